@@ -193,6 +193,7 @@ func serviceSQS(ctx context.Context, connTimeout time.Duration) {
 
 			found, err := awsC.refreshAWSCerts(*sqsCertsDirOpt, connTimeout)
 			if err != nil {
+				recordQueueHealth(live.queueType, err)
 				logger.Warn(fmt.Sprintf("unable to refresh AWS certs due to %v", err))
 				continue
 			}
@@ -200,9 +201,11 @@ func serviceSQS(ctx context.Context, connTimeout time.Duration) {
 			serverFound := make(map[string]string, len(found))
 
 			// Iterate the region for the main URLs to be used and use that as our main project key
+			var queueErr kv.Error
 			for _, credFiles := range found {
 				urls, err := runner.GetSQSProjects(strings.Split(credFiles, ","))
 				if err != nil {
+					queueErr = err
 					logger.Warn("unable to refresh AWS certs", "error", err.Error())
 					continue
 				}
@@ -210,6 +213,7 @@ func serviceSQS(ctx context.Context, connTimeout time.Duration) {
 					serverFound[k] = credFiles
 				}
 			}
+			recordQueueHealth(live.queueType, queueErr)
 
 			logger.Info("Starting AWS lifecycle", "found", serverFound)
 
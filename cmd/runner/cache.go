@@ -10,6 +10,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/leaf-ai/studio-go-runner/internal/runner"
 
@@ -24,8 +25,18 @@ var (
 	objCacheOpt    = flag.String("cache-dir", "", "An optional directory to be used as a cache for downloaded artifacts")
 	objCacheMaxOpt = flag.String("cache-size", "", "The maximum target size of the disk based download cache, for example (10Gb), must be larger than 1Gb")
 
+	objCachePeerAddrOpt       = flag.String("cache-peer-address", "", "An optional address, for example 10.0.0.5:8081, on which the cache contents are served to peer runners, a private interface should be used")
+	objCachePeersOpt          = flag.String("cache-peers", "", "A comma separated list of peer runner host:port pairs for cache sharing, dns:name:port entries are resolved to all addresses for use with headless services")
+	objCachePeerSecret        = flag.String("cache-peer-secret", "", "The secret shared by peer runners to authenticate cache sharing requests, required when cache-peer-address is used, typically supplied using the CACHE_PEER_SECRET environment variable")
+	objCacheTreesOpt          = flag.String("cache-trees", "", "An optional mode, hardlink, reflink, or bind, used to cache the unpacked contents of immutable artifacts and link them into experiments, bind requires CAP_SYS_ADMIN")
+	objCachePrefetchOpt       = flag.Int("cache-prefetch", 0, "The number of commonly used immutable artifacts per queue that are downloaded into the cache while the network is idle, 0 disables prefetching")
+	objCachePrefetchRetainOpt = flag.Duration("cache-prefetch-retain", 30*time.Minute, "The time the credentials of a finished experiment remain usable for prefetching the artifacts of its queue, 0 discards them once the experiment finishes")
+
 	// CacheActive is set to true if or when the caching system has been configured and is activated
 	CacheActive = false
+
+	// prefetcher is used to learn which artifacts are commonly used by queues when prefetching is enabled
+	prefetcher *runner.Prefetcher
 )
 
 func getCacheOptions() (dir string, size int64, err kv.Error) {
@@ -71,7 +82,26 @@ func startObjStore(ctx context.Context, removedC chan os.FileInfo, errorC chan k
 		_ = os.MkdirAll(dir, 0700)
 	}
 
+	if err = runner.SetTreeCache(*objCacheTreesOpt); err != nil {
+		return false, nil, err
+	}
+
 	triggerC, err = runner.InitObjStore(ctx, dir, size, removedC, errorC)
+	if err != nil {
+		return true, triggerC, err
+	}
+
+	// Sharing of cached artifacts with other runners is only done when asked for
+	if len(*objCachePeerAddrOpt) != 0 {
+		if err = runner.StartCachePeers(ctx, *objCachePeerAddrOpt, *objCachePeersOpt, *objCachePeerSecret, errorC); err != nil {
+			return true, triggerC, err
+		}
+	}
+
+	if *objCachePrefetchOpt > 0 {
+		prefetcher = runner.NewPrefetcher(*objCachePrefetchOpt, *objCachePrefetchRetainOpt)
+		go prefetcher.Run(ctx, time.Minute, errorC)
+	}
 
 	return true, triggerC, err
 }
@@ -0,0 +1,231 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+// This file contains the implementation of changes to the runner configuration that are
+// made while the runner is active using values found within the Kubernetes configMaps
+// also used for the runner STATE.  Keys use the same naming as the environment
+// variables for the equivalent command line options, for example MAX_MEM for max-mem.
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leaf-ai/studio-go-runner/internal/runner"
+
+	"github.com/dustin/go-humanize"
+	logxi "github.com/karlmutch/logxi/v1"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+type liveOpts struct {
+	values   map[string]string // The configMap values that have been applied, keyed using the configMap key
+	logLevel int               // The logging level in use before it was first changed by a configMap
+	sync.Mutex
+}
+
+var (
+	liveConfig = liveOpts{
+		values: map[string]string{},
+	}
+
+	// liveKeys are the configMap keys that can be changed while the runner is active, in the
+	// order they are applied
+	liveKeys = []string{"MAX_CORES", "MAX_MEM", "MAX_DISK", "QUEUE_MATCH", "QUEUE_MISMATCH", "CACHE_SIZE", "LOG_LEVEL"}
+)
+
+// liveValue returns the value currently in effect for a configMap key, being the value
+// applied from the configMap or when not present the command line option
+//
+func liveValue(key string) (value string) {
+	liveConfig.Lock()
+	defer liveConfig.Unlock()
+	return liveValueLocked(key)
+}
+
+func liveValueLocked(key string) (value string) {
+	if value, isPresent := liveConfig.values[key]; isPresent {
+		return value
+	}
+	return optValue(key)
+}
+
+// optValue returns the value of the command line option equivalent to a configMap key
+//
+func optValue(key string) (value string) {
+	if opt := flag.Lookup(strings.ToLower(strings.Replace(key, "_", "-", -1))); opt != nil {
+		return opt.Value.String()
+	}
+	return ""
+}
+
+// queueFilters returns the regular expressions used to select the queues that will be
+// considered for work
+//
+func queueFilters() (match string, mismatch string) {
+	liveConfig.Lock()
+	defer liveConfig.Unlock()
+	return liveValueLocked("QUEUE_MATCH"), liveValueLocked("QUEUE_MISMATCH")
+}
+
+// currentLogLevel derives the level of the logger from the messages it allows
+//
+func currentLogLevel() (level int) {
+	switch {
+	case logger.IsTrace():
+		return logxi.LevelTrace
+	case logger.IsDebug():
+		return logxi.LevelDebug
+	case logger.IsInfo():
+		return logxi.LevelInfo
+	case logger.IsWarn():
+		return logxi.LevelWarn
+	}
+	return logxi.LevelError
+}
+
+func setCPULimits(cores string, mem string) (err kv.Error) {
+	limitCores, errGo := strconv.ParseUint(cores, 10, 32)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	limitMem, errGo := humanize.ParseBytes(mem)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	return runner.SetCPULimits(uint(limitCores), limitMem)
+}
+
+// applyLive validates and applies a single configuration value
+//
+func applyLive(key string, value string) (err kv.Error) {
+	switch key {
+	case "MAX_CORES":
+		return setCPULimits(value, liveValue("MAX_MEM"))
+	case "MAX_MEM":
+		return setCPULimits(liveValue("MAX_CORES"), value)
+	case "MAX_DISK":
+		limitDisk, errGo := humanize.ParseBytes(value)
+		if errGo != nil {
+			return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+		}
+		avail, err := runner.SetDiskLimits(*tempOpt, limitDisk)
+		if err != nil {
+			return err
+		}
+		if avail == 0 {
+			return kv.NewError("insufficient disk storage available").With("stack", stack.Trace().TrimRuntime())
+		}
+	case "QUEUE_MATCH", "QUEUE_MISMATCH":
+		if _, errGo := regexp.Compile(value); errGo != nil {
+			return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+		}
+	case "CACHE_SIZE":
+		if !CacheActive {
+			return kv.NewError("the cache size can only be changed when the cache is in use").With("stack", stack.Trace().TrimRuntime())
+		}
+		size, errGo := humanize.ParseBytes(value)
+		if errGo != nil {
+			return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+		}
+		if size < 1024*1024*1024 {
+			return kv.NewError("cache size too small to be useful, less than 1Gb").With("stack", stack.Trace().TrimRuntime())
+		}
+		return runner.SetObjStoreMax(int64(size))
+	case "LOG_LEVEL":
+		liveConfig.Lock()
+		defer liveConfig.Unlock()
+
+		if _, isPresent := liveConfig.values[key]; !isPresent {
+			liveConfig.logLevel = currentLogLevel()
+		}
+		// An empty value is used when the key is removed from the configMap to restore
+		// the original level
+		if len(value) == 0 {
+			logger.SetLevel(liveConfig.logLevel)
+			return nil
+		}
+		level, isPresent := logxi.LevelAtoi[value]
+		if !isPresent {
+			return kv.NewError("unrecognized log level").With("stack", stack.Trace().TrimRuntime())
+		}
+		logger.SetLevel(level)
+	}
+	return nil
+}
+
+// applyK8sConfig applies configuration values obtained from the configMaps, keys that are
+// no longer present revert to their command line option values.  Values that cannot be
+// used are rejected and the previous value retained.
+//
+func applyK8sConfig(values map[string]string) (applied []string, errs []kv.Error) {
+	applied = []string{}
+	errs = []kv.Error{}
+
+	for _, key := range liveKeys {
+		value, isPresent := values[key]
+
+		liveConfig.Lock()
+		current, wasPresent := liveConfig.values[key]
+		liveConfig.Unlock()
+
+		if isPresent == wasPresent && value == current {
+			continue
+		}
+
+		// Keys removed from the configMap revert to the command line option, there being no
+		// option for the log level an empty value restores the level saved by applyLive
+		if !isPresent {
+			value = optValue(key)
+		}
+
+		if err := applyLive(key, value); err != nil {
+			errs = append(errs, err.With("key", key, "value", value))
+			continue
+		}
+
+		liveConfig.Lock()
+		if isPresent {
+			liveConfig.values[key] = value
+		} else {
+			delete(liveConfig.values, key)
+		}
+		liveConfig.Unlock()
+
+		applied = append(applied, key+"="+value)
+	}
+	return applied, errs
+}
+
+// k8sConfigUpdater applies configuration changes received from the Kubernetes configMap
+// watcher until the ctx is Done
+//
+func k8sConfigUpdater(ctx context.Context, configC <-chan runner.K8sConfigUpdate, errorC chan<- kv.Error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update := <-configC:
+			applied, errs := applyK8sConfig(update.Values)
+			if len(applied) != 0 {
+				logger.Info("configuration changed", "values", strings.Join(applied, ", "))
+			}
+			for _, err := range errs {
+				err = kv.Wrap(err, "configuration value rejected").With("stack", stack.Trace().TrimRuntime())
+				select {
+				case errorC <- err:
+				case <-time.After(2 * time.Second):
+					logger.Warn(fmt.Sprint(err))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// TestConfigReload checks that configMap values are applied, that invalid values are
+// rejected retaining the previous value, and that removed values revert to the
+// command line options
+//
+func TestConfigReload(t *testing.T) {
+	match, mismatch := queueFilters()
+
+	applied, errs := applyK8sConfig(map[string]string{"QUEUE_MATCH": "^rmq_test.*$", "QUEUE_MISMATCH": "["})
+	if len(applied) != 1 || len(errs) != 1 {
+		t.Fatal(kv.NewError("configuration not applied as expected").With("applied", applied, "errs", errs).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if newMatch, newMismatch := queueFilters(); newMatch != "^rmq_test.*$" || newMismatch != mismatch {
+		t.Fatal(kv.NewError("queue filters not changed").With("match", newMatch, "mismatch", newMismatch).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	if _, errs = applyK8sConfig(map[string]string{"QUEUE_MATCH": "^rmq_test.*$", "MAX_MEM": "lots"}); len(errs) != 1 {
+		t.Fatal(kv.NewError("invalid memory limit not rejected").With("errs", errs).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	if _, errs = applyK8sConfig(map[string]string{}); len(errs) != 0 {
+		t.Fatal(errs[0])
+	}
+	if newMatch, newMismatch := queueFilters(); newMatch != match || newMismatch != mismatch {
+		t.Fatal(kv.NewError("queue filters not reverted").With("match", newMatch, "mismatch", newMismatch).With("stack", stack.Trace().TrimRuntime()))
+	}
+}
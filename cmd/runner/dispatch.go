@@ -0,0 +1,69 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+// This file contains the options handling for running experiments as Kubernetes pods
+// rather than as processes of the runner, known as dispatching.  When dispatching the
+// runner retains the downloading of artifacts and their return along with the metadata
+// processing, the CPU, memory and GPU resources are provided by the cluster.
+
+import (
+	"strings"
+
+	"github.com/leaf-ai/studio-go-runner/internal/runner"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+func validateDispatchOpts() (errs []kv.Error) {
+	errs = []kv.Error{}
+
+	if !*k8sDispatchOpt {
+		return errs
+	}
+
+	if len(*k8sDispatchImageOpt) == 0 {
+		errs = append(errs, kv.NewError("the k8s-dispatch-image command line option must be set when k8s-dispatch is enabled").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if len(*k8sDispatchClaimOpt) == 0 {
+		errs = append(errs, kv.NewError("the k8s-dispatch-claim command line option must be set when k8s-dispatch is enabled").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if err := runner.IsAliveK8s(); err != nil {
+		errs = append(errs, kv.Wrap(err, "the k8s-dispatch command line option requires the runner to be deployed within Kubernetes").With("stack", stack.Trace().TrimRuntime()))
+	}
+	return errs
+}
+
+// dispatchOpts returns the configuration used to create experiment pods
+//
+func dispatchOpts() (opts runner.K8sPodOpts) {
+	secrets := []string{}
+	for _, secret := range strings.Split(*k8sDispatchSecretsOpt, ",") {
+		if secret = strings.TrimSpace(secret); len(secret) != 0 {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	return runner.K8sPodOpts{
+		Namespace: *cfgNamespace,
+		Image:     *k8sDispatchImageOpt,
+		Claim:     *k8sDispatchClaimOpt,
+		MountPath: *tempOpt,
+		Secrets:   secrets,
+	}
+}
+
+// dispatchResource returns the portion of an experiments resource request that has to be
+// met by the runner.  Dispatched experiments have their CPU, memory and GPUs provided by
+// the cluster leaving only the disk used for artifacts on the shared working directory.
+//
+func dispatchResource(rsc *runner.Resource) (local *runner.Resource) {
+	if !*k8sDispatchOpt || rsc == nil {
+		return rsc
+	}
+	return &runner.Resource{
+		Hdd: rsc.Hdd,
+		Ram: "0",
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+// This file contains the implementation of the live export of metrics emitted by experiments.
+// Experiments that print single line JSON documents of the form {"studioml":{"metrics":{...}}}
+// have the numeric values within the metrics block exported as prometheus gauges while they
+// run.  The series for an experiment are removed shortly after it stops so that the number of
+// series is bounded by the experiments being run, and by a limit on the number of metrics
+// each experiment can export.  When an experiment is run again, for example after being
+// returned to the queue, the new run takes over the series and the pending removal for the
+// previous run is cancelled.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	expMetricsMaxOpt      = flag.Int("experiment-metrics-max", 32, "the maximum number of metrics each experiment can export using studioml metrics JSON output, 0 disables the export")
+	expMetricsIntervalOpt = flag.Duration("experiment-metrics-interval", 15*time.Second, "the interval at which the output of experiments is checked for new metrics, 0 disables the export")
+	expMetricsLingerOpt   = flag.Duration("experiment-metrics-linger", 2*time.Minute, "the time metrics exported by an experiment remain available once it stops")
+
+	experimentMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "runner_experiment_metric",
+			Help: "Most recent value of a metric output by a running experiment.",
+		},
+		[]string{"host", "project", "experiment", "name"},
+	)
+
+	// exportedRuns holds the run of each experiment whose series are being exported, keyed
+	// on the project and experiment labels
+	exportedRuns = struct {
+		runs map[string]*experimentMetrics
+		sync.Mutex
+	}{runs: map[string]*experimentMetrics{}}
+)
+
+func init() {
+	prometheus.MustRegister(experimentMetric)
+}
+
+const (
+	// expMetricsMaxLine is the longest line of output that is examined for metrics
+	expMetricsMaxLine = 64 * 1024
+	// expMetricsMaxLabel is the longest experiment key or metric name used as a label
+	expMetricsMaxLabel = 64
+)
+
+// experimentMetrics holds the series being exported for a single experiment
+//
+type experimentMetrics struct {
+	project    string
+	experiment string
+	max        int
+	names      map[string]struct{} // The metrics that have been exported
+	linger     *time.Timer         // Removes the series once the run has stopped, nil while running
+}
+
+func newExperimentMetrics(project string, experiment string, max int) (em *experimentMetrics) {
+	return &experimentMetrics{
+		project:    project,
+		experiment: boundLabel(experiment),
+		max:        max,
+		names:      map[string]struct{}{},
+	}
+}
+
+// boundLabel shortens a label value and replaces characters that are not useful within
+// metric names and dashboard queries.  Shortened values end with a hash of the original
+// value so that values sharing a long prefix remain distinct.
+//
+func boundLabel(value string) (label string) {
+	label = strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-.:", r)) {
+			return r
+		}
+		return '_'
+	}, value)
+	if len(label) > expMetricsMaxLabel {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(value))
+		suffix := fmt.Sprintf("-%08x", h.Sum32())
+		label = label[:expMetricsMaxLabel-len(suffix)] + suffix
+	}
+	return label
+}
+
+// scrape examines a line of experiment output and exports any numeric studioml metrics it
+// contains, returning the number of values updated
+//
+func (em *experimentMetrics) scrape(line []byte) (updated int) {
+	line = bytes.TrimSpace(line)
+	if len(line) < 2 || line[0] != '{' || !bytes.Contains(line, []byte(`"studioml"`)) {
+		return 0
+	}
+
+	doc := struct {
+		StudioML struct {
+			Metrics map[string]interface{} `json:"metrics"`
+		} `json:"studioml"`
+	}{}
+	if errGo := json.Unmarshal(line, &doc); errGo != nil {
+		return 0
+	}
+
+	for name, value := range doc.StudioML.Metrics {
+		v, isNumber := value.(float64)
+		if !isNumber {
+			continue
+		}
+		name = boundLabel(name)
+		if len(name) == 0 {
+			continue
+		}
+		if _, isPresent := em.names[name]; !isPresent {
+			if len(em.names) >= em.max {
+				continue
+			}
+			em.names[name] = struct{}{}
+		}
+		experimentMetric.With(em.labels(name)).Set(v)
+		updated++
+	}
+	return updated
+}
+
+func (em *experimentMetrics) labels(name string) prometheus.Labels {
+	return prometheus.Labels{"host": host, "project": em.project, "experiment": em.experiment, "name": name}
+}
+
+// clear removes the series exported for the experiment
+//
+func (em *experimentMetrics) clear() {
+	for name := range em.names {
+		experimentMetric.Delete(em.labels(name))
+	}
+	em.names = map[string]struct{}{}
+}
+
+func (em *experimentMetrics) key() string {
+	return em.project + "/" + em.experiment
+}
+
+// start records the run as the one exporting the series of the experiment, the series of a
+// previous run that has stopped are removed immediately rather than after their linger
+//
+func (em *experimentMetrics) start() {
+	exportedRuns.Lock()
+	defer exportedRuns.Unlock()
+
+	if prev, isPresent := exportedRuns.runs[em.key()]; isPresent && prev.linger != nil {
+		prev.linger.Stop()
+		prev.clear()
+	}
+	exportedRuns.runs[em.key()] = em
+}
+
+// stop removes the series of the run once the linger has passed, unless another run of the
+// experiment has started in the meantime and taken them over
+//
+func (em *experimentMetrics) stop(linger time.Duration) {
+	exportedRuns.Lock()
+	defer exportedRuns.Unlock()
+
+	em.linger = time.AfterFunc(linger, func() {
+		exportedRuns.Lock()
+		defer exportedRuns.Unlock()
+
+		if exportedRuns.runs[em.key()] != em {
+			return
+		}
+		delete(exportedRuns.runs, em.key())
+		em.clear()
+	})
+}
+
+// watchOutputMetrics exports the metrics found within new lines of the output file at regular
+// intervals until the ctx is Done, at which point any remaining output is examined
+//
+func watchOutputMetrics(ctx context.Context, fn string, em *experimentMetrics, interval time.Duration) {
+	offset := int64(0)
+	partial := []byte{}
+
+	read := func() {
+		f, errGo := os.Open(filepath.Clean(fn))
+		if errGo != nil {
+			return
+		}
+		defer f.Close()
+
+		if _, errGo = f.Seek(offset, io.SeekStart); errGo != nil {
+			return
+		}
+		buf := make([]byte, 32*1024)
+		for {
+			n, errGo := f.Read(buf)
+			offset += int64(n)
+			partial = append(partial, buf[:n]...)
+			for {
+				end := bytes.IndexByte(partial, '\n')
+				if end < 0 {
+					break
+				}
+				em.scrape(partial[:end])
+				partial = partial[end+1:]
+			}
+			// Lines too long to hold metrics are skipped
+			if len(partial) > expMetricsMaxLine {
+				partial = []byte{}
+			}
+			if errGo != nil || n == 0 {
+				return
+			}
+		}
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			read()
+		case <-ctx.Done():
+			read()
+			if len(partial) != 0 {
+				em.scrape(partial)
+			}
+			return
+		}
+	}
+}
+
+// watchMetrics exports the metrics output by the experiment while it runs, the series are
+// removed once the experiment has stopped for the experiment-metrics-linger duration
+//
+func (p *processor) watchMetrics(ctx context.Context) {
+	if *expMetricsMaxOpt <= 0 || *expMetricsIntervalOpt <= 0 {
+		return
+	}
+	em := newExperimentMetrics(p.Request.Config.Database.ProjectId, p.Request.Experiment.Key, *expMetricsMaxOpt)
+	em.start()
+
+	watchOutputMetrics(ctx, filepath.Join(p.ExprDir, "output", "output"), em, *expMetricsIntervalOpt)
+
+	em.stop(*expMetricsLingerOpt)
+}
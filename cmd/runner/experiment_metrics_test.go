@@ -0,0 +1,116 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestExperimentMetrics checks that numeric studioml metrics output by an experiment are
+// exported, that the number of metrics for an experiment is limited, and that the series
+// are removed once cleared
+//
+func TestExperimentMetrics(t *testing.T) {
+	dir, errGo := ioutil.TempDir("", "experiment-metrics")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "output")
+	output := "epoch 1\n" +
+		`{"studioml":{"metrics":{"loss":0.75,"note":"ignored"}}}` + "\n" +
+		`{"studioml":{"metrics":{"loss":0.5,"accuracy":0.9,"f1":0.8}}}` + "\n" +
+		`{"studioml":{"metrics":{"recall":0.7}}}`
+	if errGo = ioutil.WriteFile(fn, []byte(output), 0600); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	project := "metrics-test-" + time.Now().Format("150405.000000")
+	em := newExperimentMetrics(project, "experiment/1", 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	watchOutputMetrics(ctx, fn, em, 10*time.Millisecond)
+
+	if len(em.names) != 3 {
+		t.Fatal(kv.NewError("metric limit not applied").With("names", em.names).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if _, isPresent := em.names["recall"]; isPresent {
+		t.Fatal(kv.NewError("metric over the limit exported").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	metric := &dto.Metric{}
+	gauge := experimentMetric.With(prometheus.Labels{"host": host, "project": project, "experiment": "experiment_1", "name": "loss"})
+	if errGo = gauge.Write(metric); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if metric.GetGauge().GetValue() != 0.5 {
+		t.Fatal(kv.NewError("metric not updated").With("value", metric.GetGauge().GetValue()).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	em.clear()
+	for name := range map[string]struct{}{"loss": {}, "accuracy": {}, "f1": {}} {
+		if experimentMetric.Delete(em.labels(name)) {
+			t.Fatal(kv.NewError("metric not cleared").With("name", name).With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+}
+
+// TestExperimentMetricsRerun checks that a new run of an experiment is not affected by the
+// pending removal of the series of its previous run
+//
+func TestExperimentMetricsRerun(t *testing.T) {
+	project := "metrics-rerun-" + time.Now().Format("150405.000000")
+
+	first := newExperimentMetrics(project, "experiment", 3)
+	first.start()
+	first.scrape([]byte(`{"studioml":{"metrics":{"loss":0.75,"epoch":1}}}`))
+	first.stop(50 * time.Millisecond)
+
+	second := newExperimentMetrics(project, "experiment", 3)
+	second.start()
+	if experimentMetric.Delete(first.labels("epoch")) {
+		t.Fatal(kv.NewError("previous run not cleared").With("stack", stack.Trace().TrimRuntime()))
+	}
+	second.scrape([]byte(`{"studioml":{"metrics":{"loss":0.5}}}`))
+
+	time.Sleep(200 * time.Millisecond)
+
+	metric := &dto.Metric{}
+	if errGo := experimentMetric.With(second.labels("loss")).Write(metric); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if metric.GetGauge().GetValue() != 0.5 {
+		t.Fatal(kv.NewError("new run cleared by the previous run").With("value", metric.GetGauge().GetValue()).With("stack", stack.Trace().TrimRuntime()))
+	}
+	second.clear()
+}
+
+// TestBoundLabel checks that long label values are shortened without becoming the same
+//
+func TestBoundLabel(t *testing.T) {
+	prefix := strings.Repeat("x", expMetricsMaxLabel)
+	first, second := boundLabel(prefix+"-first"), boundLabel(prefix+"-second")
+	if len(first) != expMetricsMaxLabel || len(second) != expMetricsMaxLabel {
+		t.Fatal(kv.NewError("label not shortened").With("first", first, "second", second).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if first == second {
+		t.Fatal(kv.NewError("shortened labels collide").With("label", first).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if label := boundLabel("experiment/1"); label != "experiment_1" {
+		t.Fatal(kv.NewError("short label altered").With("label", label).With("stack", stack.Trace().TrimRuntime()))
+	}
+}
@@ -31,16 +31,34 @@ func HandleMsg(ctx context.Context, qt *runner.QueueTask) (rsc *runner.Resource,
 		}
 	}()
 
+	// Trace the handling of the message, as a part of the trace of the submitter if they supplied one
+	ctx, span := startMsgSpan(ctx, qt)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	// Messages received as a drain starts are returned to the queue for other runners
+	if !openForBiz.Load() {
+		return rsc, false, kv.NewError("runner draining").With("status", "retry").With("stack", stack.Trace().TrimRuntime())
+	}
+
 	// allocate the processor and sub the subscription as
 	// the group mechanism for work coming down the
 	// pipe that is sent to the resource allocation
 	// module
-	proc, err := newProcessor(ctx, qt.Subscription, qt.Msg, qt.Credentials, qt.Wrapper)
+	proc, err := newProcessor(ctx, qt.Project+qt.Subscription, qt.Subscription, qt.Msg, qt.Credentials, qt.Wrapper)
 	if err != nil {
 		return rsc, true, err
 	}
 	defer proc.Close()
 
+	span.AddAttributes(proc.spanAttributes()...)
+
+	// Register the experiment with the lifecycle manager, should a drain deadline expire
+	// the ctx is canceled which stops the experiment and results in it being requeued
+	ctx, done := lifecycle.track(ctx, proc.Request.Config.Database.ProjectId, proc.Request.Experiment.Key)
+	defer done()
+
 	rsc = proc.Request.Experiment.Resource.Clone()
 
 	labels := prometheus.Labels{
@@ -48,7 +66,6 @@ func HandleMsg(ctx context.Context, qt *runner.QueueTask) (rsc *runner.Resource,
 		"queue_type": "rmq",
 		"queue_name": qt.Project + qt.Subscription,
 		"project":    proc.Request.Config.Database.ProjectId,
-		"experiment": proc.Request.Experiment.Key,
 	}
 
 	// Modify the prometheus metrics that track running jobs
@@ -0,0 +1,192 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+// This file contains the implementation of the liveness and readiness endpoints used by
+// Kubernetes probes.  Liveness reflects failures that restarting the runner could clear,
+// such as lost queue connectivity or encryption keys that could not be loaded.
+// Readiness additionally reflects conditions under which the runner should not be
+// considered as available for work such as being drained, or a runner that requires
+// GPUs having none left that are usable.  GPUs with ECC failures are excluded from
+// allocation and reported without affecting readiness while other GPUs remain.
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leaf-ai/studio-go-runner/internal/runner"
+	"github.com/leaf-ai/studio-go-runner/internal/types"
+
+	"github.com/jjeffery/kv" // MIT License
+)
+
+var (
+	healthQueueTimeoutOpt = flag.Duration("health-queue-timeout", 10*time.Minute, "the time a queue backend can remain unreachable before the runner reports itself as not alive")
+
+	health = runnerHealth{
+		queues: map[string]*queueHealth{},
+	}
+)
+
+type queueHealth struct {
+	lastOK  time.Time // The last time the queue server was successfully contacted, or first seen
+	lastErr kv.Error  // The outcome of the last attempt to contact the queue server
+}
+
+type runnerHealth struct {
+	queues map[string]*queueHealth // Queue backends keyed on their queue type
+	sync.Mutex
+}
+
+// healthReport is the JSON document returned by the health endpoints
+//
+type healthReport struct {
+	Status string            `json:"status"`
+	State  string            `json:"state"`
+	Checks map[string]string `json:"checks"` // Keyed on the component, "ok" or a description of the failure
+}
+
+// recordQueueHealth is called by queue backends with the outcome of each attempt to refresh
+// their queues from the queue server
+//
+func recordQueueHealth(queueType string, err kv.Error) {
+	health.Lock()
+	defer health.Unlock()
+
+	qh, isPresent := health.queues[queueType]
+	if !isPresent {
+		// Backends that have yet to succeed are given the timeout from when they are first seen
+		qh = &queueHealth{lastOK: time.Now()}
+		health.queues[queueType] = qh
+	}
+	qh.lastErr = err
+	if err == nil {
+		qh.lastOK = time.Now()
+	}
+}
+
+// livenessChecks returns the status of components whose failure should result in the runner
+// being restarted
+//
+func livenessChecks() (checks map[string]string, healthy bool) {
+	checks = map[string]string{}
+	healthy = true
+
+	fail := func(component string, msg string) {
+		checks[component] = msg
+		healthy = false
+	}
+
+	if _, err := getWrapper(); err != nil {
+		fail("keys", err.Error())
+	} else {
+		checks["keys"] = "ok"
+	}
+
+	if dir, _, _ := getCacheOptions(); len(dir) != 0 && !CacheActive {
+		fail("cache", "cache not initialized")
+	} else {
+		checks["cache"] = "ok"
+	}
+
+	health.Lock()
+	defer health.Unlock()
+
+	for queueType, qh := range health.queues {
+		component := "queue/" + queueType
+		switch {
+		case qh.lastErr == nil:
+			checks[component] = "ok"
+		case time.Since(qh.lastOK) > *healthQueueTimeoutOpt:
+			fail(component, qh.lastErr.Error())
+		default:
+			// Failures are tolerated for a while to ride out brief outages of the servers
+			checks[component] = "retrying, " + qh.lastErr.Error()
+		}
+	}
+	return checks, healthy
+}
+
+// readinessChecks returns the status of components whose failure should result in the
+// runner not being considered as available for work, which includes the liveness checks
+//
+func readinessChecks() (checks map[string]string, ready bool) {
+	checks, ready = livenessChecks()
+
+	if state := lifecycle.currentState(); state != types.K8sRunning {
+		checks["state"] = "runner is " + state.String()
+		ready = false
+	} else {
+		checks["state"] = "ok"
+	}
+
+	gpus, err := runner.GPUInventory()
+	if err != nil {
+		checks["gpu"] = err.Error()
+		return checks, false
+	}
+	msg, usable := gpuReadiness(gpus, gpuRequired())
+	checks["gpu"] = msg
+	if !usable {
+		ready = false
+	}
+
+	return checks, ready
+}
+
+// gpuRequired is used to test if the runner can only run work using its own GPUs
+//
+func gpuRequired() bool {
+	// When experiments are dispatched as pods the GPUs are provided by the cluster
+	return !*cpuOnlyOpt && !*k8sDispatchOpt && *runner.UseGPU
+}
+
+// gpuReadiness describes the state of the GPUs, cards with ECC failures are no longer
+// allocated and so only make the runner unavailable when it requires GPUs and none remain
+//
+func gpuReadiness(gpus []runner.GPUTrack, required bool) (msg string, usable bool) {
+	failed := []string{}
+	for _, gpu := range gpus {
+		if gpu.EccFailure != nil {
+			failed = append(failed, gpu.UUID)
+		}
+	}
+
+	if len(failed) == 0 {
+		return "ok", true
+	}
+
+	sort.Strings(failed)
+	msg = "ECC failures on " + strings.Join(failed, ", ")
+	if required && len(failed) == len(gpus) {
+		return msg + ", no usable GPUs remain", false
+	}
+	return msg + ", excluded from allocation", true
+}
+
+// serveHealth returns a handler that reports the outcome of a set of checks, failures
+// being returned using a 503 status so that they are seen by Kubernetes probes
+//
+func serveHealth(checks func() (map[string]string, bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		results, ok := checks()
+
+		report := healthReport{
+			Status: "ok",
+			State:  lifecycle.currentState().String(),
+			Checks: results,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			report.Status = "failed"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leaf-ai/studio-go-runner/internal/runner"
+	"github.com/leaf-ai/studio-go-runner/internal/types"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// TestHealthChecks checks that queue failures are tolerated until the health-queue-timeout
+// has passed and that a draining runner is reported as not being ready
+//
+func TestHealthChecks(t *testing.T) {
+	queueType := "health_test"
+	component := "queue/" + queueType
+
+	defer func() {
+		health.Lock()
+		delete(health.queues, queueType)
+		health.Unlock()
+
+		lifecycle.setState(types.K8sRunning)
+	}()
+
+	recordQueueHealth(queueType, kv.NewError("connection refused"))
+	if checks, _ := livenessChecks(); !strings.HasPrefix(checks[component], "retrying") {
+		t.Fatal(kv.NewError("queue failure not tolerated").With("checks", checks).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	health.Lock()
+	health.queues[queueType].lastOK = time.Now().Add(-*healthQueueTimeoutOpt - time.Minute)
+	health.Unlock()
+
+	recorder := httptest.NewRecorder()
+	serveHealth(livenessChecks)(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatal(kv.NewError("unreachable queue server not reported").With("status", recorder.Code).With("stack", stack.Trace().TrimRuntime()))
+	}
+	report := healthReport{}
+	if errGo := json.Unmarshal(recorder.Body.Bytes(), &report); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if report.Checks[component] != "connection refused" {
+		t.Fatal(kv.NewError("queue failure missing from report").With("report", report).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	recordQueueHealth(queueType, nil)
+	if checks, _ := livenessChecks(); checks[component] != "ok" {
+		t.Fatal(kv.NewError("queue recovery not seen").With("checks", checks).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	lifecycle.setState(types.K8sDrainAndSuspend)
+
+	if checks, ready := readinessChecks(); ready || checks["state"] == "ok" {
+		t.Fatal(kv.NewError("draining runner reported as ready").With("checks", checks).With("stack", stack.Trace().TrimRuntime()))
+	}
+}
+
+// TestGPUReadiness checks that a GPU with ECC failures only makes the runner unavailable
+// when the runner requires GPUs and no other GPUs remain
+//
+func TestGPUReadiness(t *testing.T) {
+	failure := kv.NewError("simulated ECC failure")
+	gpus := []runner.GPUTrack{
+		{UUID: "GPU-good"},
+		{UUID: "GPU-faulty", EccFailure: &failure},
+	}
+
+	if msg, usable := gpuReadiness(gpus[:1], true); !usable || msg != "ok" {
+		t.Fatal(kv.NewError("healthy GPU not reported as usable").With("msg", msg).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if msg, usable := gpuReadiness(gpus, true); !usable || !strings.Contains(msg, "GPU-faulty") {
+		t.Fatal(kv.NewError("failed GPU made the runner unavailable").With("msg", msg).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if msg, usable := gpuReadiness(gpus[1:], true); usable {
+		t.Fatal(kv.NewError("runner with no usable GPUs reported as ready").With("msg", msg).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if msg, usable := gpuReadiness(gpus[1:], false); !usable {
+		t.Fatal(kv.NewError("runner not requiring GPUs reported as unavailable").With("msg", msg).With("stack", stack.Trace().TrimRuntime()))
+	}
+}
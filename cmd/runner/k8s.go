@@ -24,23 +24,29 @@ func k8sStateUpdates() (l *runner.Listeners) {
 // initiateK8s runs until either ctx is Done or the listener is running successfully
 func initiateK8s(ctx context.Context, namespace string, cfgMap string, readyC chan struct{}, errorC chan kv.Error) {
 
-	// If the user did specify the k8s parameters then we need to process the k8s configs
-	if len(*cfgNamespace) == 0 || len(*cfgConfigMap) == 0 {
-		return
-	}
-
+	// The broadcaster is also used for state changes made to standalone runners and so is
+	// always present
 	listeners = runner.NewStateBroadcast(ctx, errorC)
 
+	// Start a logger for catching the state changes and printing them
+	go k8sStateLogger(ctx)
+
 	func() {
 		defer recover()
 		close(readyC)
 	}()
 
+	// If the user did specify the k8s parameters then we need to process the k8s configs
+	if len(*cfgNamespace) == 0 || len(*cfgConfigMap) == 0 {
+		return
+	}
+
 	// Watch for k8s API connectivity events that are of interest and use the errorC to surface them
 	go runner.MonitorK8s(ctx, errorC)
 
-	// Start a logger for catching the state changes and printing them
-	go k8sStateLogger(ctx)
+	// Apply configuration changes, such as resource limits, found in the config maps
+	configC := make(chan runner.K8sConfigUpdate, 1)
+	go k8sConfigUpdater(ctx, configC, errorC)
 
 	// The convention exists that the per machine configmap name is simply the hostname
 	podMap := os.Getenv("HOSTNAME")
@@ -55,7 +61,7 @@ func initiateK8s(ctx context.Context, namespace string, cfgMap string, readyC ch
 			// If k8s is specified we need to start a listener for lifecycle
 			// states being set in the k8s config map or within a config map
 			// that matches our pod/hostname
-			if err := runner.ListenK8s(ctx, *cfgNamespace, *cfgConfigMap, podMap, listeners.Master, errorC); err != nil {
+			if err := runner.ListenK8s(ctx, *cfgNamespace, *cfgConfigMap, podMap, listeners.Master, configC, errorC); err != nil {
 				logger.Warn("k8s monitoring offline", "error", err.Error())
 			}
 		case <-ctx.Done():
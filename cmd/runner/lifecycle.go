@@ -0,0 +1,234 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+// This file contains the implementation of the runner lifecycle states.  The lifecycle
+// manager tracks the experiments being run, stops new work being fetched while the runner
+// is draining, reports the progress of a drain, and once drained exits the runner when
+// the state is DrainAndTerminate.  DrainAndSuspend leaves the runner idle until the
+// Running state is seen.  An optional drain deadline results in experiments that are still
+// running when it expires being stopped, which saves their checkpoints and artifacts and
+// returns their messages to the queues they came from for another runner to use.
+
+import (
+	"context"
+	"flag"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leaf-ai/studio-go-runner/internal/runner"
+	"github.com/leaf-ai/studio-go-runner/internal/types"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+var (
+	drainDeadlineOpt = flag.Duration("drain-deadline", 0, "the time running experiments have to complete once a drain is requested, after which they are checkpointed and returned to their queues, 0 waits for experiments to complete")
+
+	lifecycle = &lifecycleManager{
+		running: map[uint64]*inflight{},
+		state:   types.K8sRunning,
+	}
+)
+
+// inflight describes an experiment that is being run
+//
+type inflight struct {
+	project    string
+	experiment string
+	started    time.Time
+	requeue    context.CancelFunc // Stops the experiment so that it can be requeued
+}
+
+type lifecycleManager struct {
+	running    map[uint64]*inflight // Running experiments
+	nextID     uint64               // The identifier for the next experiment that starts
+	state      types.K8sState       // The most recently requested runner state
+	drainStart time.Time            // When the current drain started, zero when not draining
+	requeued   bool                 // Set once the experiments have been stopped due to the drain deadline
+	lastReport time.Time            // When the drain progress was last logged
+	sync.Mutex
+}
+
+// drainStatus contains the progress of the runner toward being idle
+//
+type drainStatus struct {
+	State       string   `json:"state"`
+	Running     int      `json:"running"`
+	Experiments []string `json:"experiments,omitempty"`
+	DrainingFor string   `json:"draining_for,omitempty"`
+}
+
+// track registers an experiment as running.  The returned context is canceled should the
+// experiment need to be requeued and done must be called when the experiment stops.
+//
+func (lm *lifecycleManager) track(ctx context.Context, project string, experiment string) (trackCtx context.Context, done func()) {
+	trackCtx, cancel := context.WithCancel(ctx)
+
+	lm.Lock()
+	defer lm.Unlock()
+
+	id := lm.nextID
+	lm.nextID++
+	lm.running[id] = &inflight{
+		project:    project,
+		experiment: experiment,
+		started:    time.Now(),
+		requeue:    cancel,
+	}
+
+	return trackCtx, func() {
+		cancel()
+
+		lm.Lock()
+		delete(lm.running, id)
+		lm.Unlock()
+	}
+}
+
+// currentState returns the state the runner was most recently asked to be in
+//
+func (lm *lifecycleManager) currentState() (state types.K8sState) {
+	lm.Lock()
+	defer lm.Unlock()
+	return lm.state
+}
+
+// setState applies a requested runner state
+//
+func (lm *lifecycleManager) setState(state types.K8sState) {
+	lm.Lock()
+	defer lm.Unlock()
+
+	if state == lm.state {
+		return
+	}
+	lm.state = state
+
+	// New work is only accepted when running, the unknown state is treated as a request
+	// to stop fetching work in the same way that the queue services do
+	openForBiz.Store(state == types.K8sRunning)
+
+	switch state {
+	case types.K8sRunning:
+		if !lm.drainStart.IsZero() {
+			logger.Info("drain cancelled, accepting new work", "running", len(lm.running))
+		}
+		lm.drainStart = time.Time{}
+		lm.requeued = false
+	case types.K8sDrainAndSuspend, types.K8sDrainAndTerminate:
+		if lm.drainStart.IsZero() {
+			lm.drainStart = time.Now()
+			lm.lastReport = time.Time{}
+		}
+		logger.Info("draining", "state", state.String(), "running", len(lm.running), "deadline", drainDeadlineOpt.String())
+	}
+}
+
+// status returns the drain progress of the runner
+//
+func (lm *lifecycleManager) status() (status drainStatus) {
+	lm.Lock()
+	defer lm.Unlock()
+
+	status = drainStatus{
+		State:       lm.state.String(),
+		Running:     len(lm.running),
+		Experiments: make([]string, 0, len(lm.running)),
+	}
+	for _, exp := range lm.running {
+		status.Experiments = append(status.Experiments, exp.project+":"+exp.experiment)
+	}
+	sort.Strings(status.Experiments)
+
+	if !lm.drainStart.IsZero() {
+		status.DrainingFor = time.Since(lm.drainStart).Round(time.Second).String()
+	}
+	return status
+}
+
+// check reports on the progress of any drain, stops experiments that remain once the
+// drain deadline has expired, and indicates if the runner has drained and should now
+// terminate
+//
+func (lm *lifecycleManager) check(deadline time.Duration) (terminate bool) {
+	lm.Lock()
+	defer lm.Unlock()
+
+	if lm.drainStart.IsZero() {
+		return false
+	}
+
+	if len(lm.running) == 0 {
+		if lm.state == types.K8sDrainAndTerminate {
+			logger.Info("drained, terminating", "drain_duration", time.Since(lm.drainStart).String())
+			return true
+		}
+		if lm.lastReport.IsZero() || time.Since(lm.lastReport) > 15*time.Minute {
+			logger.Info("drained, suspended", "drain_duration", time.Since(lm.drainStart).String())
+			lm.lastReport = time.Now()
+		}
+		return false
+	}
+
+	if time.Since(lm.lastReport) > time.Minute {
+		experiments := make([]string, 0, len(lm.running))
+		for _, exp := range lm.running {
+			experiments = append(experiments, exp.project+":"+exp.experiment)
+		}
+		sort.Strings(experiments)
+		logger.Info("draining", "state", lm.state.String(), "running", len(lm.running),
+			"experiments", strings.Join(experiments, ", "), "draining_for", time.Since(lm.drainStart).String())
+		lm.lastReport = time.Now()
+	}
+
+	if deadline != 0 && !lm.requeued && time.Since(lm.drainStart) > deadline {
+		logger.Warn("drain deadline expired, returning experiments to their queues", "running", len(lm.running),
+			"deadline", deadline.String(), "stack", stack.Trace().TrimRuntime())
+		for _, exp := range lm.running {
+			exp.requeue()
+		}
+		lm.requeued = true
+	}
+	return false
+}
+
+// runLifecycle applies changes to the runner state until the ctx is Done, or the runner
+// has drained and is to terminate in which case the cancel function is used to stop the
+// runner
+//
+func runLifecycle(ctx context.Context, cancel context.CancelFunc, interval time.Duration, errorC chan<- kv.Error) {
+	l := k8sStateUpdates()
+	if l == nil {
+		reportStateErr(kv.NewError("runner state changes unavailable").With("stack", stack.Trace().TrimRuntime()), errorC)
+		return
+	}
+
+	listener := make(chan runner.K8sStateUpdate, 1)
+	id, err := l.Add(listener)
+	if err != nil {
+		reportStateErr(err, errorC)
+		return
+	}
+	defer l.Delete(id)
+
+	check := time.NewTicker(interval)
+	defer check.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update := <-listener:
+			lifecycle.setState(update.State)
+		case <-check.C:
+			if lifecycle.check(*drainDeadlineOpt) {
+				cancel()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leaf-ai/studio-go-runner/internal/types"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// TestLifecycleDrain checks that draining stops new work, that experiments still running
+// once the drain deadline has expired are stopped for requeueing, and that the runner only
+// terminates once idle when using DrainAndTerminate
+//
+func TestLifecycleDrain(t *testing.T) {
+	lm := &lifecycleManager{
+		running: map[uint64]*inflight{},
+		state:   types.K8sRunning,
+	}
+	defer lm.setState(types.K8sRunning)
+
+	ctx, done := lm.track(context.Background(), "project", "experiment")
+
+	lm.setState(types.K8sDrainAndSuspend)
+	if openForBiz.Load() {
+		t.Fatal(kv.NewError("new work accepted while draining").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if status := lm.status(); status.Running != 1 || len(status.DrainingFor) == 0 {
+		t.Fatal(kv.NewError("drain progress not reported").With("status", status).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// The experiment continues to run while the deadline has not expired
+	if lm.check(time.Hour) || ctx.Err() != nil {
+		t.Fatal(kv.NewError("experiment stopped before the drain deadline").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if lm.check(time.Nanosecond); ctx.Err() == nil {
+		t.Fatal(kv.NewError("experiment not stopped once the drain deadline expired").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// Terminate must wait for the experiment to stop
+	lm.setState(types.K8sDrainAndTerminate)
+	if lm.check(0) {
+		t.Fatal(kv.NewError("terminated with an experiment running").With("stack", stack.Trace().TrimRuntime()))
+	}
+	done()
+	if !lm.check(0) {
+		t.Fatal(kv.NewError("drained runner did not terminate").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// A suspended runner that is idle resumes accepting work when running again
+	lm.setState(types.K8sDrainAndSuspend)
+	if lm.check(0) {
+		t.Fatal(kv.NewError("suspended runner terminated").With("stack", stack.Trace().TrimRuntime()))
+	}
+	lm.setState(types.K8sRunning)
+	if !openForBiz.Load() {
+		t.Fatal(kv.NewError("work not accepted after resuming").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
@@ -50,18 +50,39 @@ var (
 	cfgNamespace = flag.String("k8s-namespace", "default", "The namespace that is being used for our configuration")
 	cfgConfigMap = flag.String("k8s-configmap", "studioml-go-runner", "The name of the Kubernetes ConfigMap where our configuration can be found")
 
+	k8sDispatchOpt        = flag.Bool("k8s-dispatch", false, "run experiments as Kubernetes pods in the k8s-namespace rather than as processes of the runner")
+	k8sDispatchImageOpt   = flag.String("k8s-dispatch-image", "", "the container image used by experiment pods when k8s-dispatch is enabled")
+	k8sDispatchClaimOpt   = flag.String("k8s-dispatch-claim", "", "the persistent volume claim, mounted by the runner at the working-dir, that is shared with experiment pods")
+	k8sDispatchSecretsOpt = flag.String("k8s-dispatch-secrets", "", "a comma separated list of Kubernetes secrets mounted into experiment pods under /etc/secrets/")
+
 	amqpURL       = flag.String("amqp-url", "", "The URI for an amqp message exchange through which StudioML is being sent")
 	queueMatch    = flag.String("queue-match", "^(rmq|sqs)_.*$", "User supplied regular expression that needs to match a queues name to be considered for work")
 	queueMismatch = flag.String("queue-mismatch", "", "User supplied regular expression that must not match a queues name to be considered for work")
+	queueDepthOpt = flag.Duration("queue-depth-interval", time.Minute, "the interval at which the number of messages waiting in queues is measured for metrics, 0 disables measurement")
 
 	tempOpt    = flag.String("working-dir", setTemp(), "the local working directory being used for runner storage, defaults to env var %TMPDIR, or /tmp")
 	debugOpt   = flag.Bool("debug", false, "leave debugging artifacts in place, can take a large amount of disk space (intended for developers only)")
 	cpuOnlyOpt = flag.Bool("cpu-only", false, "in the event no gpus are found continue with only CPU support")
 
+	gpuProviderOpt = flag.String("gpu-provider", runner.DefaultGPUProvider, "the source of gpu inventory information, nvml for NVIDIA cards, or sim for simulated cards described by the gpu-sim-file option")
+	gpuSimFileOpt  = flag.String("gpu-sim-file", "", "a JSON file describing the cards reported by the sim gpu-provider")
+	gpuShareOpt    = flag.Bool("gpu-share", false, "allows experiments requesting a single gpu with a gpuMem budget to share cards with other experiments")
+	gpuShareEnvOpt = flag.Bool("gpu-share-env", true, "when sharing gpus add environment variables asking frameworks such as TensorFlow to only take gpu memory as needed")
+
 	maxCoresOpt = flag.Uint("max-cores", 0, "maximum number of cores to be used (default 0, all cores available will be used)")
+	cpuPinOpt   = flag.Bool("cpu-pinning", false, "allocate specific cores to experiments, preferring cores on the NUMA node of their gpus, and restrict experiments to those cores")
 	maxMemOpt   = flag.String("max-mem", "0gb", "maximum amount of memory to be allocated to tasks using SI, ICE units, for example 512gb, 16gib, 1024mb, 64mib etc' (default 0, is all available RAM)")
 	maxDiskOpt  = flag.String("max-disk", "0gb", "maximum amount of local disk storage to be allocated to tasks using SI, ICE units, for example 512gb, 16gib, 1024mb, 64mib etc' (default 0, is 85% of available Disk)")
 
+	diskQuotaIntervalOpt = flag.Duration("disk-quota-interval", time.Minute, "the interval at which the local storage used by experiments is measured against their hdd allocation, 0 disables disk quotas")
+	diskQuotaWarnOpt     = flag.Float64("disk-quota-warn", 0.8, "the fraction of an experiments hdd allocation at which a warning is logged")
+	usageIntervalOpt     = flag.Duration("usage-interval", 15*time.Second, "the interval at which the resources consumed by running experiments are sampled, 0 disables usage sampling")
+
+	transferMaxOpt       = flag.Uint("transfer-max", 8, "maximum number of artifacts being concurrently downloaded, or uploaded, across all experiments on the runner")
+	transferPartsOpt     = flag.Uint("transfer-parts", 4, "number of parallel ranged requests, or upload parts, used for each large artifact")
+	transferBandwidthOpt = flag.String("transfer-bandwidth", "0", "maximum bandwidth per second shared by all artifact transfers using SI, ICE units, for example 100mb, 1gib (default 0, is unlimited)")
+	artifactParallelOpt  = flag.Uint("artifact-parallelism", 4, "maximum number of artifacts for a single experiment that will be transferred at the same time")
+
 	msgEncryptDirOpt   = flag.String("encrypt-dir", "./certs/message", "directory where secrets have been mounted into pod containers")
 	acceptClearTextOpt = flag.Bool("clear-text-messages", false, "enables clear-text messages across queues support (Associated Risk)")
 )
@@ -251,7 +272,15 @@ func watchReportingChannels(quitCtx context.Context, cancel context.CancelFunc)
 
 func validateGPUOpts() (errs []kv.Error) {
 	errs = []kv.Error{}
-	if !*cpuOnlyOpt && *runner.UseGPU {
+
+	// Failures of the default provider are expected on CPU only hardware and are dealt with below
+	if err := runner.InitGPUs(*gpuProviderOpt, *gpuSimFileOpt); err != nil && *gpuProviderOpt != runner.DefaultGPUProvider {
+		errs = append(errs, kv.Wrap(err, "the gpu-provider command line option could not be used").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	runner.SetGPUSharing(*gpuShareOpt, *gpuShareEnvOpt)
+
+	if gpuRequired() {
 		if _, free := runner.GPUSlots(); free == 0 {
 			if runner.HasCUDA() {
 
@@ -308,6 +337,21 @@ func validateResourceOpts() (errs []kv.Error) {
 	if err = runner.SetCPULimits(limitCores, limitMem); err != nil {
 		errs = append(errs, kv.Wrap(err, "the cores, or memory limits on command line option were invalid").With("stack", stack.Trace().TrimRuntime()))
 	}
+	if *diskQuotaWarnOpt <= 0 || *diskQuotaWarnOpt > 1 {
+		errs = append(errs, kv.NewError("the disk-quota-warn command line option must be a fraction greater than 0 and no more than 1").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if *diskQuotaIntervalOpt < 0 {
+		errs = append(errs, kv.NewError("the disk-quota-interval command line option must not be negative").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if *usageIntervalOpt < 0 {
+		errs = append(errs, kv.NewError("the usage-interval command line option must not be negative").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if *stateAPIOpt && len(*stateAPITokenOpt) == 0 {
+		errs = append(errs, kv.NewError("the state-api command line option requires the state-api-token option").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if err := runner.SetCPUPinning(*cpuPinOpt); err != nil {
+		errs = append(errs, kv.Wrap(err, "the cpu-pinning command line option could not be used").With("stack", stack.Trace().TrimRuntime()))
+	}
 	avail, err := runner.SetDiskLimits(*tempOpt, limitDisk)
 	if err != nil {
 		errs = append(errs, kv.Wrap(err, "the disk storage limits on command line option were invalid").With("stack", stack.Trace().TrimRuntime()))
@@ -322,6 +366,25 @@ func validateResourceOpts() (errs []kv.Error) {
 	return errs
 }
 
+func validateTransferOpts() (errs []kv.Error) {
+	errs = []kv.Error{}
+
+	bandwidth, errGo := humanize.ParseBytes(*transferBandwidthOpt)
+	if errGo != nil {
+		errs = append(errs, kv.Wrap(errGo, "the transfer-bandwidth command line option was invalid").With("stack", stack.Trace().TrimRuntime()))
+		return errs
+	}
+
+	if err := runner.SetTransferLimits(*transferMaxOpt, *transferPartsOpt, bandwidth); err != nil {
+		errs = append(errs, kv.Wrap(err, "the transfer limits on command line options were invalid").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	if *artifactParallelOpt == 0 {
+		errs = append(errs, kv.NewError("the artifact-parallelism command line option must be at least 1").With("stack", stack.Trace().TrimRuntime()))
+	}
+	return errs
+}
+
 func validateServerOpts() (errs []kv.Error) {
 	errs = []kv.Error{}
 
@@ -340,8 +403,12 @@ func validateServerOpts() (errs []kv.Error) {
 
 	errs = append(errs, validateResourceOpts()...)
 
+	errs = append(errs, validateTransferOpts()...)
+
 	errs = append(errs, validateCredsOpts()...)
 
+	errs = append(errs, validateDispatchOpts()...)
+
 	if len(*amqpURL) != 0 {
 		// Just looking for syntax errors that we should stop on if seen.  We wont
 		// save the results of the compilation itself
@@ -402,6 +469,10 @@ func EntryPoint(quitCtx context.Context, cancel context.CancelFunc, doneC chan s
 	go initiateK8s(quitCtx, *cfgNamespace, *cfgConfigMap, readyC, errorC)
 	<-readyC
 
+	// Apply runner state changes, such as draining, and terminate the runner once drained
+	// if requested
+	go runLifecycle(quitCtx, cancel, 5*time.Second, errorC)
+
 	errs = validateServerOpts()
 
 	// initialize the disk based artifact cache, after the signal handlers are in place
@@ -428,9 +499,19 @@ func EntryPoint(quitCtx context.Context, cancel context.CancelFunc, doneC chan s
 }
 
 func startServices(quitCtx context.Context, statusC chan []string, errorC chan kv.Error) {
+	// Allow standalone runners to have their state changed using signals, or a file
+	go watchStateSignals(quitCtx, errorC)
+	go watchStateFile(quitCtx, *stateFileOpt, 5*time.Second, errorC)
+
 	// Watch for GPU hardware events that are of interest
 	go runner.MonitorGPUs(quitCtx, statusC, errorC)
 
+	// Export traces of the experiments being run when a collector has been configured
+	go startTracing(quitCtx, errorC)
+
+	// Send notifications about experiments and alerts about the runner
+	go startNotifier(quitCtx, errorC)
+
 	// loops doing prometheus exports for resource consumption statistics etc
 	// on a regular basis
 	promUpdate := time.Duration(15 * time.Second)
@@ -64,6 +64,13 @@ func runPrometheus(ctx context.Context) (err kv.Error) {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
 
+	// Kubernetes liveness and readiness probes
+	mux.HandleFunc("/healthz", serveHealth(livenessChecks))
+	mux.HandleFunc("/readyz", serveHealth(readinessChecks))
+
+	// Runner state reporting, and changes for standalone runners
+	mux.HandleFunc("/state", serveState)
+
 	h := http.Server{
 		Addr:    fmt.Sprintf("%s:%d", host, prometheusPort),
 		Handler: mux,
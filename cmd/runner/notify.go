@@ -0,0 +1,162 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+// This file contains the implementation of the experiment and runner notifications.  Experimenters
+// choose the destination for notifications about their experiments using the slack_destination
+// field of their requests, which can be either a Slack channel that is sent to using the Slack
+// webhook of the runner, or the URL of a webhook on a host permitted by the runner operator.
+// Alerts about the runner itself, such as GPU ECC failures, are sent to the runner Slack webhook.
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/leaf-ai/studio-go-runner/internal/runner"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+var (
+	slackHookOpt      = flag.String("slack-hook", "", "the URL of a Slack incoming webhook, or JSON webhook, to which runner alerts are sent and that is used to send experiment notifications to Slack channels")
+	slackRoomOpt      = flag.String("slack-room", "", "the Slack channel to which runner alerts are sent, defaults to the channel of the slack-hook")
+	notifyHostsOpt    = flag.String("notify-hosts", "hooks.slack.com", "a comma separated list of the hosts that experiments can use as webhook destinations for their notifications, empty to prevent experiments using webhooks")
+	notifyIntervalOpt = flag.Duration("notify-interval", time.Second, "the minimum time between notifications sent to any one destination")
+
+	notifier = runner.NewNotifier(3)
+)
+
+// startNotifier sends queued notifications until the ctx is Done, and watches for runner
+// conditions that operators are alerted to
+//
+func startNotifier(ctx context.Context, errorC chan<- kv.Error) {
+	if len(*slackHookOpt) != 0 {
+		go watchGPUAlerts(ctx, 30*time.Second)
+	}
+	notifier.Run(ctx, *notifyIntervalOpt, errorC)
+}
+
+// experimentDestination validates the slack_destination of an experiment returning where its
+// notifications are sent, or an empty URL if it has no destination
+//
+func experimentDestination(slackDest string) (dest runner.Destination, err kv.Error) {
+	slackDest = strings.TrimSpace(slackDest)
+	if len(slackDest) == 0 {
+		return dest, nil
+	}
+
+	if !strings.HasPrefix(slackDest, "http://") && !strings.HasPrefix(slackDest, "https://") {
+		// Slack channels and users are sent to using the webhook of the runner
+		if len(*slackHookOpt) == 0 {
+			return dest, kv.NewError("slack-hook option not set").With("slack_destination", slackDest).With("stack", stack.Trace().TrimRuntime())
+		}
+		return runner.Destination{URL: *slackHookOpt, Channel: slackDest}, nil
+	}
+
+	// Only the host is included in errors as webhook URLs contain the credentials for the webhook
+	u, errGo := url.Parse(slackDest)
+	if errGo != nil {
+		return dest, kv.NewError("slack_destination is not a valid URL").With("stack", stack.Trace().TrimRuntime())
+	}
+	for _, host := range strings.Split(*notifyHostsOpt, ",") {
+		if host = strings.TrimSpace(host); len(host) != 0 && strings.EqualFold(host, u.Hostname()) {
+			return runner.Destination{URL: slackDest}, nil
+		}
+	}
+	return dest, kv.NewError("slack_destination host not permitted").With("host", u.Hostname()).With("stack", stack.Trace().TrimRuntime())
+}
+
+// notify sends a notification about the experiment to the destination chosen by the experimenter.
+// Failures include a summary of the error, the class of failure, and the tail of the experiment
+// output.
+//
+func (p *processor) notify(event string, outcome kv.Error) {
+	dest, err := experimentDestination(p.Request.Config.Runner.SlackDest)
+	if err != nil {
+		logger.Warn("notification not sent", "project_id", p.Request.Config.Database.ProjectId,
+			"experiment_id", p.Request.Experiment.Key, "event", event, "error", err.Error())
+		return
+	}
+	if len(dest.URL) == 0 {
+		return
+	}
+
+	note := runner.Notification{
+		Event:      event,
+		Host:       host,
+		Project:    p.Request.Config.Database.ProjectId,
+		Experiment: p.Request.Experiment.Key,
+	}
+	if outcome != nil {
+		note.Message = errorSummary(outcome)
+		note.Outcome = p.outcomeClass(true)
+		if tail, err := runner.ReadLast(filepath.Join(p.ExprDir, "output", "output"), 2048); err == nil {
+			note.Output = strings.TrimSpace(tail)
+		}
+	}
+
+	if err = notifier.Send(dest, note); err != nil {
+		logger.Warn("notification not sent", "error", err.Error())
+	}
+}
+
+// errorSummary returns the message of an error without the key value pairs attached to it, which
+// can include the experiment request along with its credentials and artifact keys
+//
+func errorSummary(err kv.Error) (summary string) {
+	text, _ := kv.Parse([]byte(err.Error()))
+	return string(text)
+}
+
+// alert sends a notification about the runner to the runner Slack webhook
+//
+func alert(msg string) {
+	if len(*slackHookOpt) == 0 {
+		return
+	}
+	note := runner.Notification{
+		Event:   "alert",
+		Host:    host,
+		Message: msg,
+	}
+	if err := notifier.Send(runner.Destination{URL: *slackHookOpt, Channel: *slackRoomOpt}, note); err != nil {
+		logger.Warn("alert not sent", "alert", msg, "error", err.Error())
+	}
+}
+
+// watchGPUAlerts alerts the runner operator when GPU cards are seen to have ECC failures
+//
+func watchGPUAlerts(ctx context.Context, interval time.Duration) {
+	alerted := map[string]struct{}{}
+
+	check := time.NewTicker(interval)
+	defer check.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-check.C:
+			gpus, err := runner.GPUInventory()
+			if err != nil {
+				continue
+			}
+			for _, gpu := range gpus {
+				if gpu.EccFailure == nil {
+					continue
+				}
+				if _, isPresent := alerted[gpu.UUID]; isPresent {
+					continue
+				}
+				alerted[gpu.UUID] = struct{}{}
+				alert(fmt.Sprintf("GPU %s has ECC failures and is no longer used, %s", gpu.UUID, (*gpu.EccFailure).Error()))
+			}
+		}
+	}
+}
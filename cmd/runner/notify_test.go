@@ -0,0 +1,58 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// TestExperimentDestination checks that experiments can only send notifications to Slack
+// channels using the runner webhook, or to webhooks on hosts permitted by the runner operator
+//
+func TestExperimentDestination(t *testing.T) {
+	hook, hosts := *slackHookOpt, *notifyHostsOpt
+	defer func() {
+		*slackHookOpt, *notifyHostsOpt = hook, hosts
+	}()
+
+	*slackHookOpt = ""
+	*notifyHostsOpt = "hooks.slack.com, webhooks.example.com"
+
+	if dest, err := experimentDestination(""); err != nil || len(dest.URL) != 0 {
+		t.Fatal(kv.NewError("empty destination used").With("dest", dest).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if _, err := experimentDestination("#experiments"); err == nil {
+		t.Fatal(kv.NewError("channel accepted without a slack-hook").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	*slackHookOpt = "https://hooks.slack.com/services/T0/B0/X"
+	dest, err := experimentDestination("#experiments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest.URL != *slackHookOpt || dest.Channel != "#experiments" {
+		t.Fatal(kv.NewError("channel not sent using the slack-hook").With("dest", dest).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	if _, err = experimentDestination("https://webhooks.example.com/studio"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = experimentDestination("http://169.254.169.254/latest"); err == nil {
+		t.Fatal(kv.NewError("webhook host not permitted was used").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
+
+// TestErrorSummary checks that the key value pairs of errors, which can hold the experiment
+// request and its credentials, are not included in notifications
+//
+func TestErrorSummary(t *testing.T) {
+	cause := kv.NewError("access denied").With("artifact_key", "secret")
+	err := kv.Wrap(cause, "artifact fetch failed").With("request", "credentials").With("stack", stack.Trace().TrimRuntime())
+
+	if summary := errorSummary(err); summary != "artifact fetch failed: access denied" {
+		t.Fatal(kv.NewError("error summary incorrect").With("summary", summary).With("stack", stack.Trace().TrimRuntime()))
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+// This file contains the implementation of the metrics describing where the time taken by
+// experiments is spent, and how experiments end.  Metrics are aggregated per project to
+// avoid the number of series growing with the number of experiments run.  Deliveries of
+// experiments that are returned to the queue as their resources are not available are
+// only counted as back offs, they are measured once the experiment is accepted.
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	phaseQueueWait = "queue_wait" // From the experiment being queued until the runner starts to process it
+	phaseFetch     = "fetch"      // Retrieval of the experiment artifacts
+	phaseBuild     = "build"      // Creation of the environment for the experiment
+	phaseRun       = "run"        // The run of the experiment script
+	phaseUpload    = "upload"     // The return of the experiment artifacts once stopped
+
+	outcomeSuccess   = "success"
+	outcomeFetch     = "fetch_failed" // One or more artifacts could not be retrieved
+	outcomeBuild     = "build_failed" // The environment for the experiment could not be created
+	outcomeExpired   = "expired"      // The lifetime of the experiment had passed before it could run
+	outcomeTimeout   = "timeout"      // The experiment ran for longer than its maximum duration
+	outcomeDiskQuota = "disk_quota"   // The experiment used more than the disk space allocated to it
+	outcomeCancelled = "cancelled"    // The experiment was stopped by the runner, for example by a drain
+	outcomeRun       = "run_failed"   // The experiment script failed
+)
+
+var (
+	phaseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "runner_project_phase_seconds",
+			Help:    "Time taken by each phase of processing the experiments of a project.",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+		},
+		[]string{"host", "project", "phase"},
+	)
+	experimentOutcomes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_project_outcomes",
+			Help: "Number of experiments that have stopped, by how they stopped.",
+		},
+		[]string{"host", "project", "outcome"},
+	)
+	allocationBackoffs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_project_allocation_backoffs",
+			Help: "Number of experiment deliveries returned to the queue as their resources were not available.",
+		},
+		[]string{"host", "project"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(phaseDuration)
+	prometheus.MustRegister(experimentOutcomes)
+	prometheus.MustRegister(allocationBackoffs)
+}
+
+// observePhase records the duration of a phase of processing the experiment that started at
+// the specified time
+//
+func (p *processor) observePhase(phase string, started time.Time) {
+	phaseDuration.With(prometheus.Labels{"host": host, "project": p.Request.Config.Database.ProjectId, "phase": phase}).
+		Observe(time.Since(started).Seconds())
+}
+
+// observeQueueWait records the time the experiment spent waiting to be processed, experiments
+// without the time they were queued are ignored
+//
+func (p *processor) observeQueueWait() {
+	if p.Request.Experiment.TimeAdded < 10.0 {
+		return
+	}
+	secs := int64(p.Request.Experiment.TimeAdded)
+	queued := time.Unix(secs, int64((p.Request.Experiment.TimeAdded-float64(secs))*float64(time.Second)))
+	if queued.After(time.Now()) {
+		return
+	}
+	p.observePhase(phaseQueueWait, queued)
+}
+
+// backedOff counts a delivery of the experiment that was returned to the queue as the
+// resources it needs were not available
+//
+func (p *processor) backedOff() {
+	allocationBackoffs.With(prometheus.Labels{"host": host, "project": p.Request.Config.Database.ProjectId}).Inc()
+}
+
+// failed records the class of failure that stopped the experiment, only the first failure is
+// retained as later failures are typically a consequence of it
+//
+func (p *processor) failed(outcome string) {
+	if len(p.outcome) == 0 {
+		p.outcome = outcome
+	}
+}
+
+// outcomeClass returns the recorded class of failure for the experiment, or success if it did
+// not fail
+//
+func (p *processor) outcomeClass(failed bool) (outcome string) {
+	if !failed {
+		return outcomeSuccess
+	}
+	if len(p.outcome) == 0 {
+		return outcomeRun
+	}
+	return p.outcome
+}
+
+// recordOutcome counts the experiment as having stopped with the recorded failure, or with
+// success if it did not fail
+//
+func (p *processor) recordOutcome(failed bool) {
+	experimentOutcomes.With(prometheus.Labels{"host": host, "project": p.Request.Config.Database.ProjectId, "outcome": p.outcomeClass(failed)}).Inc()
+}
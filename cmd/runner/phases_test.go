@@ -0,0 +1,65 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leaf-ai/studio-go-runner/internal/runner"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestPhaseMetrics checks that the queue wait is measured from the time the experiment was
+// queued, that experiments are counted using the first failure they encountered, and that
+// back offs are not counted as outcomes
+//
+func TestPhaseMetrics(t *testing.T) {
+	project := "phases-test-" + time.Now().Format("150405.000000")
+
+	p := &processor{Request: &runner.Request{}}
+	p.Request.Config.Database.ProjectId = project
+	p.Request.Experiment.TimeAdded = float64(time.Now().Add(-time.Minute).Unix())
+
+	p.observeQueueWait()
+
+	metric := &dto.Metric{}
+	observer := phaseDuration.With(prometheus.Labels{"host": host, "project": project, "phase": phaseQueueWait})
+	if errGo := observer.(prometheus.Metric).Write(metric); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if metric.GetHistogram().GetSampleCount() != 1 || metric.GetHistogram().GetSampleSum() < 59 {
+		t.Fatal(kv.NewError("queue wait not recorded").With("histogram", metric.GetHistogram().String()).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	p.failed(outcomeFetch)
+	p.failed(outcomeRun)
+	p.recordOutcome(true)
+
+	(&processor{Request: p.Request}).recordOutcome(false)
+
+	p.backedOff()
+	metric = &dto.Metric{}
+	if errGo := allocationBackoffs.With(prometheus.Labels{"host": host, "project": project}).Write(metric); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if metric.GetCounter().GetValue() != 1 {
+		t.Fatal(kv.NewError("back off miscounted").With("count", metric.GetCounter().GetValue()).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	for outcome, expected := range map[string]float64{outcomeFetch: 1, outcomeRun: 0, outcomeSuccess: 1} {
+		metric := &dto.Metric{}
+		counter := experimentOutcomes.With(prometheus.Labels{"host": host, "project": project, "outcome": outcome})
+		if errGo := counter.Write(metric); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if metric.GetCounter().GetValue() != expected {
+			t.Fatal(kv.NewError("outcome miscounted").With("outcome", outcome).With("count", metric.GetCounter().GetValue()).With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+}
@@ -37,6 +37,8 @@ import (
 
 	"github.com/go-stack/stack"
 	"github.com/jjeffery/kv" // MIT License
+
+	"go.opencensus.io/trace"
 )
 
 type processor struct {
@@ -50,6 +52,13 @@ type processor struct {
 	Artifacts  *runner.ArtifactCache
 	Executor   Executor
 	ready      chan bool // Used by the processor to indicate it has released resources or state has changed
+
+	artifactKey *[32]byte            // The experimenter supplied data key for encrypted artifacts, if any
+	usage       *runner.UsageTracker // The resources consumed by the experiment while being processed
+	queue       string               // The queue the experiment was received from, used when tracing
+	runnerLog   string               // The file into which messages logged about the experiment are captured
+	outcome     string               // The class of failure that stopped the experiment, empty if none
+	accessionID string               // Identifies this attempt at running the experiment, used when tracing
 }
 
 type tempSafe struct {
@@ -114,6 +123,12 @@ type Executor interface {
 	// Run will execute the worker task used by the experiment
 	Run(ctx context.Context, refresh map[string]runner.Artifact) (err kv.Error)
 
+	// WorkDirs returns any directories outside of the experiment directory used by the running task
+	WorkDirs() (dirs []string)
+
+	// Pid returns the process id of the running task, or 0 if it is not running
+	Pid() (pid int)
+
 	// Close can be used to tidy up after an experiment has completed
 	Close() (err kv.Error)
 }
@@ -140,7 +155,7 @@ func makeCWD() (temp string, err kv.Error) {
 
 // newProcessor will create a new working directory
 //
-func newProcessor(ctx context.Context, group string, msg []byte, creds string, wrapper *runner.Wrapper) (proc *processor, err kv.Error) {
+func newProcessor(ctx context.Context, queue string, group string, msg []byte, creds string, wrapper *runner.Wrapper) (proc *processor, err kv.Error) {
 
 	// When a processor is initialized make sure that the logger is enabled first time through
 	//
@@ -161,6 +176,7 @@ func newProcessor(ctx context.Context, group string, msg []byte, creds string, w
 		Group:   group,
 		Creds:   creds,
 		ready:   make(chan bool),
+		queue:   queue,
 	}
 
 	// Check to see if we have an encrypted or signed request
@@ -181,7 +197,13 @@ func newProcessor(ctx context.Context, group string, msg []byte, creds string, w
 			return nil, err
 		}
 		// Decrypt, using the wrapper, the master request structure and assign it to our task
-		if p.Request, err = w.Request(envelope); err != nil {
+		_, span := p.startSpan(ctx, "runner/decrypt")
+		p.Request, err = w.Request(envelope)
+		if err == nil {
+			span.AddAttributes(p.spanAttributes()...)
+		}
+		endSpan(span, err)
+		if err != nil {
 			return nil, err
 		}
 	} else {
@@ -193,6 +215,23 @@ func newProcessor(ctx context.Context, group string, msg []byte, creds string, w
 			return nil, err
 		}
 	}
+	// Data keys for encrypted artifacts must only ever arrive inside encrypted payloads
+	if len(p.Request.Experiment.ArtifactKey) != 0 {
+		if isEnvelope, _ := runner.IsEnvelope(msg); !isEnvelope {
+			return nil, kv.NewError("artifact keys are only accepted within encrypted messages").With("stack", stack.Trace().TrimRuntime())
+		}
+		key, err := runner.ParseArtifactKey(p.Request.Experiment.ArtifactKey)
+		if err != nil {
+			return nil, err
+		}
+		p.artifactKey = &key
+	}
+	for group, artifact := range p.Request.Experiment.Artifacts {
+		if err = artifact.Validate(); err != nil {
+			return nil, err.With("group", group)
+		}
+	}
+
 	// Recheck the alloc using the encrtyped resource description
 	if _, err = allocResource(&p.Request.Experiment.Resource, true); err != nil {
 		return nil, err
@@ -222,10 +261,20 @@ func newProcessor(ctx context.Context, group string, msg []byte, creds string, w
 
 	switch mode {
 	case ExecPythonVEnv:
+		if *k8sDispatchOpt {
+			if p.Executor, err = runner.NewK8sPod(p.Request, p.ExprDir, dispatchOpts()); err != nil {
+				return nil, err
+			}
+			break
+		}
 		if p.Executor, err = runner.NewVirtualEnv(p.Request, p.ExprDir); err != nil {
 			return nil, err
 		}
 	case ExecSingularity:
+		if *k8sDispatchOpt {
+			return nil, kv.NewError("singularity experiments cannot be run as Kubernetes pods").With("stack", stack.Trace().TrimRuntime()).
+				With("project", p.Request.Config.Database.ProjectId).With("experiment", p.Request.Experiment.Key)
+		}
 		if p.Executor, err = runner.NewSingularity(p.Request, p.ExprDir); err != nil {
 			return nil, err
 		}
@@ -250,18 +299,79 @@ const (
 // was used by the studioml work
 //
 func (p *processor) Close() (err error) {
-	if *debugOpt || 0 == len(p.ExprDir) {
+	if 0 == len(p.ExprDir) {
+		return nil
+	}
+
+	// Trees bound into the work directory are released even when it is retained for debugging
+	p.releaseTrees()
+	if *debugOpt {
 		return nil
 	}
 
+	if len(p.runnerLog) != 0 {
+		os.Remove(p.runnerLog)
+	}
 	return os.RemoveAll(p.ExprDir)
 }
 
+// releaseTrees removes the cached trees bound into the work directory so that removing the
+// directory leaves the cache intact
+//
+func (p *processor) releaseTrees() {
+	if err := runner.ReleaseTrees(p.ExprDir); err != nil {
+		logger.Warn("cached trees not released", "dir", p.ExprDir, "error", err.Error())
+	}
+}
+
+// inParallel will invoke the supplied function for each of the artifact groups using no more
+// than the experiment artifact parallelism limit of concurrent invocations.  The first error
+// encountered is returned once all invocations have completed.
+//
+func inParallel(groups []string, doIt func(group string) (err kv.Error)) (err kv.Error) {
+
+	limit := *artifactParallelOpt
+	if limit == 0 {
+		limit = 1
+	}
+	slots := make(chan struct{}, limit)
+
+	errLock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	for _, group := range groups {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(group string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if errDo := doIt(group); errDo != nil {
+				errLock.Lock()
+				if err == nil {
+					err = errDo
+				}
+				errLock.Unlock()
+			}
+		}(group)
+	}
+	wg.Wait()
+
+	return err
+}
+
 // fetchAll is used to retrieve from the storage system employed by studioml any and all available
-// artifacts and to unpack them into the experiment directory
+// artifacts and to unpack them into the experiment directory.  Artifacts are retrieved in parallel
+// with the total number of transfers across all experiments being limited by the runner.
 //
 func (p *processor) fetchAll(ctx context.Context) (err kv.Error) {
 
+	if p.usage != nil {
+		ctx = runner.WithUsageTracker(ctx, p.usage)
+	}
+
+	groups := make([]string, 0, len(p.Request.Experiment.Artifacts))
 	for group, artifact := range p.Request.Experiment.Artifacts {
 
 		// Artifacts that have no qualified location will be ignored
@@ -273,6 +383,26 @@ func (p *processor) fetchAll(ctx context.Context) (err kv.Error) {
 		if group == "_singularity" {
 			continue
 		}
+		groups = append(groups, group)
+	}
+
+	if p.artifactKey != nil {
+		ctx = runner.WithArtifactKey(ctx, *p.artifactKey)
+	}
+
+	// Limit the size of the artifacts being unpacked to the disk space allocated to the experiment
+	if hdd, errGo := humanize.ParseBytes(p.Request.Experiment.Resource.Hdd); errGo == nil && hdd != 0 {
+		ctx = runner.WithExtractLimits(ctx, runner.NewExtractLimits(hdd))
+	}
+
+	return inParallel(groups, func(group string) (err kv.Error) {
+		artifact := p.Request.Experiment.Artifacts[group]
+
+		ctx, span := p.startSpan(ctx, "runner/fetch")
+		span.AddAttributes(trace.StringAttribute("artifact", group))
+		defer func() {
+			endSpan(span, err)
+		}()
 
 		// Extract all available artifacts into subdirectories of the main experiment directory.
 		//
@@ -281,12 +411,17 @@ func (p *processor) fetchAll(ctx context.Context) (err kv.Error) {
 		//
 		warns, err := artifactCache.Fetch(ctx, artifact.Clone(), p.Request.Config.Database.ProjectId, group, p.Creds, p.ExprEnvs, p.ExprDir)
 
+		// Learn which artifacts the queue commonly uses so that they can be kept in the cache
+		if err == nil && prefetcher != nil {
+			prefetcher.Observe(p.Group, &artifact, p.Request.Config.Database.ProjectId, p.ExprDir, p.Creds, p.ExprEnvs)
+		}
+
 		if err != nil {
 			msg := "artifact fetch failed"
 			msgDetail := []interface{}{
 				"group", group,
-				"project", p.Request.Config.Database.ProjectId,
-				"Experiment", p.Request.Experiment.Key,
+				"project_id", p.Request.Config.Database.ProjectId,
+				"experiment_id", p.Request.Experiment.Key,
 				"stack", stack.Trace().TrimRuntime(),
 				"err", err,
 			}
@@ -310,8 +445,8 @@ func (p *processor) fetchAll(ctx context.Context) (err kv.Error) {
 				return err.With(msgDetail...)
 			}
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 // copyToMetaData is used to copy a file to the meta data area using the file naming semantics
@@ -439,10 +574,57 @@ func (p *processor) updateMetaData(group string, artifact runner.Artifact, acces
 	}
 }
 
+// captureLog starts capturing the messages logged about the experiment into a file, the returned
+// function stops the capture.  The file is kept outside of the experiment directory so that it
+// is not visible to the experiment, including experiments run as Kubernetes pods which mount
+// the experiment directory, until it is copied into the metadata being uploaded.
+//
+func (p *processor) captureLog(accessionID string) (stop func()) {
+	logDir := filepath.Join(p.RootDir, "logs")
+	_ = os.MkdirAll(logDir, 0700)
+
+	fn := filepath.Join(logDir, p.ExprSubDir+"-runner-host-"+accessionID+".log")
+	f, errGo := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if errGo != nil {
+		logger.Warn("runner log not captured", "project_id", p.Request.Config.Database.ProjectId,
+			"experiment_id", p.Request.Experiment.Key, "file", fn, "error", errGo.Error())
+		return func() {}
+	}
+	p.runnerLog = fn
+
+	stopLogger := logger.Capture("experiment_id", p.Request.Experiment.Key, f)
+	return func() {
+		stopLogger()
+		f.Close()
+	}
+}
+
+// writeRunnerLog copies the messages logged about the experiment so far into the metadata
+// directory using a file name that shares the accession ID with the output files
+//
+func (p *processor) writeRunnerLog(accessionID string) (err kv.Error) {
+	if len(p.runnerLog) == 0 || len(accessionID) == 0 {
+		return nil
+	}
+
+	metaDir := filepath.Join(p.ExprDir, "_metadata")
+	if errGo := os.MkdirAll(metaDir, 0700); errGo != nil {
+		return kv.Wrap(errGo).With("dir", metaDir, "stack", stack.Trace().TrimRuntime())
+	}
+	return p.copyToMetaData(p.runnerLog, filepath.Join(metaDir, "runner-host-"+accessionID+".log"), "")
+}
+
 // returnOne is used to upload a single artifact to the data store specified by the experimenter
 //
 func (p *processor) returnOne(ctx context.Context, group string, artifact runner.Artifact, accessionID string) (uploaded bool, warns []kv.Error, err kv.Error) {
 
+	ctx, span := p.startSpan(ctx, "runner/upload")
+	span.AddAttributes(trace.StringAttribute("artifact", group))
+	defer func() {
+		span.AddAttributes(trace.BoolAttribute("uploaded", uploaded))
+		endSpan(span, err)
+	}()
+
 	// Meta data is specialized
 	if len(accessionID) != 0 {
 		switch group {
@@ -451,6 +633,15 @@ func (p *processor) returnOne(ctx context.Context, group string, artifact runner
 				logger.Warn("output artifact could not be used for metadata", "project_id", p.Request.Config.Database.ProjectId,
 					"experiment_id", p.Request.Experiment.Key, "error", err.Error())
 			}
+		case "_metadata":
+			if err = p.writeUsage(accessionID); err != nil {
+				logger.Warn("usage could not be saved to metadata", "project_id", p.Request.Config.Database.ProjectId,
+					"experiment_id", p.Request.Experiment.Key, "error", err.Error())
+			}
+			if err = p.writeRunnerLog(accessionID); err != nil {
+				logger.Warn("runner log could not be saved to metadata", "project_id", p.Request.Config.Database.ProjectId,
+					"experiment_id", p.Request.Experiment.Key, "error", err.Error())
+			}
 		}
 	}
 
@@ -462,6 +653,13 @@ func (p *processor) returnOne(ctx context.Context, group string, artifact runner
 		return false, warns, nil
 	}
 
+	if p.artifactKey != nil {
+		ctx = runner.WithArtifactKey(ctx, *p.artifactKey)
+	}
+	if p.usage != nil {
+		ctx = runner.WithUsageTracker(ctx, p.usage)
+	}
+
 	uploaded, warns, err = artifactCache.Restore(ctx, &artifact, p.Request.Config.Database.ProjectId, group, p.Creds, p.ExprEnvs, p.ExprDir)
 	if err != nil {
 		logger.Warn("artifact not returned", "project_id", p.Request.Config.Database.ProjectId,
@@ -475,7 +673,11 @@ func (p *processor) returnOne(ctx context.Context, group string, artifact runner
 //
 func (p *processor) returnAll(ctx context.Context, accessionID string) {
 
+	ctx, span := p.startSpan(ctx, "runner/return")
+	defer span.End()
+
 	returned := make([]string, 0, len(p.Request.Experiment.Artifacts))
+	returnedLock := sync.Mutex{}
 
 	// Accessioning can modify the system artifacts and so the order we traverse
 	// is important, we want the _metadata artifact after the _output
@@ -483,32 +685,59 @@ func (p *processor) returnAll(ctx context.Context, accessionID string) {
 	// before lowercase letters
 	//
 	keys := make([]string, 0, len(p.Request.Experiment.Artifacts))
-	for group := range p.Request.Experiment.Artifacts {
-		keys = append(keys, group)
+	for group, artifact := range p.Request.Experiment.Artifacts {
+		if artifact.Mutable {
+			keys = append(keys, group)
+		}
 	}
 	sort.Strings(keys)
 
-	for _, group := range keys {
-		if artifact, isPresent := p.Request.Experiment.Artifacts[group]; isPresent {
-			if artifact.Mutable {
-				if _, warns, err := p.returnOne(ctx, group, artifact, accessionID); err != nil {
-					logger.Debug("return error", "project_id", p.Request.Config.Database.ProjectId, "group", group, "error", err.Error())
-					for _, warn := range warns {
-						logger.Debug("return warning", "project_id", p.Request.Config.Database.ProjectId, "group", group, "warning", warn.Error())
-					}
-				} else {
-					returned = append(returned, group)
-				}
+	p.returnGroups(keys, func(group string) (err kv.Error) {
+		artifact := p.Request.Experiment.Artifacts[group]
+		_, warns, err := p.returnOne(ctx, group, artifact, accessionID)
+		if err != nil {
+			logger.Debug("return error", "project_id", p.Request.Config.Database.ProjectId, "experiment_id", p.Request.Experiment.Key,
+				"group", group, "error", err.Error())
+			for _, warn := range warns {
+				logger.Debug("return warning", "project_id", p.Request.Config.Database.ProjectId, "experiment_id", p.Request.Experiment.Key,
+					"group", group, "warning", warn.Error())
 			}
+			return err
 		}
-	}
+		returnedLock.Lock()
+		returned = append(returned, group)
+		returnedLock.Unlock()
+		return nil
+	})
 
 	if len(returned) != 0 {
-		logger.Info("project returned", "project_id", p.Request.Config.Database.ProjectId, "result", strings.Join(returned, ", "))
+		sort.Strings(returned)
+		logger.Info("project returned", "project_id", p.Request.Config.Database.ProjectId, "experiment_id", p.Request.Experiment.Key,
+			"result", strings.Join(returned, ", "))
+	}
+}
+
+// returnGroups uploads the artifact groups in parallel with the exception of the _metadata
+// artifact which is returned only after all others are complete as returning the other artifacts
+// can add to the metadata
+//
+func (p *processor) returnGroups(groups []string, doIt func(group string) (err kv.Error)) {
+	parallel := make([]string, 0, len(groups))
+	last := []string{}
+	for _, group := range groups {
+		if group == "_metadata" {
+			last = append(last, group)
+			continue
+		}
+		parallel = append(parallel, group)
 	}
+
+	inParallel(parallel, doIt)
+	inParallel(last, doIt)
 }
 
 func allocResource(rsc *runner.Resource, live bool) (alloc *runner.Allocated, err kv.Error) {
+	rsc = dispatchResource(rsc)
 	if rsc == nil {
 		return nil, kv.NewError("resource missing").With("stack", stack.Trace().TrimRuntime())
 	}
@@ -582,12 +811,39 @@ func (p *processor) Process(ctx context.Context) (ack bool, err kv.Error) {
 	host, _ := os.Hostname()
 	accessionID := host + "-" + base62.EncodeInt64(time.Now().Unix())
 
+	p.accessionID = accessionID
+	trace.FromContext(ctx).AddAttributes(trace.StringAttribute("accession_id", accessionID))
+
+	// Capture the messages logged about the experiment so that they can be returned within
+	// the experiment metadata
+	stopCapture := p.captureLog(accessionID)
+	defer stopCapture()
+
+	// Credentials lent to the prefetcher expire once the experiment is no longer active
+	if prefetcher != nil {
+		defer prefetcher.Release(p.ExprDir)
+	}
+
 	// Call the allocation function to get access to resources and get back
 	// the allocation we received
+	_, span := p.startSpan(ctx, "runner/allocate")
 	alloc, err := p.allocate()
+	endSpan(span, err)
 	if err != nil {
+		// The message will be redelivered and so this is not the end of the experiment
+		p.backedOff()
+		logger.Debug("allocation failed", "project_id", p.Request.Config.Database.ProjectId, "experiment_id", p.Request.Experiment.Key,
+			"resources", p.Request.Experiment.Resource, "error", err.Error())
 		return false, kv.Wrap(err, "allocation fail backing off").With("stack", stack.Trace().TrimRuntime())
 	}
+	logger.Debug("allocated", "project_id", p.Request.Config.Database.ProjectId, "experiment_id", p.Request.Experiment.Key,
+		"resources", p.Request.Experiment.Resource)
+
+	// The experiment has been accepted and so is measured from here
+	p.observeQueueWait()
+	defer func() {
+		p.recordOutcome(err != nil)
+	}()
 
 	// Setup a function to release resources that have been allocated
 	defer p.deallocate(alloc)
@@ -596,7 +852,8 @@ func (p *processor) Process(ctx context.Context) (ack bool, err kv.Error) {
 	//
 	defer func() {
 		if r := recover(); r != nil {
-			logger.Warn(fmt.Sprintf("panic running studioml script %#v, %s", r, string(debug.Stack())))
+			logger.Warn(fmt.Sprintf("panic running studioml script %#v, %s", r, string(debug.Stack())),
+				"project_id", p.Request.Config.Database.ProjectId, "experiment_id", p.Request.Experiment.Key)
 		}
 	}()
 
@@ -767,6 +1024,11 @@ func (p *processor) applyEnv(alloc *runner.Allocated) {
 			}
 		}
 	}
+	if alloc.CPU != nil {
+		for env, cpuVar := range alloc.CPU.Env {
+			p.ExprEnvs[env] = cpuVar
+		}
+	}
 }
 
 func (p *processor) calcTimeLimit() (maxDuration time.Duration) {
@@ -841,9 +1103,20 @@ func (p *processor) checkpointStart(ctx context.Context, accessionID string, ref
 // experiment
 func (p *processor) checkpointArtifacts(ctx context.Context, accessionID string, refresh map[string]runner.Artifact) {
 	logger.Info("checkpointArtifacts", "project_id", p.Request.Config.Database.ProjectId, "experiment_id", p.Request.Experiment.Key)
-	for group, artifact := range refresh {
-		p.returnOne(ctx, group, artifact, accessionID)
+
+	ctx, span := p.startSpan(ctx, "runner/checkpoint")
+	defer span.End()
+
+	groups := make([]string, 0, len(refresh))
+	for group := range refresh {
+		groups = append(groups, group)
 	}
+	sort.Strings(groups)
+
+	p.returnGroups(groups, func(group string) (err kv.Error) {
+		_, _, err = p.returnOne(ctx, group, refresh[group], accessionID)
+		return err
+	})
 }
 
 // checkpointer is designed to take items such as progress tracking artifacts and on a regular basis
@@ -863,7 +1136,7 @@ func (p *processor) checkpointer(ctx context.Context, saveInterval time.Duration
 			// The context that is supplied by the caller relates to the experiment itself, however what we dont want
 			// to happen is for the uploading of artifacts to be terminated until they complete so we build a new context
 			// for the uploads and use the ctx supplied as a lifecycle indicator
-			uploadCtx, uploadCancel := context.WithTimeout(context.Background(), saveTimeout)
+			uploadCtx, uploadCancel := context.WithTimeout(detachSpan(ctx), saveTimeout)
 
 			// Here a regular checkpoint of the artifacts is being done.  Before doing this
 			// we should copy meta data related files from the output directory and other
@@ -875,7 +1148,7 @@ func (p *processor) checkpointer(ctx context.Context, saveInterval time.Duration
 			// The context that is supplied by the caller relates to the experiment itself, however what we dont want
 			// to happen is for the uploading of artifacts to be terminated until they complete so we build a new context
 			// for the uploads and use the ctx supplied as a lifecycle indicator
-			uploadCtx, uploadCancel := context.WithTimeout(context.Background(), saveTimeout)
+			uploadCtx, uploadCancel := context.WithTimeout(detachSpan(ctx), saveTimeout)
 			defer uploadCancel()
 
 			// The context can be canncelled externally in which case
@@ -890,7 +1163,12 @@ func (p *processor) checkpointer(ctx context.Context, saveInterval time.Duration
 // runScript is used to start a script execution along with an artifact checkpointer that both remain running until the
 // experiment is done.  refresh contains a list of the artifacts that require checkpointing
 //
-func (p *processor) runScript(ctx context.Context, accessionID string, refresh map[string]runner.Artifact, refreshTimeout time.Duration) (err kv.Error) {
+func (p *processor) runScript(ctx context.Context, alloc *runner.Allocated, accessionID string, refresh map[string]runner.Artifact, refreshTimeout time.Duration) (err kv.Error) {
+
+	ctx, span := p.startSpan(ctx, "runner/run")
+	defer func() {
+		endSpan(span, err)
+	}()
 
 	// Create a context that can be cancelled within the runScript so that the checkpointer
 	// and the executor are aligned on the termination of a job either from the base
@@ -907,6 +1185,32 @@ func (p *processor) runScript(ctx context.Context, accessionID string, refresh m
 	//
 	doneC := p.checkpointStart(runCtx, accessionID, refresh, refreshTimeout)
 
+	// Start watching the storage used by the experiment, if the disk allocation is exceeded
+	// the experiment is terminated which also causes the checkpointer to do a final save
+	quotaC := make(chan kv.Error, 1)
+	go func() {
+		if errQuota := p.watchDisk(runCtx, alloc); errQuota != nil {
+			quotaC <- errQuota
+			runCancel()
+		}
+	}()
+
+	// Export the metrics output by the experiment while it runs
+	go p.watchMetrics(runCtx)
+
+	// Sample the resources being consumed by the experiment while it runs
+	if p.usage != nil && *usageIntervalOpt != 0 {
+		pid := p.Executor.Pid
+		dirs := func() []string {
+			return append([]string{p.ExprDir}, p.Executor.WorkDirs()...)
+		}
+		gpus := runner.GPUAllocations{}
+		if alloc != nil {
+			gpus = alloc.GPU
+		}
+		go p.usage.Run(runCtx, *usageIntervalOpt, pid, gpus, dirs)
+	}
+
 	// Blocking call to run the process that uses the ctx for timeouts etc
 	err = p.Executor.Run(runCtx, refresh)
 
@@ -918,6 +1222,38 @@ func (p *processor) runScript(ctx context.Context, accessionID string, refresh m
 	// and artifact uploads
 	<-doneC
 
+	select {
+	case errQuota := <-quotaC:
+		p.failed(outcomeDiskQuota)
+		return errQuota
+	default:
+	}
+
+	return err
+}
+
+// watchDisk measures the storage used by the experiment until the context is cancelled, returning
+// an error if the disk space allocated to the experiment has been exceeded
+//
+func (p *processor) watchDisk(ctx context.Context, alloc *runner.Allocated) (err kv.Error) {
+	if alloc == nil || alloc.Disk == nil {
+		return nil
+	}
+	dirs := func() []string {
+		return append([]string{p.ExprDir}, p.Executor.WorkDirs()...)
+	}
+	warn := func(usage uint64) {
+		logger.Warn("approaching disk quota",
+			"project_id", p.Request.Config.Database.ProjectId,
+			"experiment_id", p.Request.Experiment.Key,
+			"usage", humanize.Bytes(usage),
+			"quota", humanize.Bytes(alloc.Disk.Size()))
+	}
+
+	if err = runner.WatchDiskQuota(ctx, alloc.Disk.Size(), *diskQuotaWarnOpt, *diskQuotaIntervalOpt, dirs, warn); err != nil {
+		err = err.With("project_id", p.Request.Config.Database.ProjectId, "experiment_id", p.Request.Experiment.Key)
+		logger.Warn("terminating experiment", "experiment_id", p.Request.Experiment.Key, "error", err.Error())
+	}
 	return err
 }
 
@@ -929,10 +1265,10 @@ func (p *processor) run(ctx context.Context, alloc *runner.Allocated, accessionI
 	terminateAt := time.Now().Add(maxDuration)
 
 	if terminateAt.Before(time.Now()) {
+		p.failed(outcomeExpired)
 		return kv.NewError("elapsed limit has expired").
 			With("project_id", p.Request.Config.Database.ProjectId, "experiment_id", p.Request.Experiment.Key,
-				"started_at", startedAt, "max_duration", maxDuration.String(),
-				"request", *p.Request).
+				"started_at", startedAt, "max_duration", maxDuration.String()).
 			With("stack", stack.Trace().TrimRuntime())
 	}
 
@@ -947,7 +1283,13 @@ func (p *processor) run(ctx context.Context, alloc *runner.Allocated, accessionI
 	}
 
 	// Now we have the files locally stored we can begin the work
-	if err = p.Executor.Make(alloc, p); err != nil {
+	_, span := p.startSpan(ctx, "runner/build")
+	buildStart := time.Now()
+	err = p.Executor.Make(alloc, p)
+	p.observePhase(phaseBuild, buildStart)
+	endSpan(span, err)
+	if err != nil {
+		p.failed(outcomeBuild)
 		return err
 	}
 
@@ -968,6 +1310,7 @@ func (p *processor) run(ctx context.Context, alloc *runner.Allocated, accessionI
 
 	// Recheck the expiry time as the make step can be time consuming
 	if terminateAt.Before(time.Now()) {
+		p.failed(outcomeExpired)
 		return kv.NewError("already expired").
 			With("project_id", p.Request.Config.Database.ProjectId, "experiment_id", p.Request.Experiment.Key,
 				"started_at", startedAt, "max_duration", maxDuration.String(),
@@ -1000,7 +1343,19 @@ func (p *processor) run(ctx context.Context, alloc *runner.Allocated, accessionI
 	// Blocking call to run the script and only return when done.  Cancellation is done
 	// if needed using the cancel function created by the context, runCtx
 	//
-	return p.runScript(runCtx, accessionID, refresh, refreshTimeout)
+	runStart := time.Now()
+	err = p.runScript(runCtx, alloc, accessionID, refresh, refreshTimeout)
+	p.observePhase(phaseRun, runStart)
+
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			p.failed(outcomeCancelled)
+		case runCtx.Err() == context.DeadlineExceeded:
+			p.failed(outcomeTimeout)
+		}
+	}
+	return err
 }
 
 func outputErr(fn string, inErr kv.Error) (err kv.Error) {
@@ -1032,24 +1387,48 @@ func (p *processor) deployAndRun(ctx context.Context, alloc *runner.Allocated, a
 		ctxProj, _ := FromProjectContext(ctx)
 
 		logger.Info(termination, "project_id", p.Request.Config.Database.ProjectId, "ctx_project_id", ctxProj, "experiment_id", p.Request.Experiment.Key)
+		if err != nil {
+			logger.Warn("experiment failed", "project_id", p.Request.Config.Database.ProjectId, "experiment_id", p.Request.Experiment.Key,
+				"error", err.Error())
+		}
 
 		// We should always upload results even in the event of an error to
 		// help give the experimenter some clues as to what might have
 		// failed if there is a problem.  The original ctx could have expired
 		// so we simply create and use a new one to do our upload.
 		//
-		timeout, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		timeout, cancel := context.WithTimeout(detachSpan(ctx), 5*time.Minute)
+		uploadStart := time.Now()
 		p.returnAll(timeout, accessionID)
+		p.observePhase(phaseUpload, uploadStart)
 		cancel()
 
+		recordUsage(p.Request.Config.Database.ProjectId, p.usage.Summary())
+
+		if err != nil {
+			p.notify("failed", err)
+		} else {
+			p.notify("completed", nil)
+		}
+
+		p.releaseTrees()
 		if !*debugOpt {
 			defer os.RemoveAll(p.ExprDir)
+			if len(p.runnerLog) != 0 {
+				defer os.Remove(p.runnerLog)
+			}
 		}
 	}(ctx)
 
+	// Track the resources consumed by the experiment from here on, including the artifacts
+	// it transfers
+	p.usage = runner.NewUsageTracker(p.Request.Experiment.Resource)
+
 	// Update and apply environment variables for the experiment
 	p.applyEnv(alloc)
 
+	p.notify("started", nil)
+
 	if *debugOpt {
 		// The following log can expose passwords etc.  As a result we do not allow it unless the debug
 		// non production flag is explicitly set
@@ -1063,7 +1442,11 @@ func (p *processor) deployAndRun(ctx context.Context, alloc *runner.Allocated, a
 
 	// fetchAll when called will have access to the environment variables used by the experiment in order that
 	// credentials can be used
-	if err = p.fetchAll(ctx); err != nil {
+	fetchStart := time.Now()
+	err = p.fetchAll(ctx)
+	p.observePhase(phaseFetch, fetchStart)
+	if err != nil {
+		p.failed(outcomeFetch)
 		// A failure here should result in a warning being written to the processor
 		// output file in the hope that it will be returned.  Likewise further on down in
 		// this function
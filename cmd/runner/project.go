@@ -18,7 +18,6 @@ import (
 	"github.com/go-stack/stack"
 	"github.com/jjeffery/kv"
 	"github.com/leaf-ai/studio-go-runner/internal/runner"
-	"github.com/leaf-ai/studio-go-runner/internal/types"
 	"github.com/prometheus/client_golang/prometheus"
 	uberatomic "go.uber.org/atomic"
 )
@@ -28,8 +27,8 @@ type projectContextKey string
 var (
 	projectKey = projectContextKey("project")
 
-	k8sListener sync.Once
-	openForBiz  = uberatomic.NewBool(true)
+	// openForBiz is maintained by the lifecycle manager and is true when new work can be accepted
+	openForBiz = uberatomic.NewBool(true)
 
 	wrapper         *runner.Wrapper = nil
 	wrapperErr                      = kv.Wrap(errors.New("wrapper uninitialized"))
@@ -96,32 +95,6 @@ type Projects struct {
 	sync.Mutex
 }
 
-func (*Projects) startStateWatcher(ctx context.Context) (err kv.Error) {
-	lifecycleC := make(chan runner.K8sStateUpdate, 1)
-	id, err := k8sStateUpdates().Add(lifecycleC)
-	if err != nil {
-		return err
-	}
-
-	go func() {
-		defer func() {
-			k8sStateUpdates().Delete(id)
-			close(lifecycleC)
-		}()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case state := <-lifecycleC:
-				openForBiz.Store(state.State == types.K8sRunning)
-			}
-		}
-	}()
-
-	return err
-}
-
 // Lifecycle is used to run a single pass across all of the found queues and subscriptions
 // looking for work and any needed updates to the list of queues found within the various queue
 // servers that are configured
@@ -142,14 +115,6 @@ func (live *Projects) Lifecycle(ctx context.Context, found map[string]string) (e
 		return nil
 	}
 
-	k8sListener.Do(func() {
-		err = live.startStateWatcher(ctx)
-	})
-
-	if err != nil {
-		return err
-	}
-
 	// Check to see if the ctx has been fired and if so clear the found list to emulate a
 	// queue server with no queues
 	if ctx.Err() != nil && len(found) != 0 {
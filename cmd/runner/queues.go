@@ -82,14 +82,43 @@ var (
 			Name: "runner_project_running",
 			Help: "Number of experiments being actively worked on per queue.",
 		},
-		[]string{"host", "queue_type", "queue_name", "project", "experiment"},
+		[]string{"host", "queue_type", "queue_name", "project"},
 	)
 	queueRan = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "runner_project_completed",
 			Help: "Number of experiments that have been run per queue.",
 		},
-		[]string{"host", "queue_type", "queue_name", "project", "experiment"},
+		[]string{"host", "queue_type", "queue_name", "project"},
+	)
+
+	queueReady = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "runner_queue_depth_ready",
+			Help: "Number of messages waiting to be delivered from a queue.",
+		},
+		[]string{"host", "queue_type", "queue_name"},
+	)
+	queueInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "runner_queue_depth_inflight",
+			Help: "Number of messages delivered from a queue that are not yet acknowledged.",
+		},
+		[]string{"host", "queue_type", "queue_name"},
+	)
+	queueOldest = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "runner_queue_oldest_age_seconds",
+			Help: "Age of the oldest message waiting within a queue, when the queue type supports it.",
+		},
+		[]string{"host", "queue_type", "queue_name"},
+	)
+	pendingGPUs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "runner_queue_pending_gpus",
+			Help: "Number of GPUs needed by the messages waiting within the queues of a project, for queues with known resource needs.",
+		},
+		[]string{"host", "queue_type", "project"},
 	)
 
 	host = runner.GetHostName()
@@ -102,6 +131,10 @@ func init() {
 	prometheus.MustRegister(queueIgnored)
 	prometheus.MustRegister(queueRunning)
 	prometheus.MustRegister(queueRan)
+	prometheus.MustRegister(queueReady)
+	prometheus.MustRegister(queueInFlight)
+	prometheus.MustRegister(queueOldest)
+	prometheus.MustRegister(pendingGPUs)
 
 	backoffs = runner.GetBackoffs()
 }
@@ -116,12 +149,13 @@ type SubsBusy struct {
 // Queuer stores the data associated with a runner instances of a queue worker at the level of the queue itself
 //
 type Queuer struct {
-	project string        // The project that is being used to access available work queues
-	cred    string        // The credentials file associated with this project
-	subs    Subscriptions // The subscriptions that exist within this project
-	busyQs  SubsBusy
-	timeout time.Duration // The queue query timeout
-	tasker  runner.TaskQueue
+	project   string        // The project that is being used to access available work queues
+	queueType string        // The type of queue server, used for labelling metrics
+	cred      string        // The credentials file associated with this project
+	subs      Subscriptions // The subscriptions that exist within this project
+	busyQs    SubsBusy
+	timeout   time.Duration // The queue query timeout
+	tasker    runner.TaskQueue
 }
 
 // SubRequest encapsulates the simple access details for a subscription.  This structure
@@ -138,14 +172,18 @@ type SubRequest struct {
 //
 func NewQueuer(projectID string, creds string, w *runner.Wrapper) (qr *Queuer, err kv.Error) {
 	qr = &Queuer{
-		project: projectID,
-		cred:    creds,
+		project:   projectID,
+		queueType: "sqs",
+		cred:      creds,
 		subs: Subscriptions{
 			subs: map[string]*Subscription{},
 		},
 		busyQs:  SubsBusy{subs: map[string]bool{}},
 		timeout: 15 * time.Second,
 	}
+	if strings.HasPrefix(projectID, "amqp://") {
+		qr.queueType = "rabbitMQ"
+	}
 	qr.tasker, err = runner.NewTaskQueue(projectID, creds, w)
 	if err != nil {
 		return nil, err
@@ -161,10 +199,12 @@ func (qr *Queuer) refresh() (err kv.Error) {
 	ctx, cancel := context.WithTimeout(context.Background(), qr.timeout)
 	defer cancel()
 
-	matcher, errGo := regexp.Compile(*queueMatch)
+	match, mismatch := queueFilters()
+
+	matcher, errGo := regexp.Compile(match)
 	if errGo != nil {
-		if len(*queueMatch) != 0 {
-			logger.Warn(kv.Wrap(errGo).With("matcher", *queueMatch).With("stack", stack.Trace().TrimRuntime()).Error())
+		if len(match) != 0 {
+			logger.Warn(kv.Wrap(errGo).With("matcher", match).With("stack", stack.Trace().TrimRuntime()).Error())
 		}
 		matcher = nil
 	}
@@ -174,12 +214,12 @@ func (qr *Queuer) refresh() (err kv.Error) {
 	mismatcher := &regexp.Regexp{}
 	_ = mismatcher // Bypass the ineffectual assignment check
 
-	if len(strings.Trim(*queueMismatch, " \n\r\t")) == 0 {
+	if len(strings.Trim(mismatch, " \n\r\t")) == 0 {
 		mismatcher = nil
 	} else {
-		mismatcher, errGo = regexp.Compile(*queueMismatch)
+		mismatcher, errGo = regexp.Compile(mismatch)
 		if errGo != nil {
-			logger.Warn(kv.Wrap(errGo).With("mismatcher", *queueMismatch).With("stack", stack.Trace().TrimRuntime()).Error())
+			logger.Warn(kv.Wrap(errGo).With("mismatcher", mismatch).With("stack", stack.Trace().TrimRuntime()).Error())
 			mismatcher = nil
 		}
 	}
@@ -196,6 +236,15 @@ func (qr *Queuer) refresh() (err kv.Error) {
 
 	added, removed := qr.subs.align(known)
 
+	if prefetcher != nil {
+		for _, remove := range removed {
+			prefetcher.Forget(remove)
+		}
+	}
+	for _, remove := range removed {
+		qr.forgetDepth(remove)
+	}
+
 	if logger.IsDebug() {
 		qr.reportQChanges(known, added, removed)
 	}
@@ -358,6 +407,11 @@ func (qr *Queuer) run(ctx context.Context, refreshQueues time.Duration, workChec
 	//
 	go qr.producer(ctx, workChecking)
 
+	// Measure the work waiting in the queues for metrics, typically used for autoscaling
+	if *queueDepthOpt != 0 {
+		go qr.depths(ctx, *queueDepthOpt)
+	}
+
 	// Now start a queue server refresher that will be called to obtain the latest list
 	// of known queues in the system
 	//
@@ -377,6 +431,64 @@ func (qr *Queuer) run(ctx context.Context, refreshQueues time.Duration, workChec
 	}
 }
 
+// depths measures the work waiting within the subscriptions on a regular interval, exporting
+// gauges for each queue along with the GPU demand for the project based on the resources
+// previously seen on each queue
+//
+func (qr *Queuer) depths(ctx context.Context, interval time.Duration) {
+
+	check := time.NewTicker(interval)
+	defer check.Stop()
+
+	defer func() {
+		for _, sub := range qr.getSubscriptions() {
+			qr.forgetDepth(sub.name)
+		}
+		pendingGPUs.Delete(prometheus.Labels{"host": host, "queue_type": qr.queueType, "project": qr.project})
+	}()
+
+	for {
+		select {
+		case <-check.C:
+			gpus := uint64(0)
+			for _, sub := range qr.getSubscriptions() {
+				depthCtx, cancel := context.WithTimeout(ctx, qr.timeout)
+				depth, err := qr.tasker.Depth(depthCtx, sub.name)
+				cancel()
+
+				if err != nil {
+					logger.Debug("queue depth unavailable", "project", qr.project, "subscription", sub.name, "error", err.Error())
+					continue
+				}
+
+				labels := prometheus.Labels{"host": host, "queue_type": qr.queueType, "queue_name": qr.project + sub.name}
+				queueReady.With(labels).Set(float64(depth.Ready))
+				queueInFlight.With(labels).Set(float64(depth.InFlight))
+				if depth.AgeKnown {
+					queueOldest.With(labels).Set(depth.OldestAge.Seconds())
+				}
+
+				if sub.rsc != nil {
+					gpus += uint64(depth.Ready) * uint64(sub.rsc.Gpus)
+				}
+			}
+			pendingGPUs.With(prometheus.Labels{"host": host, "queue_type": qr.queueType, "project": qr.project}).Set(float64(gpus))
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// forgetDepth removes the queue depth gauges for a subscription that is no longer present
+//
+func (qr *Queuer) forgetDepth(name string) {
+	labels := prometheus.Labels{"host": host, "queue_type": qr.queueType, "queue_name": qr.project + name}
+	queueReady.Delete(labels)
+	queueInFlight.Delete(labels)
+	queueOldest.Delete(labels)
+}
+
 // filterWork handles requests to check queues/subscriptions for work.
 //
 // Before checking it will ensure that a backoff time is not in play
@@ -551,6 +663,11 @@ func (qr *Queuer) doWork(ctx context.Context, request *SubRequest) {
 //
 func (qr *Queuer) fetchWork(ctx context.Context, qt *runner.QueueTask) {
 
+	// Queues that are already being serviced stop fetching work while the runner is draining
+	if !openForBiz.Load() {
+		return
+	}
+
 	// If we are able to determine the required capacity for the queue and
 	// the node does not have sufficient available dont both going to get any
 	// work
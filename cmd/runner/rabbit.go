@@ -52,11 +52,13 @@ func initRMQ() (rmq *runner.RabbitMQ) {
 
 func initRMQStructs() (matcher *regexp.Regexp, mismatcher *regexp.Regexp) {
 
-	// The regular expression is validated in the main.go file
-	matcher, errGo := regexp.Compile(*queueMatch)
+	// The regular expression is validated in the main.go file, or when changed by a configMap
+	match, mismatch := queueFilters()
+
+	matcher, errGo := regexp.Compile(match)
 	if errGo != nil {
-		if len(*queueMatch) != 0 {
-			logger.Warn(kv.Wrap(errGo).With("matcher", *queueMatch).With("stack", stack.Trace().TrimRuntime()).Error())
+		if len(match) != 0 {
+			logger.Warn(kv.Wrap(errGo).With("matcher", match).With("stack", stack.Trace().TrimRuntime()).Error())
 		}
 		matcher = nil
 	}
@@ -64,13 +66,13 @@ func initRMQStructs() (matcher *regexp.Regexp, mismatcher *regexp.Regexp) {
 	// If the length of the mismatcher is 0 then we will get a nil and because this
 	// was checked in the main we can ignore that as this is optional
 
-	if len(strings.Trim(*queueMismatch, " \n\r\t")) == 0 {
+	if len(strings.Trim(mismatch, " \n\r\t")) == 0 {
 		mismatcher = nil
 	} else {
-		mismatcher, errGo = regexp.Compile(*queueMismatch)
+		mismatcher, errGo = regexp.Compile(mismatch)
 		if errGo != nil {
-			if len(*queueMismatch) != 0 {
-				logger.Warn(kv.Wrap(errGo).With("mismatcher", *queueMismatch).With("stack", stack.Trace().TrimRuntime()).Error())
+			if len(mismatch) != 0 {
+				logger.Warn(kv.Wrap(errGo).With("mismatcher", mismatch).With("stack", stack.Trace().TrimRuntime()).Error())
 			}
 			mismatcher = nil
 		}
@@ -90,7 +92,6 @@ func serviceRMQ(ctx context.Context, checkInterval time.Duration, connTimeout ti
 		return
 	}
 
-	matcher, mismatcher := initRMQStructs()
 	rmq := initRMQ()
 
 	// Tracks all known queues and their cancel functions so they can have any
@@ -154,6 +155,9 @@ func serviceRMQ(ctx context.Context, checkInterval time.Duration, connTimeout ti
 				continue
 			}
 
+			// The queue filters are obtained each time as they can be changed using configMaps
+			matcher, mismatcher := initRMQStructs()
+
 			connCtx, cancel := context.WithTimeout(ctx, connTimeout)
 
 			// Found returns a map that contains the queues that were found
@@ -161,6 +165,8 @@ func serviceRMQ(ctx context.Context, checkInterval time.Duration, connTimeout ti
 			found, err := rmq.GetKnown(connCtx, matcher, mismatcher)
 			cancel()
 
+			recordQueueHealth(live.queueType, err)
+
 			if err != nil {
 				logger.Warn("unable to refresh RMQ manifest", err.Error())
 				qCheck = qCheck * 2
@@ -0,0 +1,192 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+// This file contains the implementation of runner state changes for runners that are not
+// deployed using Kubernetes, for example on VMs and workstations.  The state can be
+// changed using a watched state file, signals, or the /state endpoint of the prometheus
+// http server.  Changes made using the endpoint must carry the state-api-token as a bearer
+// token as the prometheus server is typically reachable by anything that scrapes it.
+// Changes are sent to the same broadcaster used for the Kubernetes configMap
+// STATE and so are handled identically.  When both are in use the most recent change is
+// used, noting that Kubernetes will periodically resend the configMap STATE.
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/leaf-ai/studio-go-runner/internal/runner"
+	"github.com/leaf-ai/studio-go-runner/internal/types"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+var (
+	stateFileOpt     = flag.String("state-file", "", "a file containing the runner state, one of Running, DrainAndSuspend, or DrainAndTerminate, that is watched for changes")
+	stateAPIOpt      = flag.Bool("state-api", false, "allow the runner state to be changed using PUT requests to the /state endpoint of the prom-address server")
+	stateAPITokenOpt = flag.String("state-api-token", "", "the bearer token that PUT requests to the /state endpoint must present, required when the state-api option is set")
+)
+
+// sendState passes a requested runner state to the listeners for state changes
+//
+func sendState(source string, state types.K8sState) (err kv.Error) {
+	if !state.IsAK8sState() || state == types.K8sUnknown {
+		return kv.NewError("invalid runner state").With("state", state.String(), "source", source).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	l := k8sStateUpdates()
+	if l == nil {
+		return kv.NewError("runner state changes unavailable").With("source", source).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	select {
+	case l.Master <- runner.K8sStateUpdate{Name: source, State: state}:
+		logger.Info("runner state requested", "state", state.String(), "source", source)
+		return nil
+	case <-time.After(2 * time.Second):
+		return kv.NewError("could not update state").With("state", state.String(), "source", source).With("stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// parseState converts the text name of a state, as used by the configMaps, into a state
+//
+func parseState(text string) (state types.K8sState, err kv.Error) {
+	state, errGo := types.K8sStateString(strings.TrimSpace(text))
+	if errGo != nil {
+		return types.K8sUnknown, kv.Wrap(errGo).With("state", strings.TrimSpace(text)).With("stack", stack.Trace().TrimRuntime())
+	}
+	return state, nil
+}
+
+// watchStateSignals changes the runner state when SIGUSR1, drain and suspend, or SIGUSR2,
+// resume running, are received
+//
+func watchStateSignals(ctx context.Context, errorC chan<- kv.Error) {
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigC)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigC:
+			state := types.K8sRunning
+			if sig == syscall.SIGUSR1 {
+				state = types.K8sDrainAndSuspend
+			}
+			if err := sendState("signal "+sig.String(), state); err != nil {
+				reportStateErr(err, errorC)
+			}
+		}
+	}
+}
+
+// watchStateFile checks the state file for changes until the ctx is Done.  A missing file
+// is not treated as a change allowing the file to be deleted and recreated.
+//
+func watchStateFile(ctx context.Context, fn string, interval time.Duration, errorC chan<- kv.Error) {
+	if len(fn) == 0 {
+		return
+	}
+
+	check := time.NewTicker(interval)
+	defer check.Stop()
+
+	lastMod := time.Time{}
+	lastText := ""
+
+	for {
+		if info, errGo := os.Stat(fn); errGo == nil && !info.ModTime().Equal(lastMod) {
+			lastMod = info.ModTime()
+
+			func() {
+				data, errGo := ioutil.ReadFile(fn)
+				if errGo != nil {
+					reportStateErr(kv.Wrap(errGo).With("file", fn).With("stack", stack.Trace().TrimRuntime()), errorC)
+					return
+				}
+				text := strings.TrimSpace(string(data))
+				if text == lastText {
+					return
+				}
+				state, err := parseState(text)
+				if err != nil {
+					reportStateErr(err.With("file", fn), errorC)
+					return
+				}
+				if err = sendState("file "+fn, state); err != nil {
+					reportStateErr(err, errorC)
+					return
+				}
+				lastText = text
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-check.C:
+		}
+	}
+}
+
+func reportStateErr(err kv.Error, errorC chan<- kv.Error) {
+	select {
+	case errorC <- err:
+	case <-time.After(2 * time.Second):
+		logger.Warn(fmt.Sprint(err))
+	}
+}
+
+// serveState reports the runner state and drain progress for GET requests and when enabled
+// using the state-api option changes the state for PUT requests that carry the
+// state-api-token, the body containing the name of the state
+//
+func serveState(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		if !*stateAPIOpt {
+			http.Error(w, "state changes are not enabled, see the state-api option", http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if len(*stateAPITokenOpt) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(*stateAPITokenOpt)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, errGo := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1024))
+		if errGo != nil {
+			http.Error(w, errGo.Error(), http.StatusBadRequest)
+			return
+		}
+		requested, err := parseState(string(body))
+		if err != nil {
+			http.Error(w, "unrecognized state, expected one of Running, DrainAndSuspend, or DrainAndTerminate", http.StatusBadRequest)
+			return
+		}
+		if err = sendState("api "+r.RemoteAddr, requested); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(lifecycle.status())
+}
@@ -0,0 +1,108 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leaf-ai/studio-go-runner/internal/runner"
+	"github.com/leaf-ai/studio-go-runner/internal/types"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// TestStandaloneState checks that changes to the state file are sent to the state listeners
+// and that state changes using the API are refused unless enabled
+//
+func TestStandaloneState(t *testing.T) {
+
+	if k8sStateUpdates() == nil {
+		t.Skip("state broadcaster not started")
+	}
+
+	dir, errGo := ioutil.TempDir("", "state-file")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "state")
+
+	listener := make(chan runner.K8sStateUpdate, 1)
+	id, err := k8sStateUpdates().Add(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k8sStateUpdates().Delete(id)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	errorC := make(chan kv.Error, 1)
+	go watchStateFile(ctx, fn, 50*time.Millisecond, errorC)
+
+	// Waits for the state to be received, other state changes such as the periodic
+	// Kubernetes refresh being ignored
+	expect := func(content string, modTime time.Time, state types.K8sState) {
+		if errGo := ioutil.WriteFile(fn, []byte(content), 0600); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if errGo := os.Chtimes(fn, modTime, modTime); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		for {
+			select {
+			case update := <-listener:
+				if update.State == state && strings.HasPrefix(update.Name, "file ") {
+					return
+				}
+			case err := <-errorC:
+				t.Fatal(err)
+			case <-ctx.Done():
+				t.Fatal(kv.NewError("state file change not seen").With("state", state.String()).With("stack", stack.Trace().TrimRuntime()))
+			}
+		}
+	}
+
+	expect("DrainAndSuspend\n", time.Now().Add(-time.Minute), types.K8sDrainAndSuspend)
+	expect("Running", time.Now(), types.K8sRunning)
+
+	recorder := httptest.NewRecorder()
+	serveState(recorder, httptest.NewRequest(http.MethodPut, "/state", strings.NewReader("DrainAndTerminate")))
+	if recorder.Code != http.StatusForbidden {
+		t.Fatal(kv.NewError("state change accepted without the state-api option").With("status", recorder.Code).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// State changes must present the token even when the state-api option is set
+	apiOpt, tokenOpt := *stateAPIOpt, *stateAPITokenOpt
+	*stateAPIOpt, *stateAPITokenOpt = true, "state-test-token"
+	defer func() {
+		*stateAPIOpt, *stateAPITokenOpt = apiOpt, tokenOpt
+	}()
+
+	for _, token := range []string{"", "Bearer wrong-token"} {
+		req := httptest.NewRequest(http.MethodPut, "/state", strings.NewReader("DrainAndTerminate"))
+		if len(token) != 0 {
+			req.Header.Set("Authorization", token)
+		}
+		recorder = httptest.NewRecorder()
+		serveState(recorder, req)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Fatal(kv.NewError("state change accepted without a valid token").With("status", recorder.Code).With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	serveState(recorder, httptest.NewRequest(http.MethodGet, "/state", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatal(kv.NewError("state not readable without a token").With("status", recorder.Code).With("stack", stack.Trace().TrimRuntime()))
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+// This file contains the implementation of distributed tracing for experiments.  Spans are
+// recorded for each phase of handling a message, from its receipt through to the return of
+// the artifacts, and are exported to an OTLP/HTTP collector.  Submitters can have the spans
+// of the runner appear within their own traces by placing a W3C traceparent value within
+// the clear text portion of the message envelope.
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/leaf-ai/studio-go-runner/internal/runner"
+
+	"github.com/jjeffery/kv" // MIT License
+
+	"go.opencensus.io/trace"
+)
+
+var (
+	otlpEndpointOpt = flag.String("otlp-endpoint", "", "the OTLP/HTTP traces endpoint of an OpenTelemetry collector to which experiment traces are sent, for example http://localhost:4318/v1/traces")
+	traceSampleOpt  = flag.Float64("trace-sample", 1.0, "the fraction of experiments traced when an otlp-endpoint is used, experiments whose submitter is tracing them are always traced")
+)
+
+// startTracing enables the recording of spans when an OTLP endpoint has been configured and
+// sends them to the collector until the ctx is Done
+//
+func startTracing(ctx context.Context, errorC chan<- kv.Error) {
+	if len(*otlpEndpointOpt) == 0 {
+		trace.ApplyConfig(trace.Config{DefaultSampler: trace.NeverSample()})
+		return
+	}
+
+	exporter := runner.NewOTLPExporter(*otlpEndpointOpt, map[string]string{
+		"service.name": "studio-go-runner",
+		"host.name":    host,
+	})
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(*traceSampleOpt)})
+
+	logger.Info("tracing enabled", "endpoint", *otlpEndpointOpt, "sample", *traceSampleOpt)
+
+	exporter.Run(ctx, 5*time.Second, errorC)
+}
+
+// startMsgSpan starts the span covering the handling of a queued message.  When the message
+// envelope contains the trace context of the submitter the span becomes a part of that trace.
+//
+func startMsgSpan(ctx context.Context, qt *runner.QueueTask) (spanCtx context.Context, span *trace.Span) {
+	name := "runner/receive"
+	if isEnvelope, _ := runner.IsEnvelope(qt.Msg); isEnvelope {
+		if envelope, err := runner.UnmarshalEnvelope(qt.Msg); err == nil && len(envelope.Message.TraceParent) != 0 {
+			parent, err := runner.ParseTraceParent(envelope.Message.TraceParent)
+			if err == nil {
+				span = trace.NewSpanWithRemoteParent(name, parent, trace.StartOptions{SpanKind: trace.SpanKindServer})
+			} else {
+				logger.Debug("trace context ignored", "subscription", qt.Subscription, "error", err.Error())
+			}
+		}
+	}
+	if span == nil {
+		span = trace.NewSpan(name, nil, trace.StartOptions{SpanKind: trace.SpanKindServer})
+	}
+	span.AddAttributes(trace.StringAttribute("queue", qt.Project+qt.Subscription))
+
+	return trace.WithSpan(ctx, span), span
+}
+
+// startSpan starts a span for a phase of processing an experiment as a child of any span
+// within the ctx
+//
+func (p *processor) startSpan(ctx context.Context, name string) (spanCtx context.Context, span *trace.Span) {
+	spanCtx, span = trace.StartSpan(ctx, name)
+	span.AddAttributes(p.spanAttributes()...)
+	return spanCtx, span
+}
+
+// spanAttributes returns the attributes that identify the experiment being processed
+//
+func (p *processor) spanAttributes() (attrs []trace.Attribute) {
+	attrs = []trace.Attribute{
+		trace.StringAttribute("queue", p.queue),
+	}
+	if p.Request != nil {
+		attrs = append(attrs,
+			trace.StringAttribute("project_id", p.Request.Config.Database.ProjectId),
+			trace.StringAttribute("experiment_id", p.Request.Experiment.Key))
+	}
+	if len(p.accessionID) != 0 {
+		attrs = append(attrs, trace.StringAttribute("accession_id", p.accessionID))
+	}
+	return attrs
+}
+
+// endSpan ends a span recording any error that occurred during the span
+//
+func endSpan(span *trace.Span, err kv.Error) {
+	if err != nil {
+		// OpenCensus uses the gRPC status codes, 2 being unknown
+		span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
+	}
+	span.End()
+}
+
+// detachSpan returns a context that carries the span of the ctx but not its deadline or
+// cancellation, for use by uploads that must continue once the experiment has stopped
+//
+func detachSpan(ctx context.Context) (detached context.Context) {
+	return trace.WithSpan(context.Background(), trace.FromContext(ctx))
+}
@@ -0,0 +1,127 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package main
+
+// This file contains the recording of resources consumed by experiments, both as
+// a summary stored with the experiments metadata and as per project aggregates
+// exported to prometheus
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/leaf-ai/studio-go-runner/internal/runner"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	usageExperiments = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_project_usage_experiments",
+			Help: "Number of experiments whose resource usage has been recorded.",
+		},
+		[]string{"host", "project"},
+	)
+	usageCPUSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_project_cpu_seconds",
+			Help: "CPU time consumed by the process trees of experiments.",
+		},
+		[]string{"host", "project"},
+	)
+	usageGPUSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_project_gpu_busy_seconds",
+			Help: "Time the GPUs allocated to experiments were busy, based on their average utilization.",
+		},
+		[]string{"host", "project"},
+	)
+	usageRSSPeak = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_project_rss_peak_bytes",
+			Help: "Sum of the peak resident memory of experiments.",
+		},
+		[]string{"host", "project"},
+	)
+	usageGPUMemPeak = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_project_gpu_mem_peak_bytes",
+			Help: "Sum of the peak GPU memory in use by experiments.",
+		},
+		[]string{"host", "project"},
+	)
+	usageDiskPeak = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_project_disk_peak_bytes",
+			Help: "Sum of the peak local storage used by experiments.",
+		},
+		[]string{"host", "project"},
+	)
+	usageTransfer = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_project_transfer_bytes",
+			Help: "Bytes of artifacts moved to and from storage platforms for experiments.",
+		},
+		[]string{"host", "project"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(usageExperiments)
+	prometheus.MustRegister(usageCPUSeconds)
+	prometheus.MustRegister(usageGPUSeconds)
+	prometheus.MustRegister(usageRSSPeak)
+	prometheus.MustRegister(usageGPUMemPeak)
+	prometheus.MustRegister(usageDiskPeak)
+	prometheus.MustRegister(usageTransfer)
+}
+
+// writeUsage saves the resource usage of the experiment so far into the metadata
+// directory using a file name that shares the accession ID with the scrape files
+//
+func (p *processor) writeUsage(accessionID string) (err kv.Error) {
+	if p.usage == nil || len(accessionID) == 0 {
+		return nil
+	}
+
+	metaDir := filepath.Join(p.ExprDir, "_metadata")
+	if errGo := os.MkdirAll(metaDir, 0700); errGo != nil {
+		return kv.Wrap(errGo).With("dir", metaDir, "stack", stack.Trace().TrimRuntime())
+	}
+
+	data, errGo := json.MarshalIndent(p.usage.Summary(), "", "  ")
+	if errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	fn := filepath.Join(metaDir, "usage-host-"+accessionID+".json")
+	if errGo = ioutil.WriteFile(fn, data, 0600); errGo != nil {
+		return kv.Wrap(errGo).With("file", fn, "stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// recordUsage adds the resources consumed by a completed experiment to the
+// per project prometheus aggregates
+//
+func recordUsage(project string, usage runner.ExperimentUsage) {
+	labels := prometheus.Labels{"host": host, "project": project}
+
+	usageExperiments.With(labels).Inc()
+	usageCPUSeconds.With(labels).Add(usage.CPUSeconds)
+	usageRSSPeak.With(labels).Add(float64(usage.PeakRSS))
+	usageGPUMemPeak.With(labels).Add(float64(usage.GPUMemPeak))
+	usageDiskPeak.With(labels).Add(float64(usage.DiskPeak))
+	usageTransfer.With(labels).Add(float64(usage.TransferBytes))
+
+	if !usage.Started.IsZero() && usage.Stopped.After(usage.Started) {
+		elapsed := usage.Stopped.Sub(usage.Started).Seconds()
+		usageGPUSeconds.With(labels).Add(elapsed * usage.GPUUtilAvg / 100)
+	}
+}
@@ -331,3 +331,94 @@ func TestCUDATrivialTrialAlloc(t *testing.T) {
 		t.Fatal(kv.NewError("allocation result was unexpected").With("expected_devices", 1).With("actual_devices", 2).With("stack", stack.Trace().TrimRuntime()))
 	}
 }
+
+// TestCUDASharedAlloc places several memory budgeted experiments onto cards that are
+// being shared and checks that slot based allocations avoid the shared cards
+//
+func TestCUDASharedAlloc(t *testing.T) {
+	small := xid.New().String()
+	large := xid.New().String()
+
+	testAlloc := gpuTracker{
+		Allocs: map[string]*GPUTrack{
+			small: {
+				UUID:      small,
+				Slots:     2,
+				Mem:       8,
+				FreeSlots: 2,
+				FreeMem:   8,
+				Tracking:  map[string]struct{}{},
+			},
+			large: {
+				UUID:      large,
+				Slots:     2,
+				Mem:       16,
+				FreeSlots: 2,
+				FreeMem:   16,
+				Tracking:  map[string]struct{}{},
+			},
+		},
+		shareMem:  true,
+		capMemEnv: true,
+	}
+
+	// Three 4 unit experiments should pack into the smaller card first then spill
+	// onto the larger card
+	allocs := GPUAllocations{}
+	for i, expected := range []string{small, small, large} {
+		alloc, err := testAlloc.AllocGPU(1, 4, []uint{2}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(alloc) != 1 || alloc[0].uuid != expected {
+			t.Fatal(kv.NewError("shared allocation placed on the wrong card").With("allocation", i).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if alloc[0].Env["TF_FORCE_GPU_ALLOW_GROWTH"] != "true" {
+			t.Fatal(kv.NewError("shared allocation missing framework env").With("env", alloc[0].Env).With("stack", stack.Trace().TrimRuntime()))
+		}
+		allocs = append(allocs, alloc...)
+	}
+
+	// Neither card is entirely free so slot based allocations cannot succeed
+	if _, err := testAlloc.AllocGPU(2, 0, []uint{2}, false); err == nil {
+		t.Fatal(kv.NewError("slot allocation succeeded on shared cards").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// The remaining memory is not enough for a 16 unit request
+	if _, err := testAlloc.AllocGPU(1, 16, []uint{2}, false); err == nil {
+		t.Fatal(kv.NewError("shared allocation exceeded the free memory").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// Release the allocations on the smaller card and it becomes usable for slots again
+	for _, alloc := range allocs[:2] {
+		if err := testAlloc.ReturnGPU(alloc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slotAllocs, err := testAlloc.AllocGPU(2, 2, []uint{2}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slotAllocs) != 1 || slotAllocs[0].uuid != small {
+		t.Fatal(kv.NewError("slot allocation did not use the released card").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// A card used for slots cannot then be shared, even when it is the closest fit
+	sharedAlloc, err := testAlloc.AllocGPU(1, 5, []uint{2}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sharedAlloc) != 1 || sharedAlloc[0].uuid != large {
+		t.Fatal(kv.NewError("shared allocation used a card holding slot allocations").With("stack", stack.Trace().TrimRuntime()))
+	}
+	allocs = append(allocs, sharedAlloc...)
+
+	for _, alloc := range append(slotAllocs, allocs[2:]...) {
+		if err := testAlloc.ReturnGPU(alloc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if testAlloc.Allocs[large].Shared != 0 || testAlloc.Allocs[large].FreeMem != 16 {
+		t.Fatal(kv.NewError("shared allocation was not returned").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the compression and archive format handling that is shared by
+// the storage implementations when artifacts are being unpacked after download, or packed
+// prior to being uploaded.  The format used is selected using the mime type derived
+// from the artifact key extension, see MimeFromExt.
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	bzip2w "github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/ulikunitz/xz"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// IsZip is used to test the extension of an artifact key to see if it is a zip archive
+//
+func IsZip(name string) bool {
+	return strings.HasSuffix(name, ".zip")
+}
+
+// IsArchive is used to test the extension of an artifact key to see if it is an archive
+// format that can be unpacked or packed by the runner
+//
+func IsArchive(name string) bool {
+	return IsTar(name) || IsZip(name)
+}
+
+// NewDecompressor will add a decompression reader on top of the supplied reader that
+// is appropriate for the mime type of the artifact.  Any types that are not known
+// compressed formats, including application/octet-stream, are passed through as is, typically
+// being a raw tar, matching the output of NewCompressor.
+//
+func NewDecompressor(fileType string, in io.Reader) (rdr io.ReadCloser, err kv.Error) {
+	switch fileType {
+	case "application/x-gzip":
+		reader, errGo := gzip.NewReader(in)
+		if errGo != nil {
+			return nil, kv.Wrap(errGo).With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
+		}
+		return reader, nil
+	case "application/bzip2":
+		return ioutil.NopCloser(bzip2.NewReader(in)), nil
+	case "application/zstd":
+		reader, errGo := zstd.NewReader(in)
+		if errGo != nil {
+			return nil, kv.Wrap(errGo).With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
+		}
+		return reader.IOReadCloser(), nil
+	case "application/x-xz":
+		reader, errGo := xz.NewReader(in)
+		if errGo != nil {
+			return nil, kv.Wrap(errGo).With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
+		}
+		return ioutil.NopCloser(reader), nil
+	case "application/x-lz4":
+		return ioutil.NopCloser(lz4.NewReader(in)), nil
+	case "application/zip":
+		return nil, kv.NewError("zip archives are not stream compressed").With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
+	default:
+		return ioutil.NopCloser(in), nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// NewCompressor will add a compression writer on top of the supplied writer that is
+// appropriate for the mime type of the artifact.  Closing the returned writer will flush
+// any compressed data but will not close the underlying writer.
+//
+func NewCompressor(fileType string, out io.Writer) (wtr io.WriteCloser, err kv.Error) {
+	switch fileType {
+	case "application/tar", "application/octet-stream":
+		return nopWriteCloser{out}, nil
+	case "application/bzip2":
+		writer, errGo := bzip2w.NewWriter(out, &bzip2w.WriterConfig{Level: 6})
+		if errGo != nil {
+			return nil, kv.Wrap(errGo).With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
+		}
+		return writer, nil
+	case "application/x-gzip":
+		return gzip.NewWriter(out), nil
+	case "application/zstd":
+		writer, errGo := zstd.NewWriter(out)
+		if errGo != nil {
+			return nil, kv.Wrap(errGo).With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
+		}
+		return writer, nil
+	case "application/x-xz":
+		writer, errGo := xz.NewWriter(out)
+		if errGo != nil {
+			return nil, kv.Wrap(errGo).With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
+		}
+		return writer, nil
+	case "application/x-lz4":
+		return lz4.NewWriter(out), nil
+	case "application/zip":
+		return nil, kv.NewError("zip archives are not stream compressed").With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
+	default:
+		return nil, kv.NewError("unrecognized upload compression").With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// WriteArchive will output the files contained within the catalog of a TarWriter
+// as an archive of the mime type specified, typically obtained from the artifact
+// key using MimeFromExt
+//
+func WriteArchive(fileType string, files *TarWriter, out io.Writer) (err kv.Error) {
+	if fileType == "application/zip" {
+		zw := zip.NewWriter(out)
+		if err = files.WriteZip(zw); err != nil {
+			zw.Close()
+			return err
+		}
+		if errGo := zw.Close(); errGo != nil {
+			return kv.Wrap(errGo).With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
+		}
+		return nil
+	}
+
+	outZ, err := NewCompressor(fileType, out)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(outZ)
+	if err = files.Write(tw); err != nil {
+		tw.Close()
+		outZ.Close()
+		return err
+	}
+	if errGo := tw.Close(); errGo != nil {
+		outZ.Close()
+		return kv.Wrap(errGo).With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
+	}
+	if errGo := outZ.Close(); errGo != nil {
+		return kv.Wrap(errGo).With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// Unzip will extract the contents of a zip archive into the output directory applying any
+// limits present in the context.  Zip archives store their directory at the end of the file
+// so when the input is not a file it will first be spooled into a temporary file alongside
+// the output directory.
+//
+func Unzip(ctx context.Context, in io.Reader, output string) (err kv.Error) {
+
+	e, err := newExtractor(ctx, output)
+	if err != nil {
+		return err
+	}
+
+	file, isFile := in.(*os.File)
+	if !isFile {
+		tmp, errGo := ioutil.TempFile(filepath.Dir(output), ".unzip-")
+		if errGo != nil {
+			return kv.Wrap(errGo).With("output", output).With("stack", stack.Trace().TrimRuntime())
+		}
+		defer func() {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}()
+
+		if _, errGo = io.Copy(tmp, in); errGo != nil {
+			return kv.Wrap(errGo).With("output", output).With("stack", stack.Trace().TrimRuntime())
+		}
+		file = tmp
+	}
+
+	info, errGo := file.Stat()
+	if errGo != nil {
+		return kv.Wrap(errGo).With("output", output).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	zr, errGo := zip.NewReader(file, info.Size())
+	if errGo != nil {
+		return kv.Wrap(errGo).With("output", output).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	for _, item := range zr.File {
+		if err = unzipFile(e, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unzipFile(e *extractor, item *zip.File) (err kv.Error) {
+	if err = e.count(item.Name); err != nil {
+		return err
+	}
+
+	path, err := e.target(item.Name)
+	if err != nil {
+		return err
+	}
+
+	info := item.FileInfo()
+	mode := info.Mode()
+
+	switch {
+	case mode.IsDir():
+		return e.mkdir(path, mode)
+	case mode&os.ModeSymlink != 0, mode.IsRegular():
+	default:
+		return kv.Wrap(ErrArchiveEntryType).With("entry", item.Name, "mode", mode.String()).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	rdr, errGo := item.Open()
+	if errGo != nil {
+		return kv.Wrap(errGo).With("path", path).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer rdr.Close()
+
+	// Zip archives store symbolic links as entries with the link destination as the contents
+	if mode&os.ModeSymlink != 0 {
+		link, errGo := ioutil.ReadAll(io.LimitReader(rdr, 4096))
+		if errGo != nil {
+			return kv.Wrap(errGo).With("path", path).With("stack", stack.Trace().TrimRuntime())
+		}
+		return e.symlink(path, string(link))
+	}
+
+	return e.writeFile(path, mode, int64(item.UncompressedSize64), rdr)
+}
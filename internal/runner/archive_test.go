@@ -0,0 +1,150 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv"
+)
+
+// TestArchiveFormats is used to validate that each of the supported archive formats can be packed
+// and then unpacked using the local storage implementation
+func TestArchiveFormats(t *testing.T) {
+
+	src, errGo := ioutil.TempDir("", "archive-src")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(src)
+
+	contents := map[string]string{
+		"a.txt":         RandomString(1024),
+		"dir/b.txt":     RandomString(16 * 1024),
+		"dir/sub/c.txt": RandomString(10),
+	}
+	for name, data := range contents {
+		fn := filepath.Join(src, name)
+		if errGo = os.MkdirAll(filepath.Dir(fn), 0700); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if errGo = ioutil.WriteFile(fn, []byte(data), 0600); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+
+	for _, ext := range []string{".tar", ".tar.gz", ".tar.bz2", ".tar.zst", ".tzst", ".tar.xz", ".tar.lz4", ".zip"} {
+		func() {
+			dir, errGo := ioutil.TempDir("", "archive-dest")
+			if errGo != nil {
+				t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+			}
+			defer os.RemoveAll(dir)
+
+			if !IsArchive(ext) {
+				t.Fatal(kv.NewError("archive type not recognized").With("ext", ext).With("stack", stack.Trace().TrimRuntime()))
+			}
+
+			archive := filepath.Join(dir, "artifact"+ext)
+			typ, err := MimeFromExt(archive)
+			if err != nil {
+				t.Fatal(err.With("ext", ext))
+			}
+
+			files, err := NewTarWriter(src)
+			if err != nil {
+				t.Fatal(err.With("ext", ext))
+			}
+
+			f, errGo := os.Create(archive)
+			if errGo != nil {
+				t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+			}
+			err = WriteArchive(typ, files, f)
+			f.Close()
+			if err != nil {
+				t.Fatal(err.With("ext", ext))
+			}
+
+			output := filepath.Join(dir, "output")
+			if errGo = os.MkdirAll(output, 0700); errGo != nil {
+				t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+			}
+
+			local, _ := NewLocalStorage()
+			if _, err = local.Fetch(context.Background(), archive, true, output, nil); err != nil {
+				t.Fatal(err.With("ext", ext))
+			}
+
+			for name, data := range contents {
+				unpacked, errGo := ioutil.ReadFile(filepath.Join(output, name))
+				if errGo != nil {
+					t.Fatal(kv.Wrap(errGo).With("ext", ext, "file", name).With("stack", stack.Trace().TrimRuntime()))
+				}
+				if string(unpacked) != data {
+					t.Fatal(kv.NewError("unpacked contents did not match").With("ext", ext, "file", name).With("stack", stack.Trace().TrimRuntime()))
+				}
+			}
+		}()
+	}
+}
+
+// TestArchiveOctetStream checks that archives of an unrecognized type written as raw tar
+// files can be read back
+//
+func TestArchiveOctetStream(t *testing.T) {
+
+	src, errGo := ioutil.TempDir("", "archive-octet")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(src)
+
+	data := RandomString(4096)
+	if errGo = ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte(data), 0600); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	files, err := NewTarWriter(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err = WriteArchive("application/octet-stream", files, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	rdr, err := NewDecompressor("application/octet-stream", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+
+	tr := tar.NewReader(rdr)
+	for {
+		header, errGo := tr.Next()
+		if errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if filepath.Base(header.Name) != "a.txt" {
+			continue
+		}
+		unpacked, errGo := ioutil.ReadAll(io.LimitReader(tr, header.Size))
+		if errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if string(unpacked) != data {
+			t.Fatal(kv.NewError("unpacked contents did not match").With("stack", stack.Trace().TrimRuntime()))
+		}
+		return
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of encrypted artifacts.  Encrypted artifacts are
+// stored using the streaming form of the block encryption with a data key that is supplied
+// by the experimenter within the encrypted request payload.  The artifacts are only ever
+// present in clear text form within the experiment directory on the runner.
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+var (
+	// ErrArtifactIncrementalEncrypted indicates an artifact requested both incremental uploads,
+	// which store files individually in clear text, and encryption
+	ErrArtifactIncrementalEncrypted = kv.NewError("incremental artifacts cannot be encrypted")
+)
+
+type artifactKeyKey struct{}
+
+// Validate checks that the options requested for an artifact can be honored together
+//
+func (a *Artifact) Validate() (err kv.Error) {
+	if a.Incremental && a.Encrypted {
+		return kv.Wrap(ErrArtifactIncrementalEncrypted).With("key", a.Key).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// ParseArtifactKey decodes the base64 encoded 32 byte data key that experimenters supply
+// for encrypting their artifacts
+//
+func ParseArtifactKey(encoded string) (key [32]byte, err kv.Error) {
+	decoded, errGo := base64.StdEncoding.DecodeString(encoded)
+	if errGo != nil {
+		return key, kv.Wrap(errGo, "artifact key is not valid base64").With("stack", stack.Trace().TrimRuntime())
+	}
+	if len(decoded) != len(key) {
+		return key, kv.NewError("artifact key must be 32 bytes").With("length", len(decoded)).With("stack", stack.Trace().TrimRuntime())
+	}
+	copy(key[:], decoded)
+	return key, nil
+}
+
+// WithArtifactKey returns a context that carries the data key used when encrypted
+// artifacts are fetched, or restored using the context
+//
+func WithArtifactKey(ctx context.Context, key [32]byte) (keyCtx context.Context) {
+	return context.WithValue(ctx, artifactKeyKey{}, key)
+}
+
+func artifactKey(ctx context.Context, art *Artifact) (key [32]byte, err kv.Error) {
+	key, isPresent := ctx.Value(artifactKeyKey{}).([32]byte)
+	if !isPresent {
+		return key, kv.NewError("encrypted artifact has no data key").With("key", art.Key).With("stack", stack.Trace().TrimRuntime())
+	}
+	return key, art.Validate()
+}
+
+// fetchEncrypted downloads an encrypted artifact and decrypts it while it is being unpacked, or
+// copied into the destination directory.  The cipher text is what is held in the local cache.
+//
+func (cache *ArtifactCache) fetchEncrypted(ctx context.Context, storage *objStore, art *Artifact, dest string) (warns []kv.Error, err kv.Error) {
+
+	key, err := artifactKey(ctx, art)
+	if err != nil {
+		return warns, err
+	}
+
+	tmp, errGo := ioutil.TempDir(filepath.Dir(dest), ".encrypted-")
+	if errGo != nil {
+		return warns, kv.Wrap(errGo).With("dest", dest).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(tmp)
+
+	if warns, err = storage.Fetch(ctx, art.Key, false, tmp); err != nil {
+		return warns, err
+	}
+
+	file, errGo := os.Open(filepath.Join(tmp, path.Base(art.Key)))
+	if errGo != nil {
+		return warns, kv.Wrap(errGo).With("key", art.Key).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer file.Close()
+
+	clear, err := NewDecryptReader(key, bufio.NewReader(file))
+	if err != nil {
+		return warns, err.With("key", art.Key)
+	}
+
+	if !art.Unpack {
+		fn := filepath.Join(dest, path.Base(art.Key))
+		out, errGo := os.OpenFile(fn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if errGo != nil {
+			return warns, kv.Wrap(errGo).With("file", fn).With("stack", stack.Trace().TrimRuntime())
+		}
+		_, errGo = io.Copy(out, clear)
+		out.Close()
+		if errGo != nil {
+			return warns, kv.Wrap(errGo).With("key", art.Key, "file", fn).With("stack", stack.Trace().TrimRuntime())
+		}
+		return warns, nil
+	}
+
+	fileType, w := MimeFromExt(art.Key)
+	if w != nil {
+		warns = append(warns, w)
+	}
+
+	if fileType == "application/zip" {
+		return warns, Unzip(ctx, clear, dest)
+	}
+
+	inReader, err := NewDecompressor(fileType, clear)
+	if err != nil {
+		return warns, err.With("key", art.Key)
+	}
+	defer inReader.Close()
+
+	return warns, Untar(ctx, inReader, dest)
+}
+
+// restoreEncrypted packs the source directory into an archive that is encrypted as it is
+// written to a staging area within the experiment directory, and then uploaded
+//
+func (cache *ArtifactCache) restoreEncrypted(ctx context.Context, storage *objStore, art *Artifact, source string, dir string) (warns []kv.Error, err kv.Error) {
+
+	key, err := artifactKey(ctx, art)
+	if err != nil {
+		return warns, err
+	}
+
+	if !IsArchive(art.Key) {
+		return warns, kv.NewError("uploads must be tar, tar compressed, or zip files").With("key", art.Key).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	files, err := NewTarWriter(source)
+	if err != nil {
+		return warns, err
+	}
+	if !files.HasFiles() {
+		return warns, nil
+	}
+
+	fileType, w := MimeFromExt(art.Key)
+	if w != nil {
+		warns = append(warns, w)
+	}
+
+	staging, errGo := ioutil.TempDir(dir, ".encrypted-")
+	if errGo != nil {
+		return warns, kv.Wrap(errGo).With("dir", dir).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(staging)
+
+	fn := filepath.Join(staging, path.Base(art.Key))
+	file, errGo := os.OpenFile(fn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if errGo != nil {
+		return warns, kv.Wrap(errGo).With("file", fn).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer file.Close()
+
+	outw := bufio.NewWriter(file)
+	enc, err := NewEncryptWriter(key, outw)
+	if err != nil {
+		return warns, err
+	}
+	if err = WriteArchive(fileType, files, enc); err != nil {
+		return warns, err.With("key", art.Key)
+	}
+	if errGo = enc.Close(); errGo != nil {
+		return warns, kv.Wrap(errGo).With("key", art.Key).With("stack", stack.Trace().TrimRuntime())
+	}
+	if errGo = outw.Flush(); errGo != nil {
+		return warns, kv.Wrap(errGo).With("key", art.Key).With("stack", stack.Trace().TrimRuntime())
+	}
+	if errGo = file.Close(); errGo != nil {
+		return warns, kv.Wrap(errGo).With("key", art.Key).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	w2, err := storage.Hoard(ctx, staging, path.Dir(art.Key))
+	return append(warns, w2...), err
+}
@@ -0,0 +1,170 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv"
+
+	minio "github.com/minio/minio-go"
+	"github.com/rs/xid"
+)
+
+// TestIncrementalEncrypted checks that artifacts requesting both incremental uploads and
+// encryption are rejected rather than being transferred as clear text
+func TestIncrementalEncrypted(t *testing.T) {
+
+	dir, errGo := ioutil.TempDir("", "incremental-encrypted")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(dir)
+
+	if errGo = ioutil.WriteFile(filepath.Join(dir, "output"), []byte(RandomString(1024)), 0600); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	art := &Artifact{
+		Bucket:      "bucket",
+		Key:         "output.tar",
+		Mutable:     true,
+		Incremental: true,
+		Encrypted:   true,
+		Qualified:   "file:///" + filepath.Join(dir, "storage"),
+	}
+
+	ctx := WithArtifactKey(context.Background(), [32]byte{})
+	cache := NewArtifactCache()
+
+	uploaded, _, err := cache.Restore(ctx, art, "project", "output", "", map[string]string{}, dir)
+	if errorCause(err) != ErrArtifactIncrementalEncrypted {
+		t.Fatal(kv.NewError("restore was not rejected").With("error", err).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if uploaded {
+		t.Fatal(kv.NewError("artifact was uploaded").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	if _, err = cache.Fetch(ctx, art, "project", "output", "", map[string]string{}, dir); errorCause(err) != ErrArtifactIncrementalEncrypted {
+		t.Fatal(kv.NewError("fetch was not rejected").With("error", err).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if _, errGo = os.Stat(filepath.Join(dir, "storage")); errGo == nil {
+		t.Fatal(kv.NewError("artifact was written to storage").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
+
+// TestEncryptedArtifact uploads an encrypted artifact to the minio test server and checks that
+// it is stored as cipher text, that it can be read back using the same key, and that
+// fetching it with a different key fails
+//
+func TestEncryptedArtifact(t *testing.T) {
+
+	InitTestingMinio(context.Background(), false)
+
+	timeoutAlive, aliveCancel := context.WithTimeout(context.Background(), time.Minute)
+	defer aliveCancel()
+
+	if alive, err := MinioTest.IsAlive(timeoutAlive); !alive || err != nil {
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Fatal("The minio test server is not available to run this test", MinioTest.Address)
+	}
+
+	dir, errGo := ioutil.TempDir("", "encrypted-artifact")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(dir)
+
+	// Uploading a placeholder creates the bucket used for the artifact
+	bucket := xid.New().String()
+	defer MinioTest.RemoveBucketAll(bucket)
+
+	placeholder := filepath.Join(dir, "placeholder")
+	if errGo = ioutil.WriteFile(placeholder, []byte("placeholder"), 0600); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if err := MinioTest.Upload(bucket, "placeholder", placeholder); err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte(RandomString(4096))
+	output := filepath.Join(dir, "upload", "output")
+	if errGo = os.MkdirAll(output, 0700); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if errGo = ioutil.WriteFile(filepath.Join(output, "model"), content, 0600); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	key := "experiments/encrypted/output.tar"
+	art := &Artifact{
+		Bucket:    bucket,
+		Key:       key,
+		Mutable:   true,
+		Unpack:    true,
+		Encrypted: true,
+		Qualified: "s3://" + MinioTest.Address + "/" + bucket + "/" + key,
+	}
+	env := map[string]string{
+		"MINIO_ACCESS_KEY":  MinioTest.AccessKeyId,
+		"MINIO_SECRET_KEY":  MinioTest.SecretAccessKeyId,
+		"MINIO_TEST_SERVER": MinioTest.Address,
+	}
+
+	dataKey := [32]byte{}
+	copy(dataKey[:], RandomString(len(dataKey)))
+	ctx := WithArtifactKey(context.Background(), dataKey)
+
+	cache := NewArtifactCache()
+	uploaded, _, err := cache.Restore(ctx, art.Clone(), "project", "output", "", env, filepath.Join(dir, "upload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !uploaded {
+		t.Fatal(kv.NewError("artifact was not uploaded").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// The stored artifact must not contain the clear text
+	obj, errGo := MinioTest.Client.GetObject(bucket, key, minio.GetObjectOptions{})
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	stored, errGo := ioutil.ReadAll(obj)
+	obj.Close()
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if len(stored) == 0 || bytes.Contains(stored, content[:64]) {
+		t.Fatal(kv.NewError("artifact was not stored encrypted").With("size", len(stored)).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	if _, err = cache.Fetch(ctx, art.Clone(), "project", "output", "", env, filepath.Join(dir, "download")); err != nil {
+		t.Fatal(err)
+	}
+	fetched, errGo := ioutil.ReadFile(filepath.Join(dir, "download", "output", "model"))
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if !bytes.Equal(fetched, content) {
+		t.Fatal(kv.NewError("fetched artifact differs from the upload").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	wrongKey := dataKey
+	wrongKey[0] ^= 0xff
+	wrongCtx := WithArtifactKey(context.Background(), wrongKey)
+	if _, err = cache.Fetch(wrongCtx, art.Clone(), "project", "output", "", env, filepath.Join(dir, "wrong")); err == nil {
+		t.Fatal(kv.NewError("artifact fetched using the wrong key").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if _, errGo = os.Stat(filepath.Join(dir, "wrong", "output", "model")); errGo == nil {
+		t.Fatal(kv.NewError("artifact decrypted using the wrong key").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
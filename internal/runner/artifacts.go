@@ -28,6 +28,13 @@ import (
 //
 type ArtifactCache struct {
 	upHashes map[string]uint64
+
+	// Used by incremental artifacts to track the content hashes of local files, and
+	// the content addressed blobs known to be present on the storage platform for
+	// each artifact key
+	fileHashes map[string]fileHash
+	upBlobs    map[string]map[string]struct{}
+
 	sync.Mutex
 
 	// This can be used by the application layer to receive diagnostic and other information
@@ -43,8 +50,10 @@ type ArtifactCache struct {
 //
 func NewArtifactCache() (cache *ArtifactCache) {
 	return &ArtifactCache{
-		upHashes: map[string]uint64{},
-		ErrorC:   make(chan kv.Error),
+		upHashes:   map[string]uint64{},
+		fileHashes: map[string]fileHash{},
+		upBlobs:    map[string]map[string]struct{}{},
+		ErrorC:     make(chan kv.Error),
 	}
 }
 
@@ -128,6 +137,10 @@ func (cache *ArtifactCache) Fetch(ctx context.Context, art *Artifact, projectId
 
 	kv := kv.With("artifact", fmt.Sprintf("%#v", *art)).With("project", projectId).With("group", group)
 
+	if err = art.Validate(); err != nil {
+		return warns, err.With("group", group)
+	}
+
 	// Process the qualified URI and use just the path for now
 	dest := filepath.Join(dir, group)
 	if errGo := os.MkdirAll(dest, 0700); errGo != nil {
@@ -150,8 +163,15 @@ func (cache *ArtifactCache) Fetch(ctx context.Context, art *Artifact, projectId
 		return warns, kv.Wrap(err).With("stack", stack.Trace().TrimRuntime())
 	}
 
-	if art.Unpack && !IsTar(art.Key) {
-		return warns, kv.NewError("the unpack flag was set for an unsupported file format (tar gzip/bzip2 only supported)").With("stack", stack.Trace().TrimRuntime())
+	if art.Unpack && !art.Incremental && !IsArchive(art.Key) {
+		return warns, kv.NewError("the unpack flag was set for an unsupported file format (tar gzip/bzip2/zstd/xz/lz4 or zip only supported)").With("stack", stack.Trace().TrimRuntime())
+	}
+
+	// Wait for room within the runner wide transfer budget before starting the download
+	release, err := AcquireTransfer(ctx)
+	if err != nil {
+		storage.Close()
+		return warns, err.With("group", group)
 	}
 
 	switch group {
@@ -160,8 +180,17 @@ func (cache *ArtifactCache) Fetch(ctx context.Context, art *Artifact, projectId
 		// experiment related retries rather than downloading an entire hosts worth of activity
 		// warns, err = storage.Gather(ctx, "metadata/", dest)
 	default:
-		warns, err = storage.Fetch(ctx, art.Key, art.Unpack, dest)
+		if art.Incremental {
+			warns, err = cache.fetchIncremental(ctx, storage, art, dest)
+		} else if art.Encrypted {
+			warns, err = cache.fetchEncrypted(ctx, storage, art, dest)
+		} else if art.Unpack && !art.Mutable && treesEnabled() {
+			warns, err = cache.fetchTree(ctx, storage, art, dest)
+		} else {
+			warns, err = storage.Fetch(ctx, art.Key, art.Unpack, dest)
+		}
 	}
+	release()
 	storage.Close()
 
 	if err != nil {
@@ -230,6 +259,10 @@ func (cache *ArtifactCache) Local(group string, dir string, file string) (fn str
 //
 func (cache *ArtifactCache) Restore(ctx context.Context, art *Artifact, projectId string, group string, cred string, env map[string]string, dir string) (uploaded bool, warns []kv.Error, err kv.Error) {
 
+	if err = art.Validate(); err != nil {
+		return false, warns, err.With("group", group)
+	}
+
 	// Immutable artifacts need just to be downloaded and nothing else
 	if !art.Mutable {
 		return false, warns, nil
@@ -267,6 +300,13 @@ func (cache *ArtifactCache) Restore(ctx context.Context, art *Artifact, projectI
 
 	hash, errHash := readAllHash(dir)
 
+	// Wait for room within the runner wide transfer budget before starting the upload
+	release, err := AcquireTransfer(ctx)
+	if err != nil {
+		return false, warns, err.With("group", group)
+	}
+	defer release()
+
 	switch group {
 	case "_metadata":
 		// If no metadata exists, which could be legitimate, dont try and save it
@@ -277,7 +317,18 @@ func (cache *ArtifactCache) Restore(ctx context.Context, art *Artifact, projectI
 			}
 		}
 	default:
-		if warns, err = storage.Deposit(ctx, source, art.Key); err != nil {
+		if art.Incremental {
+			// Incremental artifacts only upload the files that have changed
+			// along with a fresh manifest
+			if warns, err = cache.restoreIncremental(ctx, storage, art, source, dir); err != nil {
+				return false, warns, err.With("group", group)
+			}
+		} else if art.Encrypted {
+			// Encrypted artifacts are packed and encrypted locally before being uploaded
+			if warns, err = cache.restoreEncrypted(ctx, storage, art, source, dir); err != nil {
+				return false, warns, err.With("group", group)
+			}
+		} else if warns, err = storage.Deposit(ctx, source, art.Key); err != nil {
 			return false, warns, err.With("group", group)
 		}
 	}
@@ -3,7 +3,9 @@
 package runner
 
 import (
+	"bufio"
 	"crypto/rand"
+	"encoding/binary"
 	"io"
 
 	"github.com/go-stack/stack"
@@ -58,3 +60,179 @@ func DecryptBlock(key [32]byte, in []byte) (clear []byte, err kv.Error) {
 
 	return decrypted, nil
 }
+
+// The streaming form of the block encryption splits the data into chunks that are each sealed
+// using secretbox.  The stream starts with a magic value and a random 16 byte nonce prefix, the
+// nonce for each chunk is the prefix followed by a big endian chunk counter.  The high bit of
+// the counter is set for the last chunk so that truncated streams can be detected, and the
+// counter prevents chunks from being reordered.
+
+const (
+	// StreamChunkSize is the amount of clear text sealed within each chunk of an encrypted stream
+	StreamChunkSize = 64 * 1024
+
+	streamFinal = uint64(1) << 63
+)
+
+var (
+	streamMagic = [4]byte{'S', 'M', 'e', '1'}
+)
+
+type encryptWriter struct {
+	key     [32]byte
+	prefix  [16]byte
+	counter uint64
+	buffer  []byte
+	out     io.Writer
+	closed  bool
+}
+
+func (enc *encryptWriter) nonce(final bool) (nonce [24]byte) {
+	copy(nonce[:], enc.prefix[:])
+	counter := enc.counter
+	if final {
+		counter |= streamFinal
+	}
+	binary.BigEndian.PutUint64(nonce[16:], counter)
+	return nonce
+}
+
+// NewEncryptWriter returns a writer that encrypts the data written to it using the
+// supplied key.  Close must be called to seal the final chunk, closing the writer does
+// not close the underlying writer.
+//
+func NewEncryptWriter(key [32]byte, out io.Writer) (w io.WriteCloser, err kv.Error) {
+	enc := &encryptWriter{
+		key:    key,
+		buffer: make([]byte, 0, StreamChunkSize),
+		out:    out,
+	}
+	if _, errGo := io.ReadFull(rand.Reader, enc.prefix[:]); errGo != nil {
+		return nil, kv.Wrap(errGo, "nonce could not be generated").With("stack", stack.Trace().TrimRuntime())
+	}
+	if _, errGo := out.Write(streamMagic[:]); errGo != nil {
+		return nil, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	if _, errGo := out.Write(enc.prefix[:]); errGo != nil {
+		return nil, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	return enc, nil
+}
+
+func (enc *encryptWriter) seal(final bool) (errGo error) {
+	nonce := enc.nonce(final)
+	sealed := secretbox.Seal(nil, enc.buffer, &nonce, &enc.key)
+	enc.buffer = enc.buffer[:0]
+	enc.counter++
+	_, errGo = enc.out.Write(sealed)
+	return errGo
+}
+
+// Write buffers the clear text and seals each chunk once it is known not to be the last
+func (enc *encryptWriter) Write(p []byte) (n int, errGo error) {
+	if enc.closed {
+		return 0, kv.NewError("write on closed encryption stream").With("stack", stack.Trace().TrimRuntime())
+	}
+	for len(p) != 0 {
+		if len(enc.buffer) == StreamChunkSize {
+			if errGo = enc.seal(false); errGo != nil {
+				return n, errGo
+			}
+		}
+		copied := copy(enc.buffer[len(enc.buffer):cap(enc.buffer)], p)
+		enc.buffer = enc.buffer[:len(enc.buffer)+copied]
+		p = p[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// Close seals the final chunk of the stream
+func (enc *encryptWriter) Close() (errGo error) {
+	if enc.closed {
+		return nil
+	}
+	enc.closed = true
+	return enc.seal(true)
+}
+
+type decryptReader struct {
+	key     [32]byte
+	prefix  [16]byte
+	counter uint64
+	in      *bufio.Reader
+	sealed  []byte
+	clear   []byte
+	final   bool
+}
+
+// NewDecryptReader returns a reader that decrypts a stream produced by an encryption writer
+// using the supplied key.  Streams that have been truncated, reordered, or tampered with will
+// result in errors being returned by Read.
+//
+func NewDecryptReader(key [32]byte, in io.Reader) (r io.Reader, err kv.Error) {
+	dec := &decryptReader{
+		key:    key,
+		in:     bufio.NewReader(in),
+		sealed: make([]byte, StreamChunkSize+secretbox.Overhead),
+	}
+	magic := [4]byte{}
+	if _, errGo := io.ReadFull(dec.in, magic[:]); errGo != nil {
+		return nil, kv.Wrap(errGo, "encrypted stream header missing").With("stack", stack.Trace().TrimRuntime())
+	}
+	if magic != streamMagic {
+		return nil, kv.NewError("not an encrypted stream").With("stack", stack.Trace().TrimRuntime())
+	}
+	if _, errGo := io.ReadFull(dec.in, dec.prefix[:]); errGo != nil {
+		return nil, kv.Wrap(errGo, "encrypted stream header missing").With("stack", stack.Trace().TrimRuntime())
+	}
+	return dec, nil
+}
+
+func (dec *decryptReader) open() (errGo error) {
+	n, errGo := io.ReadFull(dec.in, dec.sealed)
+	switch errGo {
+	case nil:
+		// A full chunk is only the last one if nothing follows it
+		if _, errGo = dec.in.Peek(1); errGo == io.EOF {
+			dec.final = true
+		} else if errGo != nil {
+			return errGo
+		}
+	case io.ErrUnexpectedEOF, io.EOF:
+		dec.final = true
+	default:
+		return errGo
+	}
+
+	nonce := [24]byte{}
+	copy(nonce[:], dec.prefix[:])
+	counter := dec.counter
+	if dec.final {
+		counter |= streamFinal
+	}
+	binary.BigEndian.PutUint64(nonce[16:], counter)
+
+	clear, ok := secretbox.Open(dec.clear[:0], dec.sealed[:n], &nonce, &dec.key)
+	if !ok {
+		return kv.NewError("decryption failure").With("chunk", dec.counter).With("stack", stack.Trace().TrimRuntime())
+	}
+	dec.clear = clear
+	dec.counter++
+	return nil
+}
+
+// Read returns the decrypted contents of the stream
+func (dec *decryptReader) Read(p []byte) (n int, errGo error) {
+	for len(dec.clear) == 0 {
+		if dec.final {
+			return 0, io.EOF
+		}
+		if errGo = dec.open(); errGo != nil {
+			return 0, errGo
+		}
+	}
+	n = copy(p, dec.clear)
+	dec.clear = dec.clear[n:]
+	return n, nil
+}
@@ -3,11 +3,16 @@
 package runner
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
 	"strings"
 	"testing"
 
 	"github.com/go-stack/stack"
 	"github.com/jjeffery/kv"
+
+	"golang.org/x/crypto/nacl/secretbox"
 )
 
 // TestCrypt is used to validate the AES large block/file style of symetric encryption
@@ -36,3 +41,52 @@ func TestCrypt(t *testing.T) {
 		t.Fatal(kv.NewError("bad key was accepted").With("stack", stack.Trace().TrimRuntime()))
 	}
 }
+
+// TestStreamCrypt validates the streaming form of the symetric encryption including
+// data that straddles chunk boundaries and streams that have been truncated
+func TestStreamCrypt(t *testing.T) {
+	key, _, err := EncryptBlock([]byte{})
+	if err != nil {
+		t.Fatal(err.With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	for _, size := range []int{0, 1, StreamChunkSize - 1, StreamChunkSize, 3*StreamChunkSize + 7} {
+		data := RandomString(size)
+
+		encrypted := &bytes.Buffer{}
+		enc, err := NewEncryptWriter(key, encrypted)
+		if err != nil {
+			t.Fatal(err.With("stack", stack.Trace().TrimRuntime()))
+		}
+		if _, errGo := io.Copy(enc, strings.NewReader(data)); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("size", size).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if errGo := enc.Close(); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("size", size).With("stack", stack.Trace().TrimRuntime()))
+		}
+		sealed := encrypted.Bytes()
+
+		dec, err := NewDecryptReader(key, bytes.NewReader(sealed))
+		if err != nil {
+			t.Fatal(err.With("size", size).With("stack", stack.Trace().TrimRuntime()))
+		}
+		decrypted, errGo := ioutil.ReadAll(dec)
+		if errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("size", size).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if strings.Compare(data, string(decrypted)) != 0 {
+			t.Fatal(kv.NewError("stream encryption decryption cycle failed").With("size", size).With("stack", stack.Trace().TrimRuntime()))
+		}
+
+		// Dropping the last chunk of a multi chunk stream must be detected
+		if size > StreamChunkSize {
+			truncated := sealed[:len(sealed)-(size%StreamChunkSize)-secretbox.Overhead]
+			if dec, err = NewDecryptReader(key, bytes.NewReader(truncated)); err != nil {
+				t.Fatal(err.With("size", size).With("stack", stack.Trace().TrimRuntime()))
+			}
+			if _, errGo = ioutil.ReadAll(dec); errGo == nil {
+				t.Fatal(kv.NewError("truncated stream was accepted").With("size", size).With("stack", stack.Trace().TrimRuntime()))
+			}
+		}
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of a persistent index for the artifact cache.  The
+// index records the provenance and usage of every file in the cache directory so that the
+// LRU ordering, pinning, and partial downloads survive restarts of the runner.  The index is
+// held within a bolt database inside a hidden directory of the cache so that it is not
+// mistaken for a cached file by the groomer.
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/boltdb/bolt" // MIT License
+	"github.com/karlmutch/ccache"
+)
+
+// CacheEntry describes a single artifact that is present, or being downloaded into the
+// artifact cache
+//
+type CacheEntry struct {
+	Hash       string    `json:"hash"`      // The storage platform hash of the artifact, also the file name within the cache
+	Key        string    `json:"key"`       // The key for the artifact within its bucket
+	Bucket     string    `json:"bucket"`    // The bucket the artifact was retrieved from
+	Qualified  string    `json:"qualified"` // The fully qualified URI used to retrieve the artifact
+	Size       int64     `json:"size"`
+	Created    time.Time `json:"created"`
+	LastAccess time.Time `json:"last_access"`
+	Hits       uint64    `json:"hits"`
+	Pinned     bool      `json:"pinned"`  // Pinned artifacts are not evicted from the cache
+	Partial    bool      `json:"partial"` // The download for this artifact has not yet completed
+}
+
+type cacheIndex struct {
+	db *bolt.DB
+}
+
+var (
+	cacheIndexBucket = []byte("entries")
+
+	index *cacheIndex
+
+	// Partial downloads that were interrupted by a restart and which can be resumed
+	// by the next fetch of the same artifact
+	resumable     = map[string]struct{}{}
+	resumableSync sync.Mutex
+)
+
+// openCacheIndex opens, or creates, the index database within the backing directory of a cache
+//
+func openCacheIndex(backing string) (idx *cacheIndex, err kv.Error) {
+	dir := filepath.Join(backing, ".index")
+	if errGo := os.MkdirAll(dir, 0700); errGo != nil {
+		return nil, kv.Wrap(errGo, "unable to create the cache index dir").With("dir", dir).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	fn := filepath.Join(dir, "cache.db")
+
+	// Only one runner is permitted to use the cache directory at any one time, so we
+	// dont wait indefinitely on the lock for the index
+	db, errGo := bolt.Open(fn, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if errGo != nil {
+		return nil, kv.Wrap(errGo, "cache index could not be opened").With("file", fn).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	errGo = db.Update(func(tx *bolt.Tx) error {
+		_, errGo := tx.CreateBucketIfNotExists(cacheIndexBucket)
+		return errGo
+	})
+	if errGo != nil {
+		db.Close()
+		return nil, kv.Wrap(errGo).With("file", fn).With("stack", stack.Trace().TrimRuntime())
+	}
+	return &cacheIndex{db: db}, nil
+}
+
+// Close releases the index database
+//
+func (idx *cacheIndex) Close() {
+	idx.db.Close()
+}
+
+func (idx *cacheIndex) get(hash string) (entry *CacheEntry, err kv.Error) {
+	errGo := idx.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(cacheIndexBucket).Get([]byte(hash))
+		if value == nil {
+			return nil
+		}
+		entry = &CacheEntry{}
+		return json.Unmarshal(value, entry)
+	})
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("hash", hash).With("stack", stack.Trace().TrimRuntime())
+	}
+	return entry, nil
+}
+
+func (idx *cacheIndex) put(entry *CacheEntry) (err kv.Error) {
+	value, errGo := json.Marshal(entry)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("hash", entry.Hash).With("stack", stack.Trace().TrimRuntime())
+	}
+	errGo = idx.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(cacheIndexBucket).Put([]byte(entry.Hash), value)
+	})
+	if errGo != nil {
+		return kv.Wrap(errGo).With("hash", entry.Hash).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// update applies the modify function to an existing entry within a single transaction,
+// entries that are not present are left absent
+//
+func (idx *cacheIndex) update(hash string, modify func(entry *CacheEntry)) (err kv.Error) {
+	errGo := idx.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(cacheIndexBucket)
+		value := bucket.Get([]byte(hash))
+		if value == nil {
+			return nil
+		}
+		entry := &CacheEntry{}
+		if errGo := json.Unmarshal(value, entry); errGo != nil {
+			return errGo
+		}
+		modify(entry)
+		value, errGo := json.Marshal(entry)
+		if errGo != nil {
+			return errGo
+		}
+		return bucket.Put([]byte(hash), value)
+	})
+	if errGo != nil {
+		return kv.Wrap(errGo).With("hash", hash).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+func (idx *cacheIndex) remove(hash string) (err kv.Error) {
+	errGo := idx.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(cacheIndexBucket).Delete([]byte(hash))
+	})
+	if errGo != nil {
+		return kv.Wrap(errGo).With("hash", hash).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// entries returns all of the entries in the index ordered from the least to the most
+// recently accessed
+//
+func (idx *cacheIndex) entries() (entries []CacheEntry, err kv.Error) {
+	entries = []CacheEntry{}
+	errGo := idx.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(cacheIndexBucket).ForEach(func(k []byte, v []byte) error {
+			entry := CacheEntry{}
+			if errGo := json.Unmarshal(v, &entry); errGo != nil {
+				return errGo
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastAccess.Before(entries[j].LastAccess)
+	})
+	return entries, nil
+}
+
+// touch records a successful retrieval of an artifact from the cache
+//
+func (idx *cacheIndex) touch(hash string) (err kv.Error) {
+	return idx.update(hash, func(entry *CacheEntry) {
+		entry.Hits++
+		entry.LastAccess = time.Now()
+	})
+}
+
+// CachedArtifacts returns the completed entries in the artifact cache that were retrieved
+// from the named bucket, or all entries if the bucket is empty.  Entries are ordered from the
+// least to the most recently used.
+//
+func CachedArtifacts(bucket string) (entries []CacheEntry, err kv.Error) {
+	if index == nil {
+		return nil, kv.NewError("cache is not initialized").With("stack", stack.Trace().TrimRuntime())
+	}
+	all, err := index.entries()
+	if err != nil {
+		return nil, err
+	}
+	entries = make([]CacheEntry, 0, len(all))
+	for _, entry := range all {
+		if entry.Partial {
+			continue
+		}
+		if len(bucket) != 0 && entry.Bucket != bucket {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// CachePin is used to prevent, or once again permit, a cached artifact from being evicted
+// from the cache
+//
+func CachePin(hash string, pinned bool) (err kv.Error) {
+	if index == nil || !cacheReady() {
+		return kv.NewError("cache is not initialized").With("stack", stack.Trace().TrimRuntime())
+	}
+	entry, err := index.get(hash)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return kv.NewError("artifact not cached").With("hash", hash).With("stack", stack.Trace().TrimRuntime())
+	}
+	if entry.Pinned == pinned {
+		return nil
+	}
+	if err = index.update(hash, func(entry *CacheEntry) { entry.Pinned = pinned }); err != nil {
+		return err
+	}
+
+	// The in memory cache tracks pinned items to prevent them being chosen for eviction
+	withCache(func(c *ccache.Cache) {
+		if pinned {
+			c.TrackingGet(hash)
+		} else if item := c.Sample(hash); item != nil {
+			item.Release()
+		}
+	})
+	return nil
+}
+
+// isPinned is used by the groomer to avoid removing pinned files from the cache
+//
+func isPinned(hash string) bool {
+	if index == nil {
+		return false
+	}
+	entry, err := index.get(hash)
+	return err == nil && entry != nil && entry.Pinned
+}
+
+// claimResumable returns true when the caller is the first to ask for a partial download
+// that was interrupted by a restart, the caller then becomes responsible for its completion
+//
+func claimResumable(hash string) (claimed bool) {
+	resumableSync.Lock()
+	defer resumableSync.Unlock()
+
+	if _, claimed = resumable[hash]; claimed {
+		delete(resumable, hash)
+	}
+	return claimed
+}
+
+// loadCacheIndex reconciles the index with the files found in the cache directory, loading
+// the in memory cache in least recently used order so that eviction after a restart honors
+// the access history from before the restart.  Partial downloads with an index entry are
+// retained so that they can be resumed, all others are discarded.
+//
+func loadCacheIndex(c *ccache.Cache, idx *cacheIndex, backing string, cachedFiles []os.FileInfo, lifetime time.Duration) (err kv.Error) {
+	present := make(map[string]os.FileInfo, len(cachedFiles))
+	for _, file := range cachedFiles {
+		if file.IsDir() || file.Name()[0] == '.' {
+			continue
+		}
+		present[file.Name()] = file
+	}
+
+	partialDir := filepath.Join(backing, ".partial")
+	partials, _ := filepath.Glob(filepath.Join(partialDir, "*"))
+
+	entries, err := idx.entries()
+	if err != nil {
+		return err
+	}
+
+	known := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		known[entry.Hash] = struct{}{}
+		if entry.Partial {
+			continue
+		}
+		info, isPresent := present[entry.Hash]
+		if !isPresent {
+			if err = idx.remove(entry.Hash); err != nil {
+				return err
+			}
+			continue
+		}
+		ttl := lifetime - time.Since(entry.LastAccess)
+		if entry.Pinned {
+			ttl = lifetime
+		}
+		c.Set(entry.Hash, withTree(backing, entry.Hash, info), ttl)
+		if entry.Pinned {
+			c.TrackingGet(entry.Hash)
+		}
+	}
+
+	// Files that are unknown to the index, for example those from a cache populated before
+	// the index was introduced, are treated as having been used when last modified
+	for hash, info := range present {
+		if _, isPresent := known[hash]; isPresent {
+			continue
+		}
+		entry := &CacheEntry{
+			Hash:       hash,
+			Size:       info.Size(),
+			Created:    info.ModTime(),
+			LastAccess: info.ModTime(),
+		}
+		if err = idx.put(entry); err != nil {
+			return err
+		}
+		c.Set(hash, withTree(backing, hash, info), lifetime-time.Since(entry.LastAccess))
+	}
+
+	resumableSync.Lock()
+	defer resumableSync.Unlock()
+
+	for _, partial := range partials {
+		hash := filepath.Base(partial)
+		entry, err := idx.get(hash)
+		if err != nil {
+			return err
+		}
+		if entry != nil && entry.Partial {
+			resumable[hash] = struct{}{}
+			continue
+		}
+		os.RemoveAll(partial)
+	}
+
+	// Entries for partial downloads whose files have gone are discarded
+	for _, entry := range entries {
+		if _, isPresent := resumable[entry.Hash]; entry.Partial && !isPresent {
+			if err = idx.remove(entry.Hash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv"
+
+	"github.com/karlmutch/ccache"
+)
+
+// TestCacheIndexReload validates that the cache index survives being closed and reopened
+// and that the files, and partial downloads found in the cache directory are reconciled
+// against it
+func TestCacheIndexReload(t *testing.T) {
+	backing, errGo := ioutil.TempDir("", "cache-index")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(backing)
+
+	if errGo = os.MkdirAll(filepath.Join(backing, ".partial"), 0700); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	for _, name := range []string{"old", "new", "unknown", ".partial/resumable", ".partial/orphan"} {
+		if errGo = ioutil.WriteFile(filepath.Join(backing, name), []byte(name), 0600); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+
+	idx, err := openCacheIndex(backing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, entry := range []*CacheEntry{
+		{Hash: "new", Bucket: "a", LastAccess: now},
+		{Hash: "old", Bucket: "b", LastAccess: now.Add(-time.Hour)},
+		{Hash: "missing", Bucket: "a", LastAccess: now},
+		{Hash: "resumable", Bucket: "a", LastAccess: now, Partial: true},
+	} {
+		if err = idx.put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = idx.touch("new"); err != nil {
+		t.Fatal(err)
+	}
+	idx.Close()
+
+	// Reopen the index as would be done when the runner restarts
+	if idx, err = openCacheIndex(backing); err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	cachedFiles, errGo := ioutil.ReadDir(backing)
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	priorCache, priorIndex := cache, index
+	defer func() {
+		cache, index = priorCache, priorIndex
+	}()
+	cache = ccache.New(ccache.Configure().MaxSize(1024 * 1024).Track())
+	defer cache.Stop()
+	index = idx
+
+	if err = loadCacheIndex(cache, idx, backing, cachedFiles, 48*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := CachedArtifacts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[len(entries)-1].Hash != "new" || entries[len(entries)-1].Hits != 1 {
+		t.Fatal(kv.NewError("unexpected cache entries").With("entries", entries).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if entries, err = CachedArtifacts("b"); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Hash != "old" {
+		t.Fatal(kv.NewError("bucket query failed").With("entries", entries).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	if !claimResumable("resumable") || claimResumable("resumable") {
+		t.Fatal(kv.NewError("partial download was not resumable exactly once").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if _, errGo = os.Stat(filepath.Join(backing, ".partial", "orphan")); errGo == nil {
+		t.Fatal(kv.NewError("partial download unknown to the index was retained").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	if err = CachePin("old", true); err != nil {
+		t.Fatal(err)
+	}
+	if !isPinned("old") || isPinned("new") {
+		t.Fatal(kv.NewError("pinning failed").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if err = CachePin("missing", true); err == nil {
+		t.Fatal(kv.NewError("artifact missing from the cache was pinned").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
@@ -0,0 +1,383 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of artifact sharing between the caches of runners
+// within the same cluster.  Each runner serves the completed files within its cache over
+// HTTP, and periodically collects the hashes its peers have advertised.  When an artifact is
+// not in the local cache and a peer has advertised it, the artifact is downloaded from the
+// peer and verified before the origin storage platform is used.  Requests between peers
+// must carry a shared secret so that the artifacts, which would otherwise need the storage
+// platform credentials to be retrieved, are only served to other runners.
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/lthibault/jitterbug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	peerHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_cache_peer_hits",
+			Help: "Number of artifacts retrieved from the caches of peer runners.",
+		},
+		[]string{"host"},
+	)
+	peerFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_cache_peer_failures",
+			Help: "Number of artifacts advertised by peer runners that could not be retrieved, or failed validation.",
+		},
+		[]string{"host"},
+	)
+
+	// Only plain MD5 hashes can be validated once the file has been retrieved from a peer,
+	// composite hashes such as those from S3 multipart uploads are always fetched from the origin
+	plainMD5 = regexp.MustCompile("^[0-9a-f]{32}$")
+
+	peers *cachePeers
+)
+
+const (
+	peerHashesPath  = "/cache/hashes"
+	peerObjectsPath = "/cache/objects/"
+)
+
+type cachePeers struct {
+	discover string              // The peer specification supplied by the runner operator
+	secret   string              // The shared secret peers present as a bearer token
+	self     map[string]struct{} // Addresses that belong to this runner
+	client   *http.Client        // The client used for all peer requests
+	hashes   map[string][]string // The peers that have advertised a hash
+	sync.Mutex
+}
+
+// StartCachePeers will begin serving the files in the artifact cache to other runners
+// using the address supplied, and will periodically collect the hashes advertised by the
+// peers named in discover.  discover is a comma separated list of host:port pairs, entries
+// that have a "dns:" prefix are resolved to all of their addresses on every refresh which
+// allows a Kubernetes headless service to be used to locate peers.  secret must be shared by
+// all of the peers and is used to authenticate their requests.
+//
+func StartCachePeers(ctx context.Context, address string, discover string, secret string, errorC chan kv.Error) (err kv.Error) {
+	if index == nil || !cacheReady() {
+		return kv.NewError("cache is not initialized").With("stack", stack.Trace().TrimRuntime())
+	}
+	if len(secret) == 0 {
+		return kv.NewError("cache peers require a shared secret").With("address", address).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	listener, errGo := net.Listen("tcp", address)
+	if errGo != nil {
+		return kv.Wrap(errGo, "cache peer address could not be used").With("address", address).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	p := &cachePeers{
+		discover: discover,
+		secret:   secret,
+		self:     localAddresses(listener.Addr()),
+		client:   &http.Client{Timeout: 10 * time.Minute},
+		hashes:   map[string][]string{},
+	}
+
+	server := &http.Server{Handler: p.handler()}
+
+	for _, metric := range []prometheus.Collector{peerHits, peerFailures} {
+		if errGo = prometheus.Register(metric); errGo != nil {
+			select {
+			case errorC <- kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()):
+			default:
+			}
+		}
+	}
+
+	go func() {
+		if errGo := server.Serve(listener); errGo != nil && errGo != http.ErrServerClosed {
+			select {
+			case errorC <- kv.Wrap(errGo, "cache peer server failed").With("address", address).With("stack", stack.Trace().TrimRuntime()):
+			default:
+			}
+		}
+	}()
+
+	go func() {
+		check := NewTrigger(nil, time.Second*30, &jitterbug.Norm{Stdev: time.Second * 3})
+		defer check.Stop()
+
+		p.refresh(ctx, errorC)
+		for {
+			select {
+			case <-check.C:
+				p.refresh(ctx, errorC)
+			case <-ctx.Done():
+				server.Close()
+				return
+			}
+		}
+	}()
+
+	peers = p
+	return nil
+}
+
+// handler returns the HTTP handler that serves the cache contents to peers presenting the
+// shared secret
+//
+func (p *cachePeers) handler() (handler http.Handler) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(peerHashesPath, serveCacheHashes)
+	mux.HandleFunc(peerObjectsPath, serveCacheObject)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(p.secret)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// newRequest creates a request to a peer that carries the shared secret
+//
+func (p *cachePeers) newRequest(ctx context.Context, url string) (req *http.Request, err kv.Error) {
+	req, errGo := http.NewRequest(http.MethodGet, url, nil)
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("url", url).With("stack", stack.Trace().TrimRuntime())
+	}
+	req.Header.Set("Authorization", "Bearer "+p.secret)
+	return req.WithContext(ctx), nil
+}
+
+// localAddresses returns the host:port combinations that a peer could use to reach the listener
+// so that the runner does not treat itself as a peer
+//
+func localAddresses(listening net.Addr) (self map[string]struct{}) {
+	self = map[string]struct{}{}
+
+	_, port, errGo := net.SplitHostPort(listening.String())
+	if errGo != nil {
+		return self
+	}
+	self[net.JoinHostPort("localhost", port)] = struct{}{}
+	if hostName, errGo := os.Hostname(); errGo == nil {
+		self[net.JoinHostPort(hostName, port)] = struct{}{}
+	}
+
+	addrs, errGo := net.InterfaceAddrs()
+	if errGo != nil {
+		return self
+	}
+	for _, addr := range addrs {
+		if ipNet, isIP := addr.(*net.IPNet); isIP {
+			self[net.JoinHostPort(ipNet.IP.String(), port)] = struct{}{}
+		}
+	}
+	return self
+}
+
+// resolve expands the peer specification into the list of peer host:port pairs
+//
+func (p *cachePeers) resolve() (addrs []string, warns []kv.Error) {
+	addrs = []string{}
+	for _, item := range strings.Split(p.discover, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(item, "dns:") {
+			addrs = append(addrs, item)
+			continue
+		}
+		host, port, errGo := net.SplitHostPort(strings.TrimPrefix(item, "dns:"))
+		if errGo != nil {
+			warns = append(warns, kv.Wrap(errGo).With("peer", item).With("stack", stack.Trace().TrimRuntime()))
+			continue
+		}
+		ips, errGo := net.LookupHost(host)
+		if errGo != nil {
+			warns = append(warns, kv.Wrap(errGo).With("peer", item).With("stack", stack.Trace().TrimRuntime()))
+			continue
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+	}
+	return addrs, warns
+}
+
+// refresh collects the hashes currently advertised by every peer
+//
+func (p *cachePeers) refresh(ctx context.Context, errorC chan kv.Error) {
+	addrs, warns := p.resolve()
+
+	hashes := map[string][]string{}
+	for _, addr := range addrs {
+		if _, isSelf := p.self[addr]; isSelf {
+			continue
+		}
+		advertised, err := p.advertised(ctx, addr)
+		if err != nil {
+			warns = append(warns, err)
+			continue
+		}
+		for _, hash := range advertised {
+			hashes[hash] = append(hashes[hash], addr)
+		}
+	}
+
+	p.Lock()
+	p.hashes = hashes
+	p.Unlock()
+
+	for _, warn := range warns {
+		select {
+		case errorC <- warn:
+		default:
+		}
+	}
+}
+
+func (p *cachePeers) advertised(ctx context.Context, addr string) (hashes []string, err kv.Error) {
+	url := fmt.Sprintf("http://%s%s", addr, peerHashesPath)
+	req, err := p.newRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	resp, errGo := p.client.Do(req)
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("url", url).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, kv.NewError("peer hashes unavailable").With("url", url, "status", resp.Status).With("stack", stack.Trace().TrimRuntime())
+	}
+	if errGo = json.NewDecoder(resp.Body).Decode(&hashes); errGo != nil {
+		return nil, kv.Wrap(errGo).With("url", url).With("stack", stack.Trace().TrimRuntime())
+	}
+	return hashes, nil
+}
+
+// fetch attempts to retrieve the file with the supplied hash from any peer that has advertised
+// it, writing it to the output file.  When the function returns an error the contents of the
+// output file are undefined and the caller should fall back to the origin.
+//
+func (p *cachePeers) fetch(ctx context.Context, hash string, output *os.File) (err kv.Error) {
+	if !plainMD5.MatchString(hash) {
+		return kv.NewError("hash cannot be validated").With("hash", hash).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	p.Lock()
+	addrs := append([]string{}, p.hashes[hash]...)
+	p.Unlock()
+
+	if len(addrs) == 0 {
+		return kv.NewError("artifact not advertised by any peer").With("hash", hash).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	for _, addr := range addrs {
+		if err = p.fetchFrom(ctx, addr, hash, output); err == nil {
+			peerHits.With(prometheus.Labels{"host": host}).Inc()
+			return nil
+		}
+		peerFailures.With(prometheus.Labels{"host": host}).Inc()
+	}
+	return err
+}
+
+func (p *cachePeers) fetchFrom(ctx context.Context, addr string, hash string, output *os.File) (err kv.Error) {
+	if _, errGo := output.Seek(0, io.SeekStart); errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	if errGo := output.Truncate(0); errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	url := fmt.Sprintf("http://%s%s%s", addr, peerObjectsPath, hash)
+	req, err := p.newRequest(ctx, url)
+	if err != nil {
+		return err
+	}
+	resp, errGo := p.client.Do(req)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("url", url).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return kv.NewError("peer artifact unavailable").With("url", url, "status", resp.Status).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	digest := md5.New()
+	if _, errGo = io.Copy(io.MultiWriter(output, digest), NewThrottledReader(ctx, resp.Body)); errGo != nil {
+		return kv.Wrap(errGo).With("url", url).With("stack", stack.Trace().TrimRuntime())
+	}
+	if actual := hex.EncodeToString(digest.Sum(nil)); actual != hash {
+		return kv.NewError("peer artifact hash mismatch").With("url", url, "actual", actual).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// serveCacheHashes responds with a JSON list of the hashes of the files that are complete
+// within the local cache
+//
+func serveCacheHashes(w http.ResponseWriter, r *http.Request) {
+	entries, err := CachedArtifacts("")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	hashes := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		hashes = append(hashes, entry.Hash)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hashes)
+}
+
+// serveCacheObject responds with the contents of a single completed file from the local cache
+//
+func serveCacheObject(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(r.URL.Path, peerObjectsPath)
+	if len(hash) == 0 || hash[0] == '.' || strings.ContainsAny(hash, "/\\") {
+		http.Error(w, "invalid hash", http.StatusBadRequest)
+		return
+	}
+	if entry, err := index.get(hash); err != nil || entry == nil || entry.Partial {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, errGo := os.Open(filepath.Join(backingDir, hash))
+	if errGo != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	// Serving a peer counts as a use of the file so that popular files are retained
+	index.touch(hash)
+
+	http.ServeContent(w, r, hash, time.Time{}, file)
+}
@@ -0,0 +1,115 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv"
+
+	"github.com/karlmutch/ccache"
+)
+
+// TestCachePeers validates that a cached file can be retrieved from a peer, that files
+// with content that does not match the advertised hash are rejected, and that requests
+// without the shared secret are refused
+func TestCachePeers(t *testing.T) {
+	backing, errGo := ioutil.TempDir("", "cache-peers")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(backing)
+
+	idx, err := openCacheIndex(backing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	priorCache, priorIndex, priorBacking := cache, index, backingDir
+	defer func() {
+		cache, index, backingDir = priorCache, priorIndex, priorBacking
+	}()
+	cache = ccache.New(ccache.Configure().MaxSize(1024 * 1024).Track())
+	defer cache.Stop()
+	index = idx
+	backingDir = backing
+
+	// Place one valid file and one file whose contents do not match its name into the cache
+	data := RandomString(16 * 1024)
+	sum := md5.Sum([]byte(data))
+	good := hex.EncodeToString(sum[:])
+	bad := strings.Repeat("0", 32)
+	for _, hash := range []string{good, bad} {
+		if errGo = ioutil.WriteFile(filepath.Join(backing, hash), []byte(data), 0600); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if err = idx.put(&CacheEntry{Hash: hash, LastAccess: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &cachePeers{
+		secret: RandomString(32),
+		self:   map[string]struct{}{},
+		hashes: map[string][]string{},
+	}
+	server := httptest.NewServer(p.handler())
+	defer server.Close()
+
+	p.discover = strings.TrimPrefix(server.URL, "http://")
+	p.client = server.Client()
+
+	// Requests that do not present the shared secret must be refused
+	resp, errGo := p.client.Get(server.URL + peerObjectsPath + good)
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal(kv.NewError("unauthenticated request was served").With("status", resp.Status).With("stack", stack.Trace().TrimRuntime()))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	errorC := make(chan kv.Error, 10)
+	p.refresh(ctx, errorC)
+	if len(p.hashes) != 2 {
+		t.Fatal(kv.NewError("peer hashes not collected").With("hashes", p.hashes).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	output, errGo := ioutil.TempFile(backing, ".output-")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer output.Close()
+
+	if err = p.fetch(ctx, good, output); err != nil {
+		t.Fatal(err)
+	}
+	retrieved, errGo := ioutil.ReadFile(output.Name())
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if string(retrieved) != data {
+		t.Fatal(kv.NewError("peer file contents incorrect").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	if err = p.fetch(ctx, bad, output); err == nil {
+		t.Fatal(kv.NewError("peer file with mismatched hash was accepted").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if err = p.fetch(ctx, strings.Repeat("1", 32), output); err == nil {
+		t.Fatal(kv.NewError("file not advertised by any peer was retrieved").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
@@ -0,0 +1,445 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of a cache for the unpacked contents of immutable
+// archive artifacts.  Trees are unpacked once into a hidden directory within the artifact cache,
+// keyed using the hash of the archive they came from, and then linked into the directories of
+// experiments that use them which avoids decompressing and extracting the archive for every
+// experiment.
+//
+// Three linking modes are supported.  The reflink mode clones files using the copy-on-write
+// support of file systems such as btrfs and XFS, falling back to copying files when cloning is
+// not supported, and so experiments are free to modify their inputs.  The hardlink mode shares
+// the files with the cache, files are made read-only and the content hashes of the tree are
+// validated against a manifest before it is reused so that a tree modified by an experiment is
+// discarded and unpacked again.  Files are copied when the experiment directory is on a
+// different file system to the cache.  The bind mode mounts the tree read-only within the
+// experiment directory, which requires the runner to have the CAP_SYS_ADMIN capability, and
+// falls back to cloning files when the mount cannot be created.  Bound trees are released
+// using ReleaseTrees before the experiment directory is removed, trees that leave the cache
+// while bound are moved aside and removed once released.
+//
+// The size of an unpacked tree is added to the size of the archive it came from within the
+// cache so that trees count against the cache size, and are groomed along with their archive.
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/karlmutch/ccache"
+)
+
+const (
+	// TreeLinkHard shares cached files with experiments using hard links
+	TreeLinkHard = "hardlink"
+	// TreeLinkReflink clones cached files into experiments using copy-on-write reflinks
+	TreeLinkReflink = "reflink"
+	// TreeLinkBind mounts cached trees into experiments using read-only bind mounts
+	TreeLinkBind = "bind"
+)
+
+var (
+	treeLinkMode = ""
+
+	// treeBinds tracks the trees bound into experiment directories so that they are not
+	// removed while experiments are using them
+	treeBinds = struct {
+		targets map[string]string   // The hash of the tree bound at each target directory
+		uses    map[string]int      // The number of targets each tree is bound to
+		retired map[string][]string // Trees that left the cache while bound, awaiting removal
+		sync.Mutex
+	}{
+		targets: map[string]string{},
+		uses:    map[string]int{},
+		retired: map[string][]string{},
+	}
+)
+
+type treeFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"sha256"` // The content hash used to detect files modified through hard links
+}
+
+// treeSized holds the information for an archive within the cache along with the size of the
+// tree unpacked from it
+type treeSized struct {
+	os.FileInfo
+	tree int64
+}
+
+// Size returns the combined size of the archive and its tree for use by the cache
+func (t *treeSized) Size() int64 {
+	return t.FileInfo.Size() + t.tree
+}
+
+// SetTreeCache is used to enable the caching of unpacked artifacts using the linking mode
+// supplied, an empty mode disables the tree cache
+//
+func SetTreeCache(mode string) (err kv.Error) {
+	switch mode {
+	case "", TreeLinkHard, TreeLinkReflink, TreeLinkBind:
+		treeLinkMode = mode
+		return nil
+	}
+	return kv.NewError("unknown tree cache linking mode").With("mode", mode).With("stack", stack.Trace().TrimRuntime())
+}
+
+// treesEnabled is true when a tree cache mode has been selected and the artifact cache is in use
+//
+func treesEnabled() bool {
+	return len(treeLinkMode) != 0 && len(backingDir) != 0
+}
+
+func treeDir(hash string) (dir string) {
+	return filepath.Join(backingDir, ".trees", hash)
+}
+
+func treeManifest(hash string) (fn string) {
+	return filepath.Join(backingDir, ".trees", hash+".json")
+}
+
+func readTreeManifest(fn string) (manifest map[string]treeFile, errGo error) {
+	data, errGo := ioutil.ReadFile(fn)
+	if errGo != nil {
+		return nil, errGo
+	}
+	manifest = map[string]treeFile{}
+	if errGo = json.Unmarshal(data, &manifest); errGo != nil {
+		return nil, errGo
+	}
+	return manifest, nil
+}
+
+// withTree returns the information for an archive within the cache in the backing directory,
+// including the size of any tree unpacked from it, so that trees count against the cache size
+//
+func withTree(backing string, hash string, info os.FileInfo) (sized os.FileInfo) {
+	if _, isSized := info.(*treeSized); isSized {
+		return info
+	}
+	manifest, errGo := readTreeManifest(filepath.Join(backing, ".trees", hash+".json"))
+	if errGo != nil || len(manifest) == 0 {
+		return info
+	}
+	tree := int64(0)
+	for _, file := range manifest {
+		tree += file.Size
+	}
+	return &treeSized{FileInfo: info, tree: tree}
+}
+
+// accountTree adds the size of the tree for an archive to the entry for the archive within
+// the cache
+//
+func accountTree(hash string) {
+	withCache(func(c *ccache.Cache) {
+		item := c.Sample(hash)
+		if item == nil {
+			return
+		}
+		info, isInfo := item.Value().(os.FileInfo)
+		if !isInfo {
+			return
+		}
+		if _, isSized := info.(*treeSized); isSized {
+			return
+		}
+		c.Replace(hash, withTree(backingDir, hash, info))
+		// Replacing the entry discards the tracking that holds pinned items within the cache
+		if isPinned(hash) {
+			c.TrackingGet(hash)
+		}
+	})
+}
+
+// removeTree discards the unpacked tree for an archive, it is used when the archive
+// itself leaves the cache
+//
+func removeTree(hash string) {
+	if len(treeLinkMode) == 0 {
+		return
+	}
+	os.Remove(treeManifest(hash))
+
+	treeBinds.Lock()
+	defer treeBinds.Unlock()
+
+	if treeBinds.uses[hash] != 0 {
+		// Moving the tree aside leaves the bind mounts of running experiments intact
+		retired := filepath.Join(filepath.Dir(treeDir(hash)), ".retired-"+hash+"-"+RandomString(8))
+		if errGo := os.Rename(treeDir(hash), retired); errGo == nil {
+			treeBinds.retired[hash] = append(treeBinds.retired[hash], retired)
+		}
+		return
+	}
+	os.RemoveAll(treeDir(hash))
+}
+
+// bindTree mounts the tree for an archive read-only at the dest directory
+//
+func bindTree(hash string, dest string) (errGo error) {
+	treeBinds.Lock()
+	defer treeBinds.Unlock()
+
+	if errGo = bindReadOnly(treeDir(hash), dest); errGo != nil {
+		return errGo
+	}
+	treeBinds.targets[dest] = hash
+	treeBinds.uses[hash]++
+	return nil
+}
+
+// ReleaseTrees removes the bind mounts of cached trees from within an experiment directory, it
+// must be used before the directory is removed
+//
+func ReleaseTrees(dir string) (err kv.Error) {
+	treeBinds.Lock()
+	defer treeBinds.Unlock()
+
+	prefix := filepath.Clean(dir) + string(os.PathSeparator)
+	for target, hash := range treeBinds.targets {
+		if !strings.HasPrefix(target, prefix) {
+			continue
+		}
+		if errGo := unbind(target); errGo != nil {
+			if err == nil {
+				err = kv.Wrap(errGo).With("dir", target).With("stack", stack.Trace().TrimRuntime())
+			}
+			continue
+		}
+		delete(treeBinds.targets, target)
+		if treeBinds.uses[hash]--; treeBinds.uses[hash] > 0 {
+			continue
+		}
+		delete(treeBinds.uses, hash)
+		for _, retired := range treeBinds.retired[hash] {
+			os.RemoveAll(retired)
+		}
+		delete(treeBinds.retired, hash)
+	}
+	return err
+}
+
+// fetchTree will link the unpacked contents of an immutable archive into the destination
+// directory, unpacking the archive into the tree cache first if needed
+//
+func (cache *ArtifactCache) fetchTree(ctx context.Context, storage *objStore, art *Artifact, dest string) (warns []kv.Error, err kv.Error) {
+	hash, err := storage.Hash(ctx, art.Key)
+	if err != nil {
+		return warns, err
+	}
+	if len(hash) == 0 || strings.ContainsAny(hash, "/\\") || hash[0] == '.' {
+		return storage.Fetch(ctx, art.Key, true, dest)
+	}
+
+	if !validTree(hash) {
+		if warns, err = buildTree(ctx, storage, art, hash); err != nil {
+			return warns, err
+		}
+	}
+	accountTree(hash)
+
+	mode := treeLinkMode
+	if mode == TreeLinkBind {
+		if errGo := bindTree(hash, dest); errGo != nil {
+			warns = append(warns, kv.Wrap(errGo, "read-only bind failed, cloning the tree").With("key", art.Key, "dest", dest).With("stack", stack.Trace().TrimRuntime()))
+			mode = TreeLinkReflink
+		}
+	}
+	if mode != TreeLinkBind {
+		if err = linkTree(treeDir(hash), dest, mode); err != nil {
+			return warns, err.With("key", art.Key)
+		}
+	}
+
+	// Using the tree is a use of the archive within the cache
+	if index != nil {
+		index.touch(hash)
+	}
+	return warns, nil
+}
+
+// validTree checks that a previously unpacked tree is present and, when hard links are
+// being used, that the contents of none of its files have been modified by an experiment
+//
+func validTree(hash string) (valid bool) {
+	if _, errGo := os.Stat(treeManifest(hash)); errGo != nil {
+		return false
+	}
+	if _, errGo := os.Stat(treeDir(hash)); errGo != nil {
+		removeTree(hash)
+		return false
+	}
+	manifest, errGo := readTreeManifest(treeManifest(hash))
+	if errGo != nil {
+		removeTree(hash)
+		return false
+	}
+	if treeLinkMode != TreeLinkHard {
+		return true
+	}
+
+	// The size and modification time are checked for all files before the more expensive
+	// content hashes, which catch files rewritten with their original size and time
+	root := treeDir(hash)
+	for name, expected := range manifest {
+		info, errGo := os.Lstat(filepath.Join(root, name))
+		if errGo != nil || info.Size() != expected.Size || !info.ModTime().Equal(expected.ModTime) || len(expected.Hash) == 0 {
+			removeTree(hash)
+			return false
+		}
+	}
+	for name, expected := range manifest {
+		if actual, err := hashFile(filepath.Join(root, name), ""); err != nil || actual != expected.Hash {
+			removeTree(hash)
+			return false
+		}
+	}
+	return true
+}
+
+// buildTree unpacks the archive into a staging directory that is moved into the tree cache
+// once complete along with a manifest of the regular files it contains and their hashes
+//
+func buildTree(ctx context.Context, storage *objStore, art *Artifact, hash string) (warns []kv.Error, err kv.Error) {
+	trees := filepath.Dir(treeDir(hash))
+	if errGo := os.MkdirAll(trees, 0700); errGo != nil {
+		return warns, kv.Wrap(errGo).With("dir", trees).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	staging, errGo := ioutil.TempDir(trees, ".staging-")
+	if errGo != nil {
+		return warns, kv.Wrap(errGo).With("dir", trees).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(staging)
+
+	if warns, err = storage.Fetch(ctx, art.Key, true, staging); err != nil {
+		return warns, err
+	}
+
+	manifest := map[string]treeFile{}
+	errGo = filepath.Walk(staging, func(path string, info os.FileInfo, errGo error) error {
+		if errGo != nil || !info.Mode().IsRegular() {
+			return errGo
+		}
+		// Files shared using hard links are protected from casual modification
+		if treeLinkMode == TreeLinkHard {
+			if errGo = os.Chmod(path, info.Mode().Perm()&^0222); errGo != nil {
+				return errGo
+			}
+		}
+		sum, err := hashFile(path, "")
+		if err != nil {
+			return err
+		}
+		manifest[strings.TrimPrefix(path, staging+string(os.PathSeparator))] = treeFile{
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Hash:    sum,
+		}
+		return nil
+	})
+	if errGo != nil {
+		return warns, kv.Wrap(errGo).With("dir", staging).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	data, errGo := json.Marshal(manifest)
+	if errGo != nil {
+		return warns, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	if errGo = ioutil.WriteFile(staging+".json", data, 0600); errGo != nil {
+		return warns, kv.Wrap(errGo).With("file", staging+".json").With("stack", stack.Trace().TrimRuntime())
+	}
+	defer os.Remove(staging + ".json")
+
+	// Another experiment might have completed the same tree while this one was being
+	// unpacked in which case the tree from the other experiment is used, otherwise
+	// whatever remains from an interrupted attempt is replaced
+	if errGo = os.Rename(staging, treeDir(hash)); errGo != nil {
+		if _, errStat := os.Stat(treeManifest(hash)); errStat == nil {
+			return warns, nil
+		}
+		os.RemoveAll(treeDir(hash))
+		if errGo = os.Rename(staging, treeDir(hash)); errGo != nil {
+			return warns, kv.Wrap(errGo).With("dir", treeDir(hash)).With("stack", stack.Trace().TrimRuntime())
+		}
+	}
+	if errGo = os.Rename(staging+".json", treeManifest(hash)); errGo != nil {
+		return warns, kv.Wrap(errGo).With("file", treeManifest(hash)).With("stack", stack.Trace().TrimRuntime())
+	}
+	return warns, nil
+}
+
+// linkTree materializes the tree found at src within the dest directory
+//
+func linkTree(src string, dest string, mode string) (err kv.Error) {
+	errGo := filepath.Walk(src, func(path string, info os.FileInfo, errGo error) error {
+		if errGo != nil {
+			return errGo
+		}
+		target := filepath.Join(dest, strings.TrimPrefix(path, src))
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, errGo := os.Readlink(path)
+			if errGo != nil {
+				return errGo
+			}
+			return os.Symlink(link, target)
+		case !info.Mode().IsRegular():
+			return nil
+		case mode == TreeLinkHard:
+			// Links cannot span file systems in which case the file is copied
+			errGo = os.Link(path, target)
+			if linkErr, isLinkErr := errGo.(*os.LinkError); !isLinkErr || linkErr.Err != syscall.EXDEV {
+				return errGo
+			}
+		}
+		return cloneFile(path, target, info.Mode().Perm())
+	})
+	if errGo != nil {
+		return kv.Wrap(errGo).With("src", src, "dest", dest).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// cloneFile uses a copy-on-write reflink to duplicate a file when the file system supports
+// it, and otherwise copies the file
+//
+func cloneFile(src string, dest string, perm os.FileMode) (errGo error) {
+	in, errGo := os.Open(src)
+	if errGo != nil {
+		return errGo
+	}
+	defer in.Close()
+
+	out, errGo := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm|0200)
+	if errGo != nil {
+		return errGo
+	}
+	defer out.Close()
+
+	if errGo = reflink(in, out); errGo == nil {
+		return out.Close()
+	}
+	if _, errGo = io.Copy(out, in); errGo != nil {
+		return errGo
+	}
+	return out.Close()
+}
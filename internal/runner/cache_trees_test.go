@@ -0,0 +1,195 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv"
+)
+
+// TestTreeLinking validates that cached trees are materialized using both linking modes, that
+// trees count against the cache size, and that a tree shared using hard links is discarded once
+// an experiment has modified the contents of a file even when its size and time are unchanged
+func TestTreeLinking(t *testing.T) {
+	backing, errGo := ioutil.TempDir("", "cache-trees")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(backing)
+
+	priorBacking, priorMode := backingDir, treeLinkMode
+	defer func() {
+		backingDir, treeLinkMode = priorBacking, priorMode
+	}()
+	backingDir = backing
+
+	hash := "0123456789abcdef0123456789abcdef"
+	tree := treeDir(hash)
+	if errGo = os.MkdirAll(filepath.Join(tree, "sub"), 0700); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	fn := filepath.Join(tree, "sub", "data")
+	if errGo = ioutil.WriteFile(fn, []byte("data"), 0400); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if errGo = os.Symlink("sub/data", filepath.Join(tree, "link")); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	other := filepath.Join(tree, "sub", "other")
+	if errGo = ioutil.WriteFile(other, []byte("data"), 0400); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	info, errGo := os.Stat(fn)
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	otherInfo, errGo := os.Stat(other)
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	dataHash, err := hashFile(fn, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := map[string]treeFile{
+		filepath.Join("sub", "data"):  {Size: info.Size(), ModTime: info.ModTime(), Hash: dataHash},
+		filepath.Join("sub", "other"): {Size: otherInfo.Size(), ModTime: otherInfo.ModTime(), Hash: dataHash},
+	}
+	data, errGo := json.Marshal(manifest)
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if errGo = ioutil.WriteFile(treeManifest(hash), data, 0600); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	for _, mode := range []string{TreeLinkHard, TreeLinkReflink} {
+		if err := SetTreeCache(mode); err != nil {
+			t.Fatal(err)
+		}
+		if !validTree(hash) {
+			t.Fatal(kv.NewError("tree was not valid").With("mode", mode).With("stack", stack.Trace().TrimRuntime()))
+		}
+
+		dest := filepath.Join(backing, mode)
+		if err := linkTree(tree, dest, mode); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{filepath.Join("sub", "data"), filepath.Join("sub", "other"), "link"} {
+			contents, errGo := ioutil.ReadFile(filepath.Join(dest, name))
+			if errGo != nil {
+				t.Fatal(kv.Wrap(errGo).With("mode", mode).With("stack", stack.Trace().TrimRuntime()))
+			}
+			if string(contents) != "data" {
+				t.Fatal(kv.NewError("linked file contents incorrect").With("mode", mode, "file", name).With("stack", stack.Trace().TrimRuntime()))
+			}
+		}
+	}
+
+	for name, cached := range map[string]os.FileInfo{"data": info, "other": otherInfo} {
+		linkedInfo, errGo := os.Stat(filepath.Join(backing, TreeLinkHard, "sub", name))
+		if errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if !os.SameFile(cached, linkedInfo) {
+			t.Fatal(kv.NewError("file was not hard linked").With("file", name).With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+
+	if sized := withTree(backing, hash, info); sized.Size() != info.Size()+otherInfo.Size()+info.Size() {
+		t.Fatal(kv.NewError("tree size not included").With("size", sized.Size()).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// Modifying a hard linked file changes the cached tree which must then be discarded, the
+	// size and time are restored so that only the content hash reveals the modification
+	treeLinkMode = TreeLinkHard
+	linked := filepath.Join(backing, TreeLinkHard, "sub", "data")
+	if errGo = os.Chmod(linked, 0600); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if errGo = ioutil.WriteFile(linked, []byte("datb"), 0600); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if errGo = os.Chtimes(linked, info.ModTime(), info.ModTime()); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if validTree(hash) {
+		t.Fatal(kv.NewError("modified tree was considered valid").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if _, errGo = os.Stat(tree); errGo == nil {
+		t.Fatal(kv.NewError("modified tree was retained").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
+
+// TestTreeBinding validates that trees are bound read-only into experiments and that a tree
+// leaving the cache while bound is only removed once the experiment has released it
+func TestTreeBinding(t *testing.T) {
+	backing, errGo := ioutil.TempDir("", "cache-trees")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(backing)
+
+	priorBacking, priorMode := backingDir, treeLinkMode
+	defer func() {
+		backingDir, treeLinkMode = priorBacking, priorMode
+	}()
+	backingDir = backing
+	treeLinkMode = TreeLinkBind
+
+	hash := "0123456789abcdef0123456789abcdef"
+	tree := treeDir(hash)
+	if errGo = os.MkdirAll(tree, 0700); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if errGo = ioutil.WriteFile(filepath.Join(tree, "data"), []byte("data"), 0600); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	exprDir := filepath.Join(backing, "experiment")
+	dest := filepath.Join(exprDir, "workspace")
+	if errGo = os.MkdirAll(dest, 0700); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if errGo = bindTree(hash, dest); errGo != nil {
+		t.Skip("read-only binds unavailable", errGo.Error())
+	}
+	defer ReleaseTrees(exprDir)
+
+	if errGo = ioutil.WriteFile(filepath.Join(dest, "data"), []byte("modified"), 0600); errGo == nil {
+		t.Fatal(kv.NewError("bound tree was writable").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// The tree leaving the cache must not disturb the experiment using it
+	removeTree(hash)
+	if _, errGo = os.Stat(tree); errGo == nil {
+		t.Fatal(kv.NewError("removed tree was retained in the cache").With("stack", stack.Trace().TrimRuntime()))
+	}
+	contents, errGo := ioutil.ReadFile(filepath.Join(dest, "data"))
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if string(contents) != "data" {
+		t.Fatal(kv.NewError("bound file contents incorrect").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	if err := ReleaseTrees(exprDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, errGo = os.Stat(filepath.Join(dest, "data")); errGo == nil {
+		t.Fatal(kv.NewError("tree was not released").With("stack", stack.Trace().TrimRuntime()))
+	}
+	retired, errGo := filepath.Glob(filepath.Join(filepath.Dir(tree), ".retired-*"))
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if len(retired) != 0 {
+		t.Fatal(kv.NewError("retired tree was not removed").With("retired", retired).With("stack", stack.Trace().TrimRuntime()))
+	}
+}
@@ -7,6 +7,7 @@ package runner
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
 
 	"github.com/shirou/gopsutil/cpu"
@@ -30,6 +31,10 @@ type cpuTracker struct {
 	SoftMaxCores uint   // User specified limit on the number of cores to permit to be used in allocations
 	SoftMaxMem   uint64 // User specified memory that is available for allocation
 
+	pinning   bool             // Allocations are given specific cores when set
+	nodes     map[int][]int    // The cores belonging to each NUMA node
+	coresUsed map[int]struct{} // The cores currently allocated when pinning
+
 	InitErr kv.Error // Any error that might have been recorded during initialization, if set this package may produce unexpected results
 
 	sync.Mutex
@@ -58,8 +63,21 @@ func init() {
 // resources that will be returned at a later time
 //
 type CPUAllocated struct {
-	cores uint
-	mem   uint64
+	cores   uint
+	mem     uint64
+	ids     []int             // The specific cores allocated when pinning is being used
+	tracker *cpuTracker       // The tracker from which the allocation was made
+	Env     map[string]string // Any environment variables the allocator wants the runner to use
+}
+
+// CPUSet returns the cores allocated in the list format used by taskset and cpusets, when
+// pinning is not being used the set is empty
+//
+func (cpu *CPUAllocated) CPUSet() (set string) {
+	if cpu == nil || len(cpu.ids) == 0 {
+		return ""
+	}
+	return formatCPUList(cpu.ids)
 }
 
 // CPUFree is used to retrieve information about the currently available CPU resources
@@ -108,52 +126,91 @@ func SetCPULimits(maxCores uint, maxMem uint64) (err kv.Error) {
 	return nil
 }
 
-// AllocCPU is used by callers to attempt to allocate a CPU resource from the system, CPU affinity is not implemented
-// and so this is soft accounting
+// AllocCPU is used by callers to attempt to allocate a CPU resource from the system.  Unless pinning
+// has been enabled using SetCPUPinning this is soft accounting, when pinning specific cores are allocated
+// preferring those on the NUMA nodes supplied in preferred, typically the nodes of the allocated GPUs
 //
 // live can be used to test the capacity is sufficent for the request without making the request itself
 //
-func AllocCPU(maxCores uint, maxMem uint64, live bool) (alloc *CPUAllocated, err kv.Error) {
+func AllocCPU(maxCores uint, maxMem uint64, preferred []int, live bool) (alloc *CPUAllocated, err kv.Error) {
+	return cpuTrack.alloc(maxCores, maxMem, preferred, live)
+}
 
-	cpuTrack.Lock()
-	defer cpuTrack.Unlock()
+func (tracker *cpuTracker) alloc(maxCores uint, maxMem uint64, preferred []int, live bool) (alloc *CPUAllocated, err kv.Error) {
 
-	if cpuTrack.InitErr != nil {
-		return nil, cpuTrack.InitErr
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	if tracker.InitErr != nil {
+		return nil, tracker.InitErr
 	}
 
-	if maxCores+cpuTrack.AllocCores > cpuTrack.SoftMaxCores {
-		return nil, kv.NewError("insufficient CPU").With("cores_wanted", maxCores).With("cores_available", cpuTrack.SoftMaxCores-cpuTrack.AllocCores).With("stack", stack.Trace().TrimRuntime())
+	if maxCores+tracker.AllocCores > tracker.SoftMaxCores {
+		return nil, kv.NewError("insufficient CPU").With("cores_wanted", maxCores).With("cores_available", tracker.SoftMaxCores-tracker.AllocCores).With("stack", stack.Trace().TrimRuntime())
 	}
-	if maxMem+cpuTrack.AllocMem > cpuTrack.SoftMaxMem {
-		msg := fmt.Sprintf("insufficient memory %s requested from pool of %s", humanize.Bytes(maxMem), humanize.Bytes(cpuTrack.SoftMaxMem))
+	if maxMem+tracker.AllocMem > tracker.SoftMaxMem {
+		msg := fmt.Sprintf("insufficient memory %s requested from pool of %s", humanize.Bytes(maxMem), humanize.Bytes(tracker.SoftMaxMem))
 		return nil, kv.NewError(msg).With("stack", stack.Trace().TrimRuntime())
 	}
 
+	ids := []int{}
+	if tracker.pinning && maxCores != 0 {
+		if ids, err = tracker.pickCores(maxCores, preferred); err != nil {
+			return nil, err
+		}
+	}
+
 	if !live {
 		return nil, nil
 	}
 
-	cpuTrack.AllocCores += maxCores
-	cpuTrack.AllocMem += maxMem
+	tracker.AllocCores += maxCores
+	tracker.AllocMem += maxMem
 
-	return &CPUAllocated{
-		cores: maxCores,
-		mem:   maxMem,
-	}, nil
+	alloc = &CPUAllocated{
+		cores:   maxCores,
+		mem:     maxMem,
+		ids:     ids,
+		tracker: tracker,
+		Env:     map[string]string{},
+	}
+
+	// Frameworks size their thread pools to the cores they can see on the host, when pinned
+	// they are asked to only use the cores that were allocated
+	if len(ids) != 0 {
+		for _, id := range ids {
+			tracker.coresUsed[id] = struct{}{}
+		}
+		threads := strconv.Itoa(len(ids))
+		alloc.Env["OMP_NUM_THREADS"] = threads
+		alloc.Env["MKL_NUM_THREADS"] = threads
+		alloc.Env["STUDIOML_CPUSET"] = formatCPUList(ids)
+	}
+
+	return alloc, nil
 }
 
 // Release is used to return a soft allocation to the system accounting
 //
 func (cpu *CPUAllocated) Release() {
 
-	cpuTrack.Lock()
-	defer cpuTrack.Unlock()
+	tracker := cpu.tracker
+	if tracker == nil {
+		tracker = cpuTrack
+	}
 
-	if cpuTrack.InitErr != nil {
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	if tracker.InitErr != nil {
 		return
 	}
 
-	cpuTrack.AllocCores -= cpu.cores
-	cpuTrack.AllocMem -= cpu.mem
+	tracker.AllocCores -= cpu.cores
+	tracker.AllocMem -= cpu.mem
+
+	for _, id := range cpu.ids {
+		delete(tracker.coresUsed, id)
+	}
+	cpu.ids = nil
 }
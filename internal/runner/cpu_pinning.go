@@ -0,0 +1,270 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of CPU core pinning.  When pinning is enabled allocations
+// of CPU resources are given specific core IDs, preferring cores on the NUMA nodes local to any
+// GPUs that were allocated for the same experiment, and experiments are run with their affinity
+// restricted to those cores.  Only the cores the runner itself may use, as restricted by its
+// affinity and any cgroup cpuset, for example within a container, are allocated.
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+var (
+	// sysRoot is the location of the sysfs file system from which the hardware topology is read
+	sysRoot = "/sys"
+)
+
+// SetCPUPinning is used to enable the allocation of specific CPU cores to experiments, the
+// NUMA topology of the system is loaded when pinning is enabled.  An error is returned if none
+// of the cores in the topology can be used by the runner.
+//
+func SetCPUPinning(enabled bool) (err kv.Error) {
+	cpuTrack.Lock()
+	defer cpuTrack.Unlock()
+
+	if !enabled {
+		cpuTrack.pinning = false
+		return nil
+	}
+
+	if cpuTrack.AllocCores != 0 {
+		return kv.NewError("pinning cannot be enabled with cores allocated").With("stack", stack.Trace().TrimRuntime())
+	}
+
+	nodes, err := cpuTopology(sysRoot, cpuTrack.HardMaxCores)
+	if err != nil {
+		return err
+	}
+	allowed, err := allowedCPUs(procRoot)
+	if err != nil {
+		return err
+	}
+	if nodes = restrictTopology(nodes, allowed); len(nodes) == 0 {
+		cpuTrack.pinning = false
+		return kv.NewError("none of the cores the runner is allowed to use were found in the topology").With("allowed", allowed).With("stack", stack.Trace().TrimRuntime())
+	}
+	cpuTrack.nodes = nodes
+	cpuTrack.coresUsed = map[int]struct{}{}
+	cpuTrack.pinning = true
+
+	return nil
+}
+
+// cpuTopology reads the cores that belong to each NUMA node, systems that do not expose their
+// NUMA topology are treated as having a single node containing all cores
+//
+func cpuTopology(root string, cores uint) (nodes map[int][]int, err kv.Error) {
+	nodes = map[int][]int{}
+
+	lists, errGo := filepath.Glob(filepath.Join(root, "devices", "system", "node", "node*", "cpulist"))
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	for _, list := range lists {
+		node, errGo := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(list)), "node"))
+		if errGo != nil {
+			continue
+		}
+		data, errGo := ioutil.ReadFile(list)
+		if errGo != nil {
+			return nil, kv.Wrap(errGo).With("file", list).With("stack", stack.Trace().TrimRuntime())
+		}
+		ids, err := parseCPUList(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, err.With("file", list)
+		}
+		if len(ids) != 0 {
+			nodes[node] = ids
+		}
+	}
+
+	if len(nodes) == 0 {
+		ids := make([]int, 0, cores)
+		for i := 0; i < int(cores); i++ {
+			ids = append(ids, i)
+		}
+		nodes[0] = ids
+	}
+	return nodes, nil
+}
+
+// allowedCPUs reads the cores the runner may be scheduled on, which reflects both the affinity
+// of the process and any cgroup cpuset.  A nil set is returned when the kernel does not
+// report the cores, in which case all cores are considered usable.
+//
+func allowedCPUs(root string) (allowed map[int]struct{}, err kv.Error) {
+	fn := filepath.Join(root, "self", "status")
+	file, errGo := os.Open(fn)
+	if errGo != nil {
+		return nil, nil
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Cpus_allowed_list:") {
+			continue
+		}
+		ids, err := parseCPUList(strings.TrimSpace(strings.TrimPrefix(line, "Cpus_allowed_list:")))
+		if err != nil {
+			return nil, err.With("file", fn)
+		}
+		allowed = make(map[int]struct{}, len(ids))
+		for _, id := range ids {
+			allowed[id] = struct{}{}
+		}
+		return allowed, nil
+	}
+	if errGo = scanner.Err(); errGo != nil {
+		return nil, kv.Wrap(errGo).With("file", fn).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil, nil
+}
+
+// restrictTopology removes the cores that are not in the allowed set from the NUMA nodes,
+// along with any nodes left without cores.  A nil allowed set leaves the topology unchanged.
+//
+func restrictTopology(nodes map[int][]int, allowed map[int]struct{}) (restricted map[int][]int) {
+	if allowed == nil {
+		return nodes
+	}
+	restricted = map[int][]int{}
+	for node, ids := range nodes {
+		for _, id := range ids {
+			if _, isAllowed := allowed[id]; isAllowed {
+				restricted[node] = append(restricted[node], id)
+			}
+		}
+	}
+	return restricted
+}
+
+// readNUMANode returns the NUMA node contained in a sysfs numa_node file, or -1 if it is unknown
+//
+func readNUMANode(fn string) (node int) {
+	data, errGo := ioutil.ReadFile(fn)
+	if errGo != nil {
+		return -1
+	}
+	node, errGo = strconv.Atoi(strings.TrimSpace(string(data)))
+	if errGo != nil {
+		return -1
+	}
+	return node
+}
+
+// parseCPUList converts the kernel list format, for example 0-3,8-11, into core IDs
+//
+func parseCPUList(list string) (ids []int, err kv.Error) {
+	ids = []int{}
+	if len(list) == 0 {
+		return ids, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		bounds := strings.SplitN(item, "-", 2)
+		first, errGo := strconv.Atoi(bounds[0])
+		if errGo != nil {
+			return nil, kv.Wrap(errGo).With("list", list).With("stack", stack.Trace().TrimRuntime())
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, errGo = strconv.Atoi(bounds[1]); errGo != nil {
+				return nil, kv.Wrap(errGo).With("list", list).With("stack", stack.Trace().TrimRuntime())
+			}
+		}
+		for id := first; id <= last; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// formatCPUList converts core IDs into the list format used by the kernel and taskset
+//
+func formatCPUList(ids []int) (list string) {
+	sorted := append([]int{}, ids...)
+	sort.Ints(sorted)
+
+	items := []string{}
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+		if i == j {
+			items = append(items, strconv.Itoa(sorted[i]))
+		} else {
+			items = append(items, strconv.Itoa(sorted[i])+"-"+strconv.Itoa(sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(items, ",")
+}
+
+// pickCores selects free cores for an allocation.  The NUMA nodes that are preferred are used
+// first, followed by the remaining nodes with those having the most free cores first so that
+// allocations are spread across as few nodes as possible.  The tracker must be locked by
+// the caller.
+//
+func (tracker *cpuTracker) pickCores(count uint, preferred []int) (ids []int, err kv.Error) {
+	free := map[int][]int{}
+	for node, cores := range tracker.nodes {
+		for _, id := range cores {
+			if _, isUsed := tracker.coresUsed[id]; !isUsed {
+				free[node] = append(free[node], id)
+			}
+		}
+	}
+
+	order := []int{}
+	seen := map[int]struct{}{}
+	for _, node := range preferred {
+		if _, isPresent := free[node]; !isPresent {
+			continue
+		}
+		if _, isSeen := seen[node]; !isSeen {
+			seen[node] = struct{}{}
+			order = append(order, node)
+		}
+	}
+	others := []int{}
+	for node := range free {
+		if _, isSeen := seen[node]; !isSeen {
+			others = append(others, node)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		if len(free[others[i]]) == len(free[others[j]]) {
+			return others[i] < others[j]
+		}
+		return len(free[others[i]]) > len(free[others[j]])
+	})
+	order = append(order, others...)
+
+	ids = make([]int, 0, count)
+	for _, node := range order {
+		for _, id := range free[node] {
+			if uint(len(ids)) == count {
+				return ids, nil
+			}
+			ids = append(ids, id)
+		}
+	}
+	if uint(len(ids)) != count {
+		return nil, kv.NewError("insufficient CPU cores").With("cores_wanted", count, "cores_available", len(ids)).With("stack", stack.Trace().TrimRuntime())
+	}
+	return ids, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// TestCPUPinning uses a synthetic two node topology to check that it is restricted to the cores
+// the runner may use, that pinning fails when no cores remain, and that cores are allocated from the NUMA node local to the GPUs first,
+// spill onto the other node, and are returned on release
+//
+func TestCPUPinning(t *testing.T) {
+	root, errGo := ioutil.TempDir("", "cpu-pinning")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(root)
+
+	for node, list := range map[string]string{"node0": "0-3", "node1": "4-7"} {
+		dir := filepath.Join(root, "devices", "system", "node", node)
+		if errGo = os.MkdirAll(dir, 0700); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if errGo = ioutil.WriteFile(filepath.Join(dir, "cpulist"), []byte(list+"\n"), 0600); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+
+	nodes, err := cpuTopology(root, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cores outside of the set the runner is allowed to use, for example because of the cpuset
+	// of its container, are removed leaving nodes without usable cores out entirely
+	status := filepath.Join(root, "self", "status")
+	if errGo = os.MkdirAll(filepath.Dir(status), 0700); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	for list, expected := range map[string]string{"2-5": "2-3 4-5", "6": " 6", "8-9": " "} {
+		if errGo = ioutil.WriteFile(status, []byte("Name:\trunner\nCpus_allowed_list:\t"+list+"\n"), 0600); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		allowed, err := allowedCPUs(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restricted := restrictTopology(nodes, allowed)
+		if actual := formatCPUList(restricted[0]) + " " + formatCPUList(restricted[1]); actual != expected {
+			t.Fatal(kv.NewError("topology not restricted to the allowed cores").With("allowed", list, "restricted", actual).With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+
+	// Pinning cannot be enabled when none of the cores in the topology may be used
+	if errGo = ioutil.WriteFile(status, []byte("Name:\trunner\nCpus_allowed_list:\t8-9\n"), 0600); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	sys, proc := sysRoot, procRoot
+	sysRoot, procRoot = root, root
+	err = SetCPUPinning(true)
+	sysRoot, procRoot = sys, proc
+	if err == nil {
+		SetCPUPinning(false)
+		t.Fatal(kv.NewError("pinning enabled without usable cores").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	tracker := &cpuTracker{
+		HardMaxCores: 8,
+		SoftMaxCores: 8,
+		HardMaxMem:   1024,
+		SoftMaxMem:   1024,
+		pinning:      true,
+		nodes:        nodes,
+		coresUsed:    map[int]struct{}{},
+	}
+
+	// GPUs on node 1 should attract the cores from node 1
+	first, err := tracker.alloc(2, 1, []int{1}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.CPUSet() != "4-5" || first.Env["OMP_NUM_THREADS"] != "2" {
+		t.Fatal(kv.NewError("cores not allocated from the GPU node").With("cpuset", first.CPUSet(), "env", first.Env).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// Without a preference the node with the most free cores is used, and once the preferred
+	// node is exhausted the allocation spills onto the other node
+	second, err := tracker.alloc(4, 1, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.CPUSet() != "0-3" {
+		t.Fatal(kv.NewError("cores not allocated from the emptiest node").With("cpuset", second.CPUSet()).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if _, err = tracker.alloc(3, 1, []int{1}, false); err == nil {
+		t.Fatal(kv.NewError("allocation exceeded the free cores").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	second.Release()
+	third, err := tracker.alloc(4, 1, []int{1}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.CPUSet() != "0-1,6-7" {
+		t.Fatal(kv.NewError("cores did not spill onto the other node").With("cpuset", third.CPUSet()).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	first.Release()
+	third.Release()
+	if tracker.AllocCores != 0 || len(tracker.coresUsed) != 0 {
+		t.Fatal(kv.NewError("cores were not released").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
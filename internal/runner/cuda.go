@@ -8,7 +8,6 @@ package runner
 import (
 	"context"
 	"fmt"
-	"os"
 	"sort"
 	"strconv"
 	"strings"
@@ -33,6 +32,8 @@ type device struct {
 	MemTot     uint64    `json:"memtot"`
 	MemUsed    uint64    `json:"memused"`
 	MemFree    uint64    `json:"memfree"`
+	NUMANode   int       `json:"numa_node"`
+	Util       uint      `json:"util"`
 	EccFailure *kv.Error `json:"eccfailure"`
 }
 
@@ -49,12 +50,16 @@ type GPUTrack struct {
 	Mem        uint64              // The amount of memory the GPU posses
 	FreeSlots  uint                // The number of free logical slots the GPU has available
 	FreeMem    uint64              // The amount of free memory the GPU has
+	Shared     uint                // The number of fractional allocations that are sharing the GPU
+	NUMANode   int                 // The NUMA node the GPU is attached to, -1 if unknown
 	EccFailure *kv.Error           // Any Ecc failure related error messages, nil if no kv.encountered
 	Tracking   map[string]struct{} // Used to validate allocations as they are release
 }
 
 type gpuTracker struct {
-	Allocs map[string]*GPUTrack
+	Allocs    map[string]*GPUTrack
+	shareMem  bool // Allows single GPU requests with a memory budget to share a card with others
+	capMemEnv bool // Adds framework memory controls to the environment of shared allocations
 	sync.Mutex
 }
 
@@ -86,18 +91,14 @@ var (
 func init() {
 	temp := true
 	UseGPU = &temp
+}
 
-	gpuDevices, err := getCUDAInfo()
-	if err != nil {
-		CudaInitErr = &err
-		CudaInitWarnings = append(CudaInitWarnings, err)
-		return
-	}
+// newGPUTracker uses the inventory of devices from a GPU provider along with the list of devices
+// visible to the runner to generate the tracking information used for allocations
+//
+func newGPUTracker(gpuDevices cudaDevices, devs string) (tracker *gpuTracker, warns []kv.Error) {
 
-	devs := os.Getenv("CUDA_VISIBLE_DEVICES")
-	if len(devs) == 0 {
-		devs = os.Getenv("NVIDIA_VISIBLE_DEVICES")
-	}
+	warns = []kv.Error{}
 
 	visDevices := strings.Split(devs, ",")
 
@@ -108,9 +109,9 @@ func init() {
 		}
 	}
 
-	gpuAllocs.Lock()
-	defer gpuAllocs.Unlock()
-	gpuAllocs.Allocs = make(map[string]*GPUTrack, len(visDevices))
+	tracker = &gpuTracker{
+		Allocs: make(map[string]*GPUTrack, len(visDevices)),
+	}
 
 	// If the visDevices were specified use then to generate existing entries inside the device map.
 	// These entries will then get filled in later.
@@ -125,20 +126,21 @@ func init() {
 		if i, err := strconv.Atoi(id); err == nil {
 			if !warned {
 				warned = true
-				CudaInitWarnings = append(CudaInitWarnings, kv.NewError("CUDA_VISIBLE_DEVICES should be using UUIDs not indexes").With("stack", stack.Trace().TrimRuntime()))
+				warns = append(warns, kv.NewError("CUDA_VISIBLE_DEVICES should be using UUIDs not indexes").With("stack", stack.Trace().TrimRuntime()))
 			}
-			if i > len(gpuDevices.Devices) {
-				CudaInitWarnings = append(CudaInitWarnings, kv.NewError("CUDA_VISIBLE_DEVICES contained an index past the known population of GPU cards").With("stack", stack.Trace().TrimRuntime()))
+			if i >= len(gpuDevices.Devices) {
+				warns = append(warns, kv.NewError("CUDA_VISIBLE_DEVICES contained an index past the known population of GPU cards").With("stack", stack.Trace().TrimRuntime()))
+				continue
 			}
-			gpuAllocs.Allocs[gpuDevices.Devices[i].UUID] = &GPUTrack{Tracking: map[string]struct{}{}}
+			tracker.Allocs[gpuDevices.Devices[i].UUID] = &GPUTrack{Tracking: map[string]struct{}{}}
 		} else {
-			gpuAllocs.Allocs[id] = &GPUTrack{Tracking: map[string]struct{}{}}
+			tracker.Allocs[id] = &GPUTrack{Tracking: map[string]struct{}{}}
 		}
 	}
 
-	if len(gpuAllocs.Allocs) == 0 {
+	if len(tracker.Allocs) == 0 {
 		for _, dev := range gpuDevices.Devices {
-			gpuAllocs.Allocs[dev.UUID] = &GPUTrack{Tracking: map[string]struct{}{}}
+			tracker.Allocs[dev.UUID] = &GPUTrack{Tracking: map[string]struct{}{}}
 		}
 	}
 
@@ -147,13 +149,14 @@ func init() {
 	//
 	for _, dev := range gpuDevices.Devices {
 		// Dont include devices that were not specified by CUDA_VISIBLE_DEVICES
-		if _, isPresent := gpuAllocs.Allocs[dev.UUID]; !isPresent {
+		if _, isPresent := tracker.Allocs[dev.UUID]; !isPresent {
 			continue
 		}
 
 		track := &GPUTrack{
 			UUID:       dev.UUID,
 			Mem:        dev.MemFree,
+			NUMANode:   dev.NUMANode,
 			EccFailure: dev.EccFailure,
 			Tracking:   map[string]struct{}{},
 		}
@@ -175,17 +178,47 @@ func init() {
 		case strings.Contains(dev.Name, "Tesla V100"):
 			track.Slots = 16
 		default:
-			CudaInitWarnings = append(CudaInitWarnings, kv.NewError("unrecognized gpu device").With("gpu_name", dev.Name).With("gpu_uuid", dev.UUID).With("stack", stack.Trace().TrimRuntime()))
+			warns = append(warns, kv.NewError("unrecognized gpu device").With("gpu_name", dev.Name).With("gpu_uuid", dev.UUID).With("stack", stack.Trace().TrimRuntime()))
 		}
 		track.FreeSlots = track.Slots
 		track.FreeMem = track.Mem
-		gpuAllocs.Allocs[dev.UUID] = track
+		tracker.Allocs[dev.UUID] = track
+	}
+	return tracker, warns
+}
+
+// updateDevices records any ECC failures that have appeared within a fresh inventory of
+// the GPU hardware, failed cards are no longer considered for allocations.  The newly
+// seen failures are returned.
+//
+func (allocator *gpuTracker) updateDevices(gpuDevices cudaDevices) (failures []kv.Error) {
+	failures = []kv.Error{}
+
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	for _, dev := range gpuDevices.Devices {
+		if dev.EccFailure == nil {
+			continue
+		}
+		// Check to see if the hardware GPU had a failure
+		// and if it is in the tracking table and does
+		// not yet have an error logged log the error
+		// in the tracking table
+		if gpu, isPresent := allocator.Allocs[dev.UUID]; isPresent {
+			if gpu.EccFailure == nil {
+				gpu.EccFailure = dev.EccFailure
+			}
+		}
+		failures = append(failures, *dev.EccFailure)
 	}
+	return failures
 }
 
 // GPUInventory can be used to extract a copy of the current state of the GPU hardware seen within the
 // runner
 func GPUInventory() (gpus []GPUTrack, err kv.Error) {
+	ensureGPUs()
 
 	gpus = []GPUTrack{}
 
@@ -207,6 +240,8 @@ func GPUInventory() (gpus []GPUTrack, err kv.Error) {
 // failed GPUs
 //
 func MonitorGPUs(ctx context.Context, statusC chan<- []string, errC chan<- kv.Error) {
+	ensureGPUs()
+
 	// Take all of the warnings etc that were gathered during initialization and
 	// get them back to the error handling listener
 	for _, warn := range CudaInitWarnings {
@@ -226,7 +261,7 @@ func MonitorGPUs(ctx context.Context, statusC chan<- []string, errC chan<- kv.Er
 	for {
 		select {
 		case <-t.C:
-			gpuDevices, err := getCUDAInfo()
+			gpuDevices, err := gpuSource.Inventory()
 			if err != nil {
 				select {
 				case errC <- err:
@@ -236,8 +271,8 @@ func MonitorGPUs(ctx context.Context, statusC chan<- []string, errC chan<- kv.Er
 				}
 			}
 			// Look at allhe GPUs we have in our hardware config
-			for _, dev := range gpuDevices.Devices {
-				if firstTime {
+			if firstTime {
+				for _, dev := range gpuDevices.Devices {
 					msg := []string{"gpu found", "name", dev.Name, "uuid", dev.UUID, "stack", stack.Trace().TrimRuntime().String()}
 					select {
 					case statusC <- msg:
@@ -245,25 +280,13 @@ func MonitorGPUs(ctx context.Context, statusC chan<- []string, errC chan<- kv.Er
 						fmt.Println(msg)
 					}
 				}
-				if dev.EccFailure != nil {
-					gpuAllocs.Lock()
-					// Check to see if the hardware GPU had a failure
-					// and if it is in the tracking table and does
-					// not yet have an error logged log the error
-					// in the tracking table
-					if gpu, isPresent := gpuAllocs.Allocs[dev.UUID]; isPresent {
-						if gpu.EccFailure == nil {
-							gpu.EccFailure = dev.EccFailure
-							gpuAllocs.Allocs[gpu.UUID] = gpu
-						}
-					}
-					gpuAllocs.Unlock()
-					select {
-					case errC <- *dev.EccFailure:
-					default:
-						// last gasp attempt to output the error
-						fmt.Println(dev.EccFailure)
-					}
+			}
+			for _, failure := range gpuAllocs.updateDevices(gpuDevices) {
+				select {
+				case errC <- failure:
+				default:
+					// last gasp attempt to output the error
+					fmt.Println(failure)
 				}
 			}
 			firstTime = false
@@ -275,6 +298,7 @@ func MonitorGPUs(ctx context.Context, statusC chan<- []string, errC chan<- kv.Er
 
 // GPUCount returns the number of allocatable GPU resources
 func GPUCount() (cnt int) {
+	ensureGPUs()
 	gpuAllocs.Lock()
 	defer gpuAllocs.Unlock()
 
@@ -285,6 +309,7 @@ func GPUCount() (cnt int) {
 // the machine
 //
 func GPUSlots() (cnt uint, freeCnt uint) {
+	ensureGPUs()
 	gpuAllocs.Lock()
 	defer gpuAllocs.Unlock()
 
@@ -295,28 +320,34 @@ func GPUSlots() (cnt uint, freeCnt uint) {
 	return cnt, freeCnt
 }
 
-// LargestFreeGPUSlots gets the largest number of single device free GPU slots
+// LargestFreeGPUSlots gets the largest number of single device free GPU slots, cards with
+// ECC failures are not counted as they are no longer allocated
 //
 func LargestFreeGPUSlots() (cnt uint) {
+	ensureGPUs()
 	gpuAllocs.Lock()
 	defer gpuAllocs.Unlock()
 
 	for _, alloc := range gpuAllocs.Allocs {
-		if alloc.FreeSlots > cnt {
+		if alloc.EccFailure == nil && alloc.FreeSlots > cnt {
 			cnt = alloc.FreeSlots
 		}
 	}
 	return cnt
 }
 
-// TotalFreeGPUSlots gets the largest number of single device free GPU slots
+// TotalFreeGPUSlots gets the largest number of single device free GPU slots, cards with
+// ECC failures are not counted as they are no longer allocated
 //
 func TotalFreeGPUSlots() (cnt uint) {
+	ensureGPUs()
 	gpuAllocs.Lock()
 	defer gpuAllocs.Unlock()
 
 	for _, alloc := range gpuAllocs.Allocs {
-		cnt += alloc.FreeSlots
+		if alloc.EccFailure == nil {
+			cnt += alloc.FreeSlots
+		}
 	}
 	return cnt
 }
@@ -324,11 +355,12 @@ func TotalFreeGPUSlots() (cnt uint) {
 // LargestFreeGPUMem will obtain the largest number of available GPU slots
 // on any of the individual cards accessible to the runner
 func LargestFreeGPUMem() (freeMem uint64) {
+	ensureGPUs()
 	gpuAllocs.Lock()
 	defer gpuAllocs.Unlock()
 
 	for _, alloc := range gpuAllocs.Allocs {
-		if alloc.Slots != 0 && alloc.FreeMem > freeMem {
+		if alloc.Slots != 0 && alloc.EccFailure == nil && alloc.FreeMem > freeMem {
 			freeMem = alloc.FreeMem
 		}
 	}
@@ -342,6 +374,7 @@ type GPUAllocated struct {
 	uuid     string            // The device identifier this allocation was successful against
 	slots    uint              // The number of GPU slots given from the allocation
 	mem      uint64            // The amount of memory given to the allocation
+	numa     int               // The NUMA node of the device, -1 if unknown
 	Env      map[string]string // Any environment variables the device allocator wants the runner to use
 }
 
@@ -349,9 +382,44 @@ type GPUAllocated struct {
 //
 type GPUAllocations []*GPUAllocated
 
+// SetGPUSharing is used to enable the placement of multiple experiments onto a single GPU
+// card using slices of the cards memory.  When share is true experiments that request a
+// single GPU and supply a gpuMem budget are given a reservation of that amount of memory
+// on a card rather than slots, cards being used in this way are not available for slot
+// based allocations until all of their fractional allocations have been released.
+//
+// When capEnv is true the environment of fractional allocations will contain variables
+// that instruct machine learning frameworks to only take GPU memory as it is needed.
+//
+func SetGPUSharing(share bool, capEnv bool) {
+	gpuAllocs.Lock()
+	defer gpuAllocs.Unlock()
+
+	gpuAllocs.shareMem = share
+	gpuAllocs.capMemEnv = capEnv
+}
+
+// NUMANodes returns the NUMA nodes local to the devices that were allocated
+//
+func (allocs GPUAllocations) NUMANodes() (nodes []int) {
+	nodes = []int{}
+	seen := map[int]struct{}{}
+	for _, alloc := range allocs {
+		if alloc.numa < 0 {
+			continue
+		}
+		if _, isPresent := seen[alloc.numa]; !isPresent {
+			seen[alloc.numa] = struct{}{}
+			nodes = append(nodes, alloc.numa)
+		}
+	}
+	return nodes
+}
+
 // AllocGPU will select the default allocation pool for GPUs and call the allocation for it.
 //
 func AllocGPU(maxGPU uint, maxGPUMem uint64, unitsOfAllocation []uint, live bool) (alloc GPUAllocations, err kv.Error) {
+	ensureGPUs()
 	return gpuAllocs.AllocGPU(maxGPU, maxGPUMem, unitsOfAllocation, live)
 }
 
@@ -375,7 +443,9 @@ func evens(start int, end int) (result []int) {
 // When allocations occur across multiple devices the units of allocation parameter
 // defines the grainularity that the cards must conform to in terms of slots.
 //
-// Any allocations will take an entire card, we do not break cards across experiments
+// Any allocations will take an entire card, we do not break cards across experiments, unless
+// GPU sharing is enabled in which case single GPU requests with a memory budget are given a
+// slice of the memory on a card, see SetGPUSharing.
 //
 // This receiver uses a user supplied pool which allows for unit tests to be written that use a
 // custom pool
@@ -392,6 +462,16 @@ func (allocator *gpuTracker) AllocGPU(maxGPU uint, maxGPUMem uint64, unitsOfAllo
 		return alloc, nil
 	}
 
+	if maxGPU == 1 && maxGPUMem != 0 {
+		allocator.Lock()
+		shared := allocator.shareMem
+		allocator.Unlock()
+
+		if shared {
+			return allocator.allocShared(maxGPUMem, live)
+		}
+	}
+
 	// Start with the smallest granularity of allocations permitted and try and find a fit for the total,
 	// then continue up through the granularities until we have exhausted the options
 
@@ -434,6 +514,10 @@ func (allocator *gpuTracker) AllocGPU(maxGPU uint, maxGPUMem uint64, unitsOfAllo
 		if v.EccFailure != nil {
 			continue
 		}
+		// Cannot use cards that have been sliced up for fractional allocations
+		if v.Shared != 0 {
+			continue
+		}
 		// Make sure the units contains the value of the valid range of slots
 		// acceptable to the caller
 		pos := sort.SearchInts(units, int(v.Slots))
@@ -549,6 +633,7 @@ func (allocator *gpuTracker) AllocGPU(maxGPU uint, maxGPUMem uint64, unitsOfAllo
 			uuid:     found.uuid,
 			slots:    slots,
 			mem:      maxGPUMem,
+			numa:     allocator.Allocs[found.uuid].NUMANode,
 			Env:      map[string]string{"CUDA_VISIBLE_DEVICES": found.uuid},
 		})
 
@@ -558,9 +643,76 @@ func (allocator *gpuTracker) AllocGPU(maxGPU uint, maxGPUMem uint64, unitsOfAllo
 	return alloc, nil
 }
 
+// allocShared reserves a slice of the memory on a single card for an experiment, the card
+// whose free memory most closely fits the request is chosen so that cards with large
+// amounts of free memory remain available for larger requests
+//
+func (allocator *gpuTracker) allocShared(mem uint64, live bool) (alloc GPUAllocations, err kv.Error) {
+
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	var found *GPUTrack
+	for _, card := range allocator.Allocs {
+		// Cards that are broken, of an unknown type, or are in use for slot based
+		// allocations cannot be shared
+		if card.EccFailure != nil || card.Slots == 0 || card.FreeSlots != card.Slots {
+			continue
+		}
+		if card.FreeMem < mem {
+			continue
+		}
+		if found == nil || card.FreeMem < found.FreeMem || (card.FreeMem == found.FreeMem && card.UUID < found.UUID) {
+			found = card
+		}
+	}
+
+	if found == nil {
+		return nil, kv.NewError("insufficient GPU memory").With("gpu_mem", mem).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	if !live {
+		return nil, nil
+	}
+
+	found.FreeMem -= mem
+	found.Shared++
+
+	env := map[string]string{"CUDA_VISIBLE_DEVICES": found.UUID}
+	if allocator.capMemEnv {
+		for k, v := range sharedMemEnv() {
+			env[k] = v
+		}
+	}
+
+	tracking := xid.New().String()
+	found.Tracking[tracking] = struct{}{}
+
+	return GPUAllocations{&GPUAllocated{
+		tracking: tracking,
+		uuid:     found.UUID,
+		mem:      mem,
+		numa:     found.NUMANode,
+		Env:      env,
+	}}, nil
+}
+
+// sharedMemEnv generates the environment variables used by frameworks to limit their
+// consumption of GPU memory when sharing a card.  TensorFlow is asked to grow its usage on
+// demand rather than taking the entire card.  Other frameworks, PyTorch included, offer no
+// environment variable that limits their memory and must be limited by the experiment.
+//
+func sharedMemEnv() (env map[string]string) {
+	return map[string]string{
+		"TF_FORCE_GPU_ALLOW_GROWTH": "true",
+	}
+}
+
 func (allocator *gpuTracker) ReturnGPU(alloc *GPUAllocated) (err kv.Error) {
 
-	if alloc.slots == 0 || alloc.mem == 0 {
+	// Fractional allocations hold memory but no slots, so an allocation only has nothing
+	// to return when it holds neither
+	if alloc.slots == 0 && alloc.mem == 0 {
 		return nil
 	}
 
@@ -582,6 +734,11 @@ func (allocator *gpuTracker) ReturnGPU(alloc *GPUAllocated) (err kv.Error) {
 	allocator.Allocs[alloc.uuid].FreeSlots += alloc.slots
 	allocator.Allocs[alloc.uuid].FreeMem += alloc.mem
 
+	// Allocations without slots are fractional allocations sharing the card
+	if alloc.slots == 0 && allocator.Allocs[alloc.uuid].Shared != 0 {
+		allocator.Allocs[alloc.uuid].Shared--
+	}
+
 	return nil
 }
 
@@ -589,5 +746,6 @@ func (allocator *gpuTracker) ReturnGPU(alloc *GPUAllocated) (err kv.Error) {
 // details but is an honors system.
 //
 func ReturnGPU(alloc *GPUAllocated) (err kv.Error) {
+	ensureGPUs()
 	return gpuAllocs.ReturnGPU(alloc)
 }
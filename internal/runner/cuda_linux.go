@@ -9,6 +9,10 @@ package runner
 
 import (
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/go-stack/stack"
@@ -57,6 +61,44 @@ var (
 	}
 )
 
+// nvidiaNUMANode uses the information published by the NVIDIA driver to locate the PCI
+// device for a GPU and from that the NUMA node the GPU is attached to
+//
+func nvidiaNUMANode(minor uint) (node int) {
+	infos, errGo := filepath.Glob("/proc/driver/nvidia/gpus/*/information")
+	if errGo != nil {
+		return -1
+	}
+	for _, info := range infos {
+		data, errGo := ioutil.ReadFile(info)
+		if errGo != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.SplitN(line, ":", 2)
+			if len(fields) != 2 || strings.TrimSpace(fields[0]) != "Device Minor" {
+				continue
+			}
+			if strings.TrimSpace(fields[1]) != strconv.FormatUint(uint64(minor), 10) {
+				break
+			}
+			busID := filepath.Base(filepath.Dir(info))
+			return readNUMANode(filepath.Join(sysRoot, "bus", "pci", "devices", strings.ToLower(busID), "numa_node"))
+		}
+	}
+	return -1
+}
+
+func init() {
+	gpuProviders["nvml"] = func(spec string) (provider gpuProvider, err kv.Error) {
+		return &nvmlProvider{}, nil
+	}
+}
+
+// nvmlProvider discovers NVIDIA GPU cards using the NVIDIA management library
+//
+type nvmlProvider struct{}
+
 // HasCUDA allows an external package to test for the presence of CUDA support
 // in the go code of this package
 func HasCUDA() bool {
@@ -64,7 +106,9 @@ func HasCUDA() bool {
 	return true
 }
 
-func getCUDAInfo() (outDevs cudaDevices, err kv.Error) {
+// Inventory returns the NVIDIA devices present on the system
+//
+func (*nvmlProvider) Inventory() (outDevs cudaDevices, err kv.Error) {
 
 	nvmlOnce.Do(nvmlInit)
 
@@ -100,13 +144,20 @@ func getCUDAInfo() (outDevs cudaDevices, err kv.Error) {
 		}
 
 		runnerDev := device{
-			Name:    name,
-			UUID:    uuid,
-			Temp:    temp,
-			Powr:    powr,
-			MemTot:  mem.Total,
-			MemUsed: mem.Used,
-			MemFree: mem.Free,
+			Name:     name,
+			UUID:     uuid,
+			Temp:     temp,
+			Powr:     powr,
+			MemTot:   mem.Total,
+			MemUsed:  mem.Used,
+			MemFree:  mem.Free,
+			NUMANode: -1,
+		}
+		if util, errGo := dev.UtilizationRates(); errGo == nil {
+			runnerDev.Util = util.Gpu
+		}
+		if minor, errGo := dev.MinorNumber(); errGo == nil {
+			runnerDev.NUMANode = nvidiaNUMANode(minor)
 		}
 		// Dont use the ECC Error check on AWS as the NVML APIs do not appear to return the expected values
 		if isAWS, _ := IsAWS(); !isAWS && !CudaInTest {
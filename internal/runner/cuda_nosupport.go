@@ -12,29 +12,22 @@ import (
 // This file contains the CUDA functions implemented for the cases where
 // a platform cannot support the CUDA hardware, and or APIs
 
-var (
-	simDevs = cudaDevices{
-		Devices: []device{
-			//			device{
-			//				Name:    "simulated",
-			//				UUID:    "0",
-			//				MemTot:  16 * 1024 * 1024 * 1024,
-			//				MemFree: 16 * 1024 * 1024 * 1024,
-			//				MemUsed: 0,
-			//			},
-		},
+func init() {
+	gpuProviders["nvml"] = func(spec string) (provider gpuProvider, err kv.Error) {
+		return &nvmlProvider{}, nil
 	}
-)
+}
 
-func getCUDAInfo() (outDevs cudaDevices, err kv.Error) {
+// nvmlProvider stands in for the NVIDIA management library on platforms that cannot support it
+//
+type nvmlProvider struct{}
 
-	if len(simDevs.Devices) == 0 {
-		return simDevs, kv.NewError("CUDA not supported on this platform").With("stack", stack.Trace().TrimRuntime())
-	} else {
-		return simDevs, nil
-	}
+// Inventory always fails as the NVIDIA management library is not present
+//
+func (*nvmlProvider) Inventory() (outDevs cudaDevices, err kv.Error) {
+	return cudaDevices{Devices: []device{}}, kv.NewError("CUDA not supported on this platform").With("stack", stack.Trace().TrimRuntime())
 }
 
 func HasCUDA() bool {
-	return len(simDevs.Devices) > 0
+	return false
 }
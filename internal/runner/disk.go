@@ -130,6 +130,15 @@ func AllocDisk(maxSpace uint64, live bool) (alloc *DiskAllocated, err kv.Error)
 	return alloc, nil
 }
 
+// Size returns the amount of disk space that was allocated
+//
+func (alloc *DiskAllocated) Size() (size uint64) {
+	if alloc == nil {
+		return 0
+	}
+	return alloc.size
+}
+
 // Release will return assigned disk space back to the free pool of disk space
 // for a default disk device.  If the allocation is not recognized then an
 // error is returned
@@ -0,0 +1,97 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of a watcher that measures the local storage being consumed
+// by an experiment and compares it with the disk space that was allocated to the experiment.
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// DirUsage returns the storage consumed by the files within the directories supplied.  Files
+// that are linked into more than one location are only counted once.
+//
+func DirUsage(dirs []string) (usage uint64, err kv.Error) {
+	type inode struct {
+		dev uint64
+		ino uint64
+	}
+	seen := map[inode]struct{}{}
+
+	for _, dir := range dirs {
+		errGo := filepath.Walk(dir, func(path string, info os.FileInfo, errGo error) error {
+			if errGo != nil {
+				// Files can be removed by the experiment while they are being measured
+				if os.IsNotExist(errGo) {
+					return nil
+				}
+				return errGo
+			}
+			if info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+				return nil
+			}
+			stat, isStat := info.Sys().(*syscall.Stat_t)
+			if !isStat {
+				usage += uint64(info.Size())
+				return nil
+			}
+			id := inode{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}
+			if _, isPresent := seen[id]; isPresent {
+				return nil
+			}
+			seen[id] = struct{}{}
+			usage += uint64(stat.Blocks) * 512
+			return nil
+		})
+		if errGo != nil && !os.IsNotExist(errGo) {
+			return usage, kv.Wrap(errGo).With("dir", dir).With("stack", stack.Trace().TrimRuntime())
+		}
+	}
+	return usage, nil
+}
+
+// WatchDiskQuota measures the directories returned by dirs on a regular interval until the context
+// is cancelled.  When usage first passes the warn fraction of the limit the warn function is called,
+// and once the limit is exceeded the function returns an error describing the usage.  A nil error is
+// returned when the context is cancelled without the quota being exceeded.
+//
+func WatchDiskQuota(ctx context.Context, limit uint64, warnAt float64, interval time.Duration, dirs func() []string, warn func(usage uint64)) (err kv.Error) {
+	if limit == 0 || interval == 0 {
+		return nil
+	}
+
+	check := time.NewTicker(interval)
+	defer check.Stop()
+
+	warned := false
+	for {
+		select {
+		case <-check.C:
+			usage, err := DirUsage(dirs())
+			if err != nil {
+				continue
+			}
+			if usage > limit {
+				return kv.NewError("exceeded disk quota").
+					With("usage", humanize.Bytes(usage), "quota", humanize.Bytes(limit)).
+					With("stack", stack.Trace().TrimRuntime())
+			}
+			if !warned && warn != nil && float64(usage) >= float64(limit)*warnAt {
+				warned = true
+				warn(usage)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// TestDiskQuota checks that linked files are measured once, that a warning is given as the
+// quota is approached, and that the watcher stops once the quota is exceeded
+//
+func TestDiskQuota(t *testing.T) {
+	dir, errGo := ioutil.TempDir("", "disk-quota")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "data")
+	if errGo = ioutil.WriteFile(fn, []byte(RandomString(64*1024)), 0600); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if errGo = os.Link(fn, filepath.Join(dir, "link")); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	usage, err := DirUsage([]string{dir, filepath.Join(dir, "missing")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage < 64*1024 || usage >= 128*1024 {
+		t.Fatal(kv.NewError("disk usage unexpected").With("usage", usage).With("stack", stack.Trace().TrimRuntime()))
+	}
+	dirs := func() []string { return []string{dir} }
+
+	// Within the quota but past the warning threshold
+	warned := make(chan uint64, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err = WatchDiskQuota(ctx, 2*usage, 0.4, 10*time.Millisecond, dirs, func(usage uint64) { warned <- usage }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-warned:
+	default:
+		t.Fatal(kv.NewError("disk quota warning not given").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// Exceeding the quota
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err = WatchDiskQuota(ctx, usage/2, 0.8, 10*time.Millisecond, dirs, nil); err == nil {
+		t.Fatal(kv.NewError("disk quota was not exceeded").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if ctx.Err() != nil {
+		t.Fatal(kv.NewError("disk quota was not detected before the timeout").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
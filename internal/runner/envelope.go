@@ -3,10 +3,14 @@
 package runner
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"strings"
 
 	"github.com/go-stack/stack"
 	"github.com/jjeffery/kv"
+
+	"go.opencensus.io/trace"
 )
 
 // This file contains the implementation of an envelop message that will be used to
@@ -26,6 +30,46 @@ type Message struct {
 	ExperimentLifetime string         `json:"experiment_lifetime"`
 	Resource           Resource       `json:"resources_needed"`
 	Payload            string         `json:"payload"`
+	TraceParent        string         `json:"traceparent,omitempty"` // Optional W3C trace context of the submitter
+}
+
+// ParseTraceParent extracts the span context from a W3C traceparent value, for example
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01, so that the spans of the runner
+// can be recorded as a part of the trace of the submitter
+//
+func ParseTraceParent(traceParent string) (sc trace.SpanContext, err kv.Error) {
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, kv.NewError("traceparent malformed").With("traceparent", traceParent).With("stack", stack.Trace().TrimRuntime())
+	}
+	// Version ff is invalid, later versions must be parsed as version 00 with trailing fields ignored
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, kv.NewError("traceparent version unsupported").With("traceparent", traceParent).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	fields := []struct {
+		hex  string
+		dest []byte
+	}{
+		{parts[1], sc.TraceID[:]},
+		{parts[2], sc.SpanID[:]},
+	}
+	for _, field := range fields {
+		if _, errGo := hex.Decode(field.dest, []byte(field.hex)); errGo != nil {
+			return trace.SpanContext{}, kv.Wrap(errGo).With("traceparent", traceParent).With("stack", stack.Trace().TrimRuntime())
+		}
+	}
+	if sc.TraceID == (trace.TraceID{}) || sc.SpanID == (trace.SpanID{}) {
+		return trace.SpanContext{}, kv.NewError("traceparent identifiers invalid").With("traceparent", traceParent).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	flags, errGo := hex.DecodeString(parts[3])
+	if errGo != nil {
+		return trace.SpanContext{}, kv.Wrap(errGo).With("traceparent", traceParent).With("stack", stack.Trace().TrimRuntime())
+	}
+	sc.TraceOptions = trace.TraceOptions(flags[0] & 0x01)
+
+	return sc, nil
 }
 
 // Request marshals the requests made by studioML under which all of the other
@@ -0,0 +1,331 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the hardened archive extraction used by all of the storage implementations
+// when unpacking artifacts.  Entries that would escape the output directory, links that
+// point outside of it, and special files such as devices are rejected.  Limits on the number
+// of bytes and files extracted can be placed into the context used for downloads so that
+// a single artifact cannot exhaust the disk allocation of an experiment.
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+const (
+	// BytesPerInode is used to derive a limit on the number of files that can be extracted from
+	// the disk space allocated to an experiment, it mirrors the default ratio used by mke2fs
+	BytesPerInode = uint64(16 * 1024)
+)
+
+var (
+	// ErrArchivePathEscape indicates an archive entry had an absolute path, or a path that
+	// would have been written outside of the output directory
+	ErrArchivePathEscape = kv.NewError("archive entry escapes the output directory")
+
+	// ErrArchiveLinkEscape indicates an archive contained a symbolic, or hard link whose target
+	// is outside of the output directory
+	ErrArchiveLinkEscape = kv.NewError("archive link escapes the output directory")
+
+	// ErrArchiveEntryType indicates an archive contained an entry, such as a device, or fifo,
+	// that is not permitted within artifacts
+	ErrArchiveEntryType = kv.NewError("archive entry type not permitted")
+
+	// ErrArchiveSizeLimit indicates the contents of an archive exceeded the number of bytes
+	// that an artifact is permitted to extract
+	ErrArchiveSizeLimit = kv.NewError("archive exceeds the extracted size limit")
+
+	// ErrArchiveFileLimit indicates an archive contained more entries than an artifact is
+	// permitted to extract
+	ErrArchiveFileLimit = kv.NewError("archive exceeds the extracted file count limit")
+)
+
+// errorCause returns the error originally wrapped by err, allowing errors returned by this
+// package to be compared with the sentinel errors above
+//
+func errorCause(err error) (cause error) {
+	for err != nil {
+		unwrap, isWrapper := err.(interface{ Unwrap() error })
+		if !isWrapper || unwrap.Unwrap() == nil {
+			break
+		}
+		err = unwrap.Unwrap()
+	}
+	return err
+}
+
+// ExtractLimits contains the maximum number of bytes and files that can be extracted from
+// a single artifact, zero values indicate no limit
+//
+type ExtractLimits struct {
+	MaxBytes uint64
+	MaxFiles uint64
+}
+
+type extractLimitsKey struct{}
+
+// NewExtractLimits will derive the extraction limits for artifacts from the disk space
+// allocated to an experiment
+//
+func NewExtractLimits(hdd uint64) (limits ExtractLimits) {
+	return ExtractLimits{
+		MaxBytes: hdd,
+		MaxFiles: hdd / BytesPerInode,
+	}
+}
+
+// WithExtractLimits returns a context that carries the limits to be applied when artifacts
+// fetched using the context are unpacked
+//
+func WithExtractLimits(ctx context.Context, limits ExtractLimits) (limitCtx context.Context) {
+	return context.WithValue(ctx, extractLimitsKey{}, limits)
+}
+
+func extractLimits(ctx context.Context) (limits ExtractLimits) {
+	if ctx == nil {
+		return limits
+	}
+	limits, _ = ctx.Value(extractLimitsKey{}).(ExtractLimits)
+	return limits
+}
+
+// extractor tracks the output location and resources consumed while a single archive
+// is being unpacked
+type extractor struct {
+	root   string // The output directory with any symbolic links resolved
+	limits ExtractLimits
+	bytes  uint64
+	files  uint64
+}
+
+func newExtractor(ctx context.Context, output string) (e *extractor, err kv.Error) {
+	root, errGo := filepath.Abs(output)
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("output", output).With("stack", stack.Trace().TrimRuntime())
+	}
+	if root, errGo = filepath.EvalSymlinks(root); errGo != nil {
+		return nil, kv.Wrap(errGo).With("output", output).With("stack", stack.Trace().TrimRuntime())
+	}
+	return &extractor{
+		root:   root,
+		limits: extractLimits(ctx),
+	}, nil
+}
+
+// within tests that a path lexically resides inside the output directory
+func (e *extractor) within(path string) bool {
+	rel, errGo := filepath.Rel(e.root, path)
+	if errGo != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// count is used to account for a new entry against the file limit
+func (e *extractor) count(name string) (err kv.Error) {
+	e.files++
+	if e.limits.MaxFiles != 0 && e.files > e.limits.MaxFiles {
+		return kv.Wrap(ErrArchiveFileLimit).With("entry", name, "limit", e.limits.MaxFiles).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// target validates the name of an archive entry and returns the location it should be
+// written to.  Any missing parent directories are created once it has been established
+// that existing parents, including any symbolic links, are within the output directory.
+//
+func (e *extractor) target(name string) (path string, err kv.Error) {
+	if filepath.IsAbs(name) {
+		return "", kv.Wrap(ErrArchivePathEscape).With("entry", name).With("stack", stack.Trace().TrimRuntime())
+	}
+	path = filepath.Join(e.root, name)
+	if !e.within(path) {
+		return "", kv.Wrap(ErrArchivePathEscape).With("entry", name).With("stack", stack.Trace().TrimRuntime())
+	}
+	if path == e.root {
+		return path, nil
+	}
+
+	// Locate the closest parent that exists and make sure that, once symbolic links have
+	// been followed, it is still inside the output directory
+	parent := filepath.Dir(path)
+	for existing := parent; ; existing = filepath.Dir(existing) {
+		if _, errGo := os.Lstat(existing); errGo != nil {
+			continue
+		}
+		resolved, errGo := filepath.EvalSymlinks(existing)
+		if errGo != nil {
+			return "", kv.Wrap(errGo).With("entry", name).With("stack", stack.Trace().TrimRuntime())
+		}
+		if !e.within(resolved) {
+			return "", kv.Wrap(ErrArchivePathEscape).With("entry", name, "resolved", resolved).With("stack", stack.Trace().TrimRuntime())
+		}
+		break
+	}
+
+	if errGo := os.MkdirAll(parent, 0700); errGo != nil {
+		return "", kv.Wrap(errGo).With("entry", name).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	// An existing link at the location must not be followed when the entry is written
+	if info, errGo := os.Lstat(path); errGo == nil && info.Mode()&os.ModeSymlink != 0 {
+		if errGo = os.Remove(path); errGo != nil {
+			return "", kv.Wrap(errGo).With("entry", name).With("stack", stack.Trace().TrimRuntime())
+		}
+	}
+	return path, nil
+}
+
+func (e *extractor) mkdir(path string, mode os.FileMode) (err kv.Error) {
+	if errGo := os.MkdirAll(path, mode.Perm()|0700); errGo != nil {
+		return kv.Wrap(errGo).With("path", path).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// writeFile will copy the contents of an entry into a regular file, any setuid, setgid
+// and sticky bits are dropped
+func (e *extractor) writeFile(path string, mode os.FileMode, size int64, src io.Reader) (err kv.Error) {
+	if e.limits.MaxBytes != 0 && size > 0 && e.bytes+uint64(size) > e.limits.MaxBytes {
+		return kv.Wrap(ErrArchiveSizeLimit).With("path", path, "limit", e.limits.MaxBytes).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	file, errGo := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+	if errGo != nil {
+		return kv.Wrap(errGo).With("path", path).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	// The declared size of an entry cannot be trusted, zip archives in particular
+	if e.limits.MaxBytes != 0 {
+		src = io.LimitReader(src, int64(e.limits.MaxBytes-e.bytes)+1)
+	}
+	copied, errGo := io.Copy(file, src)
+	file.Close()
+
+	e.bytes += uint64(copied)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("path", path).With("stack", stack.Trace().TrimRuntime())
+	}
+	if e.limits.MaxBytes != 0 && e.bytes > e.limits.MaxBytes {
+		return kv.Wrap(ErrArchiveSizeLimit).With("path", path, "limit", e.limits.MaxBytes).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// symlink will create a relative symbolic link that must point within the output directory.
+// Links are resolved from the directory they are created in once any symbolic links in its
+// path have been followed, and may only ascend using leading '..' components.  Allowing
+// '..' after other components would let a link pass through an earlier link, for example
+// 'y/../x' where y is a link to '..', and resolve to a location other than the one checked.
+//
+func (e *extractor) symlink(path string, linkname string) (err kv.Error) {
+	if filepath.IsAbs(linkname) {
+		return kv.Wrap(ErrArchiveLinkEscape).With("path", path, "link", linkname).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	resolved, errGo := filepath.EvalSymlinks(filepath.Dir(path))
+	if errGo != nil {
+		return kv.Wrap(errGo).With("path", path, "link", linkname).With("stack", stack.Trace().TrimRuntime())
+	}
+	descending := false
+	for _, part := range strings.Split(linkname, string(filepath.Separator)) {
+		switch part {
+		case "", ".":
+		case "..":
+			if descending {
+				return kv.Wrap(ErrArchiveLinkEscape).With("path", path, "link", linkname).With("stack", stack.Trace().TrimRuntime())
+			}
+			resolved = filepath.Dir(resolved)
+		default:
+			descending = true
+			resolved = filepath.Join(resolved, part)
+		}
+	}
+	if !e.within(resolved) {
+		return kv.Wrap(ErrArchiveLinkEscape).With("path", path, "link", linkname).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	if errGo := os.Symlink(linkname, path); errGo != nil {
+		return kv.Wrap(errGo, "symbolic link create failed").With("path", path, "link", linkname).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// hardlink will create a hard link to a file that has already been extracted
+func (e *extractor) hardlink(path string, linkname string) (err kv.Error) {
+	if filepath.IsAbs(linkname) || !e.within(filepath.Join(e.root, linkname)) {
+		return kv.Wrap(ErrArchiveLinkEscape).With("path", path, "link", linkname).With("stack", stack.Trace().TrimRuntime())
+	}
+	resolved, errGo := filepath.EvalSymlinks(filepath.Join(e.root, linkname))
+	if errGo != nil {
+		return kv.Wrap(errGo).With("path", path, "link", linkname).With("stack", stack.Trace().TrimRuntime())
+	}
+	if !e.within(resolved) {
+		return kv.Wrap(ErrArchiveLinkEscape).With("path", path, "link", linkname).With("stack", stack.Trace().TrimRuntime())
+	}
+	if errGo = os.Link(resolved, path); errGo != nil {
+		return kv.Wrap(errGo, "hard link create failed").With("path", path, "link", linkname).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// Untar will extract the contents of a tar stream into the output directory applying any
+// limits present in the context
+//
+func Untar(ctx context.Context, in io.Reader, output string) (err kv.Error) {
+
+	e, err := newExtractor(ctx, output)
+	if err != nil {
+		return err
+	}
+
+	tarReader := tar.NewReader(in)
+
+	for {
+		header, errGo := tarReader.Next()
+		if errGo == io.EOF {
+			break
+		} else if errGo != nil {
+			return kv.Wrap(errGo).With("output", output).With("stack", stack.Trace().TrimRuntime())
+		}
+
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		if err = e.count(header.Name); err != nil {
+			return err
+		}
+
+		path, err := e.target(header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = e.mkdir(path, header.FileInfo().Mode())
+		case tar.TypeReg, tar.TypeRegA:
+			err = e.writeFile(path, header.FileInfo().Mode(), header.Size, tarReader)
+		case tar.TypeSymlink:
+			err = e.symlink(path, header.Linkname)
+		case tar.TypeLink:
+			err = e.hardlink(path, header.Linkname)
+		default:
+			err = kv.Wrap(ErrArchiveEntryType).With("entry", header.Name, "type", fmt.Sprintf("%c", header.Typeflag)).With("stack", stack.Trace().TrimRuntime())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv"
+)
+
+func makeTar(t *testing.T, headers []*tar.Header) (archive *bytes.Buffer) {
+	archive = &bytes.Buffer{}
+	tw := tar.NewWriter(archive)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(header.Name))
+		}
+		if errGo := tw.WriteHeader(header); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, errGo := tw.Write([]byte(header.Name)); errGo != nil {
+				t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+			}
+		}
+	}
+	if errGo := tw.Close(); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	return archive
+}
+
+// TestExtractPolicy checks that archives attempting to write outside of the output directory,
+// or exceeding the extraction limits are rejected with the appropriate errors
+func TestExtractPolicy(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		limits  ExtractLimits
+		headers []*tar.Header
+		expect  error
+	}{
+		{
+			name: "valid",
+			headers: []*tar.Header{
+				{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0700},
+				{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 04755},
+				{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "file"},
+				{Name: "dir/hard", Typeflag: tar.TypeLink, Linkname: "dir/file"},
+				{Name: "dir/sub/", Typeflag: tar.TypeDir, Mode: 0700},
+				{Name: "dir/sub/up", Typeflag: tar.TypeSymlink, Linkname: "../file"},
+			},
+		},
+		{
+			name:    "traversal",
+			headers: []*tar.Header{{Name: "../escaped", Typeflag: tar.TypeReg, Mode: 0600}},
+			expect:  ErrArchivePathEscape,
+		},
+		{
+			name:    "absolute",
+			headers: []*tar.Header{{Name: "/tmp/escaped", Typeflag: tar.TypeReg, Mode: 0600}},
+			expect:  ErrArchivePathEscape,
+		},
+		{
+			name:    "absolute symlink",
+			headers: []*tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}},
+			expect:  ErrArchiveLinkEscape,
+		},
+		{
+			name:    "relative symlink",
+			headers: []*tar.Header{{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}},
+			expect:  ErrArchiveLinkEscape,
+		},
+		{
+			name: "write through symlink",
+			headers: []*tar.Header{
+				{Name: "loop", Typeflag: tar.TypeSymlink, Linkname: "."},
+				{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "loop/loop/.."},
+				{Name: "up/escaped", Typeflag: tar.TypeReg, Mode: 0600},
+			},
+			expect: ErrArchiveLinkEscape,
+		},
+		{
+			name: "chained symlink",
+			headers: []*tar.Header{
+				{Name: "a/", Typeflag: tar.TypeDir, Mode: 0700},
+				{Name: "a/y", Typeflag: tar.TypeSymlink, Linkname: ".."},
+				{Name: "a/x", Typeflag: tar.TypeSymlink, Linkname: "y/../x2"},
+				{Name: "a/x/escaped", Typeflag: tar.TypeReg, Mode: 0600},
+			},
+			expect: ErrArchiveLinkEscape,
+		},
+		{
+			name:    "hard link",
+			headers: []*tar.Header{{Name: "hard", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"}},
+			expect:  ErrArchiveLinkEscape,
+		},
+		{
+			name:    "device",
+			headers: []*tar.Header{{Name: "null", Typeflag: tar.TypeChar, Mode: 0600, Devmajor: 1, Devminor: 3}},
+			expect:  ErrArchiveEntryType,
+		},
+		{
+			name:   "size",
+			limits: ExtractLimits{MaxBytes: 10},
+			headers: []*tar.Header{
+				{Name: "first", Typeflag: tar.TypeReg, Mode: 0600},
+				{Name: "second", Typeflag: tar.TypeReg, Mode: 0600},
+			},
+			expect: ErrArchiveSizeLimit,
+		},
+		{
+			name:   "files",
+			limits: ExtractLimits{MaxFiles: 2},
+			headers: []*tar.Header{
+				{Name: "a", Typeflag: tar.TypeReg, Mode: 0600},
+				{Name: "b", Typeflag: tar.TypeReg, Mode: 0600},
+				{Name: "c", Typeflag: tar.TypeReg, Mode: 0600},
+			},
+			expect: ErrArchiveFileLimit,
+		},
+	}
+
+	for _, aCase := range cases {
+		func() {
+			dir, errGo := ioutil.TempDir("", "extract")
+			if errGo != nil {
+				t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+			}
+			defer os.RemoveAll(dir)
+
+			output := filepath.Join(dir, "a", "b")
+			if errGo = os.MkdirAll(output, 0700); errGo != nil {
+				t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+			}
+
+			ctx := WithExtractLimits(context.Background(), aCase.limits)
+			err := Untar(ctx, makeTar(t, aCase.headers), output)
+
+			if aCase.expect == nil {
+				if err != nil {
+					t.Fatal(err.With("case", aCase.name))
+				}
+				info, errGo := os.Stat(filepath.Join(output, "dir", "file"))
+				if errGo != nil {
+					t.Fatal(kv.Wrap(errGo).With("case", aCase.name).With("stack", stack.Trace().TrimRuntime()))
+				}
+				if info.Mode()&os.ModeSetuid != 0 {
+					t.Fatal(kv.NewError("setuid bit was retained").With("case", aCase.name).With("stack", stack.Trace().TrimRuntime()))
+				}
+				return
+			}
+			if errorCause(err) != aCase.expect {
+				t.Fatal(kv.NewError("unexpected result").With("case", aCase.name, "expected", aCase.expect, "error", err).With("stack", stack.Trace().TrimRuntime()))
+			}
+			if _, errGo := os.Stat(filepath.Join(dir, "a", "escaped")); errGo == nil {
+				t.Fatal(kv.NewError("file escaped the output directory").With("case", aCase.name).With("stack", stack.Trace().TrimRuntime()))
+			}
+		}()
+	}
+}
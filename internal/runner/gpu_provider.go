@@ -0,0 +1,135 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the interface used to discover the GPU cards present on a system along with
+// the selection of the provider that is used.  Providers are registered by the files that
+// implement them and one is chosen when the runner starts, allowing the allocation and
+// monitoring logic to be used with hardware from different vendors, or without any hardware
+// at all using the simulated provider.
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// gpuProvider is implemented by sources of GPU inventories
+//
+type gpuProvider interface {
+	// Inventory returns the devices currently present along with their state
+	Inventory() (devs cudaDevices, err kv.Error)
+}
+
+const (
+	// DefaultGPUProvider is the name of the provider used when one has not been selected
+	DefaultGPUProvider = "nvml"
+)
+
+var (
+	// gpuProviders contains the factories for the GPU providers available on the platform,
+	// the spec parameter is used to pass provider specific configuration such as a file name
+	gpuProviders = map[string]func(spec string) (provider gpuProvider, err kv.Error){}
+
+	gpuSource gpuProvider
+	gpuOnce   sync.Once
+)
+
+// GPUProviders returns the names of the GPU providers that can be used with InitGPUs
+//
+func GPUProviders() (names []string) {
+	names = make([]string, 0, len(gpuProviders))
+	for name := range gpuProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InitGPUs selects the provider used to discover GPU cards and loads the initial inventory
+// used for allocations.  It should be called once when the runner starts, if it is not then
+// the default provider is used the first time the GPU resources are used.
+//
+func InitGPUs(name string, spec string) (err kv.Error) {
+	initialized := false
+	gpuOnce.Do(func() {
+		initialized = true
+		err = loadGPUs(name, spec)
+	})
+	if !initialized {
+		return kv.NewError("GPU provider already initialized").With("provider", name).With("stack", stack.Trace().TrimRuntime())
+	}
+	return err
+}
+
+// ensureGPUs loads the default provider if one has not been selected by the runner
+//
+func ensureGPUs() {
+	gpuOnce.Do(func() {
+		loadGPUs(DefaultGPUProvider, "")
+	})
+}
+
+// loadGPUs creates the named provider and uses its inventory to populate the tracking
+// information for allocations, failures are recorded in CudaInitErr and CudaInitWarnings
+// as the runner will continue without GPUs when running on CPU only hardware
+//
+func loadGPUs(name string, spec string) (err kv.Error) {
+	factory, isPresent := gpuProviders[name]
+	if !isPresent {
+		err = kv.NewError("unknown GPU provider").With("provider", name, "providers", strings.Join(GPUProviders(), ",")).With("stack", stack.Trace().TrimRuntime())
+		gpuSource = &failedProvider{err: err}
+		CudaInitErr = &err
+		CudaInitWarnings = append(CudaInitWarnings, err)
+		return err
+	}
+
+	provider, err := factory(spec)
+	if err != nil {
+		gpuSource = &failedProvider{err: err}
+		CudaInitErr = &err
+		CudaInitWarnings = append(CudaInitWarnings, err)
+		return err
+	}
+	gpuSource = provider
+
+	gpuDevices, err := provider.Inventory()
+	if err != nil {
+		CudaInitErr = &err
+		CudaInitWarnings = append(CudaInitWarnings, err)
+		return err
+	}
+
+	devs := os.Getenv("CUDA_VISIBLE_DEVICES")
+	if len(devs) == 0 {
+		devs = os.Getenv("NVIDIA_VISIBLE_DEVICES")
+	}
+
+	tracker, warns := newGPUTracker(gpuDevices, devs)
+	CudaInitWarnings = append(CudaInitWarnings, warns...)
+
+	gpuAllocs.Lock()
+	gpuAllocs.Allocs = tracker.Allocs
+	gpuAllocs.Unlock()
+
+	return nil
+}
+
+// failedProvider stands in for a provider that could not be created
+//
+type failedProvider struct {
+	err kv.Error
+}
+
+// Inventory returns the error that prevented the real provider from being used
+//
+func (p *failedProvider) Inventory() (devs cudaDevices, err kv.Error) {
+	if p.err == nil {
+		return cudaDevices{Devices: []device{}}, kv.NewError("GPU provider not initialized").With("stack", stack.Trace().TrimRuntime())
+	}
+	return cudaDevices{Devices: []device{}}, p.err
+}
@@ -0,0 +1,153 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of a simulated GPU provider.  The cards it reports are
+// described using a JSON file and allow the GPU allocation and monitoring logic to be exercised
+// on systems without GPU hardware, for example within CI pipelines.  An example file follows:
+//
+// {
+//     "cards": [
+//         {"name": "Tesla V100", "count": 2, "mem": "16gib", "temp": 40, "temp_rise": 2, "temp_max": 85},
+//         {"name": "Tesla P40", "uuid": "GPU-faulty", "mem": "24gib", "ecc_after": "10m"}
+//     ]
+// }
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// simCard describes one or more identical simulated GPU cards
+//
+type simCard struct {
+	Name     string `json:"name"`      // The product name reported for the card, this determines the slots it has
+	UUID     string `json:"uuid"`      // The identifier for the card, generated from the position in the file if absent
+	Count    uint   `json:"count"`     // The number of cards to simulate, defaults to 1, suffixes are added to a supplied UUID
+	Mem      string `json:"mem"`       // The amount of memory on the card using SI, ICE units, for example 16gib
+	Temp     uint   `json:"temp"`      // The temperature of the card when the simulation starts
+	TempRise uint   `json:"temp_rise"` // The degrees the temperature rises every minute
+	TempMax  uint   `json:"temp_max"`  // The temperature at which the rise stops, 0 is unlimited
+	Powr     uint   `json:"powr"`      // The power consumption reported for the card
+	EccAfter string `json:"ecc_after"` // The duration after the simulation starts when the card reports an ECC failure, empty for none
+	NUMANode int    `json:"numa_node"` // The NUMA node the card is attached to
+	Util     uint   `json:"util"`      // The utilization percentage reported for the card
+}
+
+type simConfig struct {
+	Cards []simCard `json:"cards"`
+}
+
+// simProvider reports the simulated cards with state derived from the time since the
+// simulation started
+//
+type simProvider struct {
+	devs     []device
+	cards    []simCard
+	eccAfter []time.Duration
+	start    time.Time
+	now      func() time.Time
+}
+
+func init() {
+	gpuProviders["sim"] = newSimProvider
+}
+
+// newSimProvider loads the simulated cards from the JSON file named by spec
+//
+func newSimProvider(spec string) (provider gpuProvider, err kv.Error) {
+	data, errGo := ioutil.ReadFile(spec)
+	if errGo != nil {
+		return nil, kv.Wrap(errGo, "simulated GPU file could not be read").With("file", spec).With("stack", stack.Trace().TrimRuntime())
+	}
+	sim, err := newSimProviderFromJSON(data)
+	if err != nil {
+		return nil, err.With("file", spec)
+	}
+	return sim, nil
+}
+
+func newSimProviderFromJSON(data []byte) (sim *simProvider, err kv.Error) {
+	cfg := simConfig{}
+	if errGo := json.Unmarshal(data, &cfg); errGo != nil {
+		return nil, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	sim = &simProvider{
+		devs:     []device{},
+		cards:    []simCard{},
+		eccAfter: []time.Duration{},
+		start:    time.Now(),
+		now:      time.Now,
+	}
+
+	for i, card := range cfg.Cards {
+		mem, errGo := ParseBytes(card.Mem)
+		if errGo != nil {
+			return nil, kv.Wrap(errGo, "simulated GPU mem invalid").With("card", i, "mem", card.Mem).With("stack", stack.Trace().TrimRuntime())
+		}
+		eccAfter := time.Duration(-1)
+		if len(card.EccAfter) != 0 {
+			if eccAfter, errGo = time.ParseDuration(card.EccAfter); errGo != nil {
+				return nil, kv.Wrap(errGo, "simulated GPU ecc_after invalid").With("card", i, "ecc_after", card.EccAfter).With("stack", stack.Trace().TrimRuntime())
+			}
+		}
+
+		count := card.Count
+		if count == 0 {
+			count = 1
+		}
+		for j := uint(0); j < count; j++ {
+			uuid := card.UUID
+			switch {
+			case len(uuid) == 0:
+				uuid = fmt.Sprintf("GPU-sim-%d-%d", i, j)
+			case count > 1:
+				uuid = fmt.Sprintf("%s-%d", uuid, j)
+			}
+			sim.devs = append(sim.devs, device{
+				UUID:     uuid,
+				Name:     card.Name,
+				Powr:     card.Powr,
+				MemTot:   mem,
+				MemFree:  mem,
+				NUMANode: card.NUMANode,
+				Util:     card.Util,
+			})
+			sim.cards = append(sim.cards, card)
+			sim.eccAfter = append(sim.eccAfter, eccAfter)
+		}
+	}
+	return sim, nil
+}
+
+// Inventory returns the simulated cards with their temperatures, and ECC failures, adjusted
+// for the time that has passed since the simulation started
+//
+func (sim *simProvider) Inventory() (outDevs cudaDevices, err kv.Error) {
+	elapsed := sim.now().Sub(sim.start)
+
+	outDevs = cudaDevices{
+		Devices: make([]device, 0, len(sim.devs)),
+	}
+	for i, dev := range sim.devs {
+		card := sim.cards[i]
+
+		dev.Temp = card.Temp + card.TempRise*uint(elapsed/time.Minute)
+		if card.TempMax != 0 && dev.Temp > card.TempMax {
+			dev.Temp = card.TempMax
+		}
+
+		if sim.eccAfter[i] >= 0 && elapsed >= sim.eccAfter[i] {
+			failure := kv.NewError("simulated ECC failure").With("GPUID", dev.UUID).With("stack", stack.Trace().TrimRuntime())
+			dev.EccFailure = &failure
+		}
+		outDevs.Devices = append(outDevs.Devices, dev)
+	}
+	return outDevs, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// TestGPUSimECC uses the simulated GPU provider to check that a card reporting an ECC failure
+// during monitoring is no longer used for allocations
+//
+func TestGPUSimECC(t *testing.T) {
+	sim, err := newSimProviderFromJSON([]byte(`{"cards": [
+		{"name": "Tesla V100", "uuid": "GPU-good", "mem": "16gib", "temp": 40, "temp_rise": 5, "temp_max": 80},
+		{"name": "Tesla V100", "uuid": "GPU-faulty", "mem": "16gib", "ecc_after": "5m"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devs, err := sim.Inventory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker, warns := newGPUTracker(devs, "")
+	if len(warns) != 0 {
+		t.Fatal(warns[0])
+	}
+	if len(tracker.Allocs) != 2 || tracker.Allocs["GPU-faulty"].Slots != 16 {
+		t.Fatal(kv.NewError("simulated cards not tracked").With("allocs", tracker.Allocs).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if failures := tracker.updateDevices(devs); len(failures) != 0 {
+		t.Fatal(failures[0])
+	}
+
+	// Move the simulation past the point where the faulty card fails
+	sim.now = func() time.Time { return sim.start.Add(10 * time.Minute) }
+	if devs, err = sim.Inventory(); err != nil {
+		t.Fatal(err)
+	}
+	for _, dev := range devs.Devices {
+		if dev.UUID == "GPU-good" && dev.Temp != 80 {
+			t.Fatal(kv.NewError("simulated temperature unexpected").With("temp", dev.Temp).With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+	if failures := tracker.updateDevices(devs); len(failures) != 1 {
+		t.Fatal(kv.NewError("simulated ECC failure not seen").With("failures", len(failures)).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	alloc, err := tracker.AllocGPU(16, 0, []uint{16}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alloc) != 1 || alloc[0].uuid != "GPU-good" {
+		t.Fatal(kv.NewError("allocation used the failed card").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if _, err = tracker.AllocGPU(16, 0, []uint{16}, false); err == nil {
+		t.Fatal(kv.NewError("allocation succeeded using the failed card").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
@@ -5,24 +5,19 @@ package runner
 // This file contains the implementation for the storage sub system that will
 // be used by the runner to retrieve storage from cloud providers or localized storage
 import (
-	"archive/tar"
 	"bufio"
-	"compress/bzip2"
-	"compress/gzip"
 	"context"
 	"encoding/hex"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"cloud.google.com/go/storage"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 
-	bzip2w "github.com/dsnet/compress/bzip2"
-
 	"github.com/go-stack/stack"
 
 	"github.com/jjeffery/kv" // MIT License
@@ -92,7 +87,32 @@ func (s *gsStorage) Hash(ctx context.Context, name string) (hash string, err kv.
 // associated with a previous Hoard operation
 //
 func (s *gsStorage) Gather(ctx context.Context, keyPrefix string, outputDir string, tap io.Writer) (warnings []kv.Error, err kv.Error) {
-	return warnings, kv.NewError("unimplemented").With("stack", stack.Trace().TrimRuntime())
+
+	// Retrieve a list of the known keys that match the key prefix
+	names := []string{}
+	objects := s.client.Bucket(s.bucket).Objects(ctx, &storage.Query{Prefix: keyPrefix})
+	for {
+		attrs, errGo := objects.Next()
+		if errGo == iterator.Done {
+			break
+		}
+		if errGo != nil {
+			return warnings, kv.Wrap(errGo).With("bucket", s.bucket, "keyPrefix", keyPrefix).With("stack", stack.Trace().TrimRuntime())
+		}
+		names = append(names, attrs.Name)
+	}
+
+	// Download these files
+	for _, key := range names {
+		w, e := s.Fetch(ctx, key, false, outputDir, tap)
+		if len(w) != 0 {
+			warnings = append(warnings, w...)
+		}
+		if e != nil {
+			err = e
+		}
+	}
+	return warnings, err
 }
 
 // Fetch is used to retrieve a file from a well known google storage bucket and either
@@ -128,78 +148,37 @@ func (s *gsStorage) Fetch(ctx context.Context, name string, unpack bool, output
 	}
 	defer obj.Close()
 
+	body := NewThrottledReader(ctx, obj)
+
 	// If the unpack flag is set then use a tar decompressor and unpacker
 	// but first make sure the output location is an existing directory
 	if unpack {
 
-		var inReader io.ReadCloser
-
-		switch fileType {
-		case "application/x-gzip", "application/zip":
-			if tap != nil {
-				// Create a stack of reader that first tee off any data read to a tap
-				// the tap being able to send data to things like caches etc
-				//
-				// Second in the stack of readers after the TAP is a decompression reader
-				inReader, errGo = gzip.NewReader(io.TeeReader(obj, tap))
-			} else {
-				inReader, errGo = gzip.NewReader(obj)
-			}
-		case "application/bzip2", "application/octet-stream":
-			if tap != nil {
-				// Create a stack of reader that first tee off any data read to a tap
-				// the tap being able to send data to things like caches etc
-				//
-				// Second in the stack of readers after the TAP is a decompression reader
-				inReader = ioutil.NopCloser(bzip2.NewReader(io.TeeReader(obj, tap)))
-			} else {
-				inReader = ioutil.NopCloser(bzip2.NewReader(obj))
-			}
-		default:
-			if tap != nil {
-				// Create a stack of reader that first tee off any data read to a tap
-				// the tap being able to send data to things like caches etc
-				//
-				// Second in the stack of readers after the TAP is a decompression reader
-				inReader = ioutil.NopCloser(io.TeeReader(obj, tap))
-			} else {
-				inReader = ioutil.NopCloser(obj)
-			}
+		var src io.Reader = body
+		if tap != nil {
+			// Create a stack of reader that first tee off any data read to a tap
+			// the tap being able to send data to things like caches etc
+			//
+			src = io.TeeReader(body, tap)
 		}
-		if errGo != nil {
-			return warns, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
-		}
-		defer inReader.Close()
-
-		tarReader := tar.NewReader(inReader)
 
-		for {
-			header, errGo := tarReader.Next()
-			if errGo == io.EOF {
-				break
-			} else if errGo != nil {
-				return warns, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
-			}
-
-			path := filepath.Join(output, header.Name)
-			info := header.FileInfo()
-			if info.IsDir() {
-				if errGo = os.MkdirAll(path, info.Mode()); errGo != nil {
-					return warns, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
-				}
-				continue
+		// Zip archives are not streamable and are handled as a whole
+		if fileType == "application/zip" {
+			if err = Unzip(ctx, src, output); err != nil {
+				return warns, kv.Wrap(err).With("stack", stack.Trace().TrimRuntime())
 			}
+			return warns, nil
+		}
 
-			file, errGo := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
-			if errGo != nil {
-				return warns, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
-			}
+		// Second in the stack of readers after the TAP is a decompression reader
+		inReader, err := NewDecompressor(fileType, src)
+		if err != nil {
+			return warns, kv.Wrap(err).With("stack", stack.Trace().TrimRuntime())
+		}
+		defer inReader.Close()
 
-			_, errGo = io.Copy(file, tarReader)
-			file.Close()
-			if errGo != nil {
-				return warns, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
-			}
+		if err = Untar(ctx, inReader, output); err != nil {
+			return warns, kv.Wrap(err).With("fileType", fileType)
 		}
 	} else {
 		errGo := os.MkdirAll(output, 0700)
@@ -214,7 +193,7 @@ func (s *gsStorage) Fetch(ctx context.Context, name string, unpack bool, output
 		defer f.Close()
 
 		outf := bufio.NewWriter(f)
-		if _, errGo = io.Copy(outf, obj); errGo != nil {
+		if _, errGo = io.Copy(outf, body); errGo != nil {
 			return warns, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
 		}
 		outf.Flush()
@@ -222,11 +201,84 @@ func (s *gsStorage) Fetch(ctx context.Context, name string, unpack bool, output
 	return warns, nil
 }
 
+// FetchTail retrieves the contents of the named object starting at the offset supplied and
+// writes them to the output writer, it is used to resume interrupted downloads
+//
+func (s *gsStorage) FetchTail(ctx context.Context, name string, offset int64, out io.Writer) (err kv.Error) {
+
+	kv := kv.With("name", name).With("offset", offset)
+
+	obj, errGo := s.client.Bucket(s.bucket).Object(name).NewRangeReader(ctx, offset, -1)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer obj.Close()
+
+	if _, errGo = io.Copy(out, NewThrottledReader(ctx, obj)); errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
 // Hoard is used to upload the contents of a directory to the storage server as individual files rather than a single
 // archive
 //
 func (s *gsStorage) Hoard(ctx context.Context, src string, dest string) (warnings []kv.Error, err kv.Error) {
-	return warnings, kv.NewError("unimplemented").With("stack", stack.Trace().TrimRuntime())
+
+	// Walk files taking each uploadable file and placing into a collection
+	files := []string{}
+	errGo := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		// We have a file include it in the upload list
+		files = append(files, file)
+
+		return nil
+	})
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	// Upload files
+	for _, aFile := range files {
+		key := filepath.ToSlash(filepath.Join(dest, strings.TrimPrefix(aFile, src)))
+		if err = s.uploadFile(ctx, aFile, key); err != nil {
+			warnings = append(warnings, err)
+		}
+	}
+
+	if len(warnings) != 0 {
+		err = kv.NewError("one or more uploads failed").With("stack", stack.Trace().TrimRuntime()).With("src", src, "warnings", warnings)
+	}
+
+	return warnings, err
+}
+
+// uploadFile can be used to transmit a file to the google storage server using a fully qualified file
+// name and key
+//
+func (s *gsStorage) uploadFile(ctx context.Context, src string, dest string) (err kv.Error) {
+	file, errGo := os.Open(filepath.Clean(src))
+	if errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("src", src)
+	}
+	defer file.Close()
+
+	obj := s.client.Bucket(s.bucket).Object(dest).NewWriter(ctx)
+	obj.ContentType = "application/octet-stream"
+
+	if _, errGo = io.Copy(obj, NewThrottledReader(ctx, file)); errGo != nil {
+		obj.Close()
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("src", src, "bucket", s.bucket, "key", dest)
+	}
+	if errGo = obj.Close(); errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("src", src, "bucket", s.bucket, "key", dest)
+	}
+	return nil
 }
 
 // Deposit directories as compressed artifacts to the firebase storage for an
@@ -234,8 +286,8 @@ func (s *gsStorage) Hoard(ctx context.Context, src string, dest string) (warning
 //
 func (s *gsStorage) Deposit(ctx context.Context, src string, dest string) (warns []kv.Error, err kv.Error) {
 
-	if !IsTar(dest) {
-		return warns, kv.NewError("uploads must be tar, or tar compressed files").With("stack", stack.Trace().TrimRuntime()).With("key", dest)
+	if !IsArchive(dest) {
+		return warns, kv.NewError("uploads must be tar, tar compressed, or zip files").With("stack", stack.Trace().TrimRuntime()).With("key", dest)
 	}
 
 	obj := s.client.Bucket(s.bucket).Object(dest).NewWriter(ctx)
@@ -250,36 +302,17 @@ func (s *gsStorage) Deposit(ctx context.Context, src string, dest string) (warns
 		return warns, nil
 	}
 
-	var outw io.Writer
-
 	typ, w := MimeFromExt(dest)
-	warns = append(warns, w)
-
-	switch typ {
-	case "application/tar", "application/octet-stream":
-		outw = bufio.NewWriter(obj)
-	case "application/bzip2":
-		outZ, errGo := bzip2w.NewWriter(obj, &bzip2w.WriterConfig{Level: 6})
-		if err != nil {
-			return warns, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
-		}
-		defer outZ.Close()
-		outw = outZ
-	case "application/x-gzip":
-		outZ := gzip.NewWriter(obj)
-		defer outZ.Close()
-		outw = outZ
-	case "application/zip":
-		return warns, kv.NewError("only tar archives are supported").With("stack", stack.Trace().TrimRuntime()).With("key", dest)
-	default:
-		return warns, kv.NewError("unrecognized upload compression").With("stack", stack.Trace().TrimRuntime()).With("key", dest)
+	if w != nil {
+		warns = append(warns, w)
 	}
 
-	tw := tar.NewWriter(outw)
-	defer tw.Close()
-
-	if err = files.Write(tw); err != nil {
-		return warns, err.(kv.Error)
+	outw := bufio.NewWriter(obj)
+	if err = WriteArchive(typ, files, outw); err != nil {
+		return warns, err.With("key", dest)
+	}
+	if errGo := outw.Flush(); errGo != nil {
+		return warns, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("key", dest)
 	}
 	return warns, nil
 }
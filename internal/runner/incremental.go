@@ -0,0 +1,387 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of incremental artifacts.  Incremental artifacts are
+// mutable artifacts that are stored as a content addressed collection of file blobs along with
+// a manifest that describes how the blobs are assembled into the artifact directory.  When
+// checkpointing only the blobs that have changed since the previous upload are transferred.
+//
+// The manifest is stored using the artifact key with a '.manifest' suffix, and the blobs are
+// stored under a 'blobs' prefix within the same directory as the artifact key using the SHA256
+// of the file contents as their names.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// ManifestFile describes a single file system entry within an incremental artifact
+//
+type ManifestFile struct {
+	Path string      `json:"path"`
+	Hash string      `json:"hash,omitempty"`
+	Size int64       `json:"size"`
+	Mode os.FileMode `json:"mode"`
+	Link string      `json:"link,omitempty"`
+}
+
+// ArtifactManifest is the catalog of the files that make up an incremental artifact
+//
+type ArtifactManifest struct {
+	Files []ManifestFile `json:"files"`
+}
+
+var (
+	// ErrIncrementalBlobHash indicates a blob retrieved for an incremental artifact did not
+	// match the hash recorded for it within the manifest, or the hash was not a valid SHA256
+	ErrIncrementalBlobHash = kv.NewError("incremental artifact blob does not match its manifest hash")
+)
+
+// fileHash is used to remember the content hash of a file so that unchanged files
+// do not need to be read for every checkpoint
+type fileHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// ManifestKey returns the storage key used for the manifest of an incremental artifact
+//
+func ManifestKey(key string) string {
+	return key + ".manifest"
+}
+
+// blobPrefix returns the storage key prefix under which the content addressed blobs for
+// an incremental artifact are stored
+func blobPrefix(key string) string {
+	return path.Join(path.Dir(key), "blobs")
+}
+
+// hashFile is used to generate the content hash for a file, optionally copying the
+// contents into a second file as the hash is being calculated
+func hashFile(fn string, copyTo string) (hash string, err kv.Error) {
+	f, errGo := os.Open(filepath.Clean(fn))
+	if errGo != nil {
+		return "", kv.Wrap(errGo).With("file", fn).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	var w io.Writer = hasher
+
+	if len(copyTo) != 0 {
+		out, errGo := os.OpenFile(copyTo, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if errGo != nil {
+			return "", kv.Wrap(errGo).With("file", copyTo).With("stack", stack.Trace().TrimRuntime())
+		}
+		defer out.Close()
+		w = io.MultiWriter(hasher, out)
+	}
+
+	if _, errGo = io.Copy(w, f); errGo != nil {
+		return "", kv.Wrap(errGo).With("file", fn).With("stack", stack.Trace().TrimRuntime())
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// knownHash will return a previously calculated content hash for a file if the file
+// appears not to have been modified since the hash was calculated
+func (cache *ArtifactCache) knownHash(fn string, info os.FileInfo) (hash string) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	known, isPresent := cache.fileHashes[fn]
+	if !isPresent || known.size != info.Size() || !known.modTime.Equal(info.ModTime()) {
+		return ""
+	}
+	return known.hash
+}
+
+func (cache *ArtifactCache) rememberHash(fn string, info os.FileInfo, hash string) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.fileHashes[fn] = fileHash{
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		hash:    hash,
+	}
+}
+
+func (cache *ArtifactCache) isUploaded(key string, hash string) (isUploaded bool) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if blobs, isPresent := cache.upBlobs[key]; isPresent {
+		_, isUploaded = blobs[hash]
+	}
+	return isUploaded
+}
+
+func (cache *ArtifactCache) markUploaded(key string, hashes []string) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	blobs, isPresent := cache.upBlobs[key]
+	if !isPresent {
+		blobs = map[string]struct{}{}
+		cache.upBlobs[key] = blobs
+	}
+	for _, hash := range hashes {
+		blobs[hash] = struct{}{}
+	}
+}
+
+// manifestPath validates a relative path from a manifest and returns the location on the
+// local file system it corresponds to
+func manifestPath(dest string, relative string) (fn string, err kv.Error) {
+	fn = filepath.Join(dest, filepath.FromSlash(relative))
+	if filepath.IsAbs(relative) || (fn != dest && !strings.HasPrefix(fn, dest+string(filepath.Separator))) {
+		return "", kv.NewError("manifest path escapes the artifact directory").With("path", relative).With("stack", stack.Trace().TrimRuntime())
+	}
+	return fn, nil
+}
+
+// validBlobHash checks that a hash from a manifest is a hex encoded SHA256 and so can be
+// used safely as the name of a blob
+func validBlobHash(hash string) (valid bool) {
+	if len(hash) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	_, errGo := hex.DecodeString(hash)
+	return errGo == nil
+}
+
+// fetchIncremental retrieves the manifest for an incremental artifact and then the blobs it references
+// and assembles them into the destination directory
+//
+func (cache *ArtifactCache) fetchIncremental(ctx context.Context, storage *objStore, art *Artifact, dest string) (warns []kv.Error, err kv.Error) {
+
+	tmp, errGo := ioutil.TempDir(filepath.Dir(dest), ".incremental-")
+	if errGo != nil {
+		return warns, kv.Wrap(errGo).With("dest", dest).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(tmp)
+
+	// Symbolic links within the manifest are held to the same rules as those within archives
+	links, err := newExtractor(ctx, dest)
+	if err != nil {
+		return warns, err
+	}
+
+	manifestKey := ManifestKey(art.Key)
+	if warns, err = storage.Fetch(ctx, manifestKey, false, tmp); err != nil {
+		return warns, err
+	}
+
+	data, errGo := ioutil.ReadFile(filepath.Join(tmp, path.Base(manifestKey)))
+	if errGo != nil {
+		return warns, kv.Wrap(errGo).With("key", manifestKey).With("stack", stack.Trace().TrimRuntime())
+	}
+	manifest := &ArtifactManifest{}
+	if errGo = json.Unmarshal(data, manifest); errGo != nil {
+		return warns, kv.Wrap(errGo).With("key", manifestKey).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	// Blobs are named by their content so duplicated files need only be downloaded once
+	// and then copied from the first location they were written to
+	fetched := map[string]string{}
+	hashes := make([]string, 0, len(manifest.Files))
+
+	for _, file := range manifest.Files {
+		fn, err := manifestPath(dest, file.Path)
+		if err != nil {
+			return warns, err
+		}
+
+		switch {
+		case file.Mode.IsDir():
+			if errGo = os.MkdirAll(fn, file.Mode.Perm()|0700); errGo != nil {
+				return warns, kv.Wrap(errGo).With("path", fn).With("stack", stack.Trace().TrimRuntime())
+			}
+			continue
+		case file.Mode&os.ModeSymlink != 0:
+			if errGo = os.MkdirAll(filepath.Dir(fn), 0700); errGo != nil {
+				return warns, kv.Wrap(errGo).With("path", fn).With("stack", stack.Trace().TrimRuntime())
+			}
+			if err = links.symlink(fn, file.Link); err != nil {
+				return warns, err
+			}
+			continue
+		}
+
+		if !validBlobHash(file.Hash) {
+			return warns, kv.Wrap(ErrIncrementalBlobHash).With("path", file.Path, "hash", file.Hash).With("stack", stack.Trace().TrimRuntime())
+		}
+
+		if errGo = os.MkdirAll(filepath.Dir(fn), 0700); errGo != nil {
+			return warns, kv.Wrap(errGo).With("path", fn).With("stack", stack.Trace().TrimRuntime())
+		}
+
+		if prior, isPresent := fetched[file.Hash]; isPresent {
+			if _, err = hashFile(prior, fn); err != nil {
+				return warns, err
+			}
+		} else {
+			w, err := storage.Fetch(ctx, path.Join(blobPrefix(art.Key), file.Hash), false, tmp)
+			warns = append(warns, w...)
+			if err != nil {
+				return warns, err.With("path", file.Path)
+			}
+			// Blobs are only placed into the artifact once their contents are known to match
+			blob := filepath.Join(tmp, file.Hash)
+			hash, err := hashFile(blob, "")
+			if err != nil {
+				return warns, err.With("path", file.Path)
+			}
+			if hash != file.Hash {
+				return warns, kv.Wrap(ErrIncrementalBlobHash).With("path", file.Path, "hash", file.Hash, "actual", hash).With("stack", stack.Trace().TrimRuntime())
+			}
+			if errGo = os.Rename(blob, fn); errGo != nil {
+				return warns, kv.Wrap(errGo).With("path", fn).With("stack", stack.Trace().TrimRuntime())
+			}
+			fetched[file.Hash] = fn
+			hashes = append(hashes, file.Hash)
+		}
+
+		if errGo = os.Chmod(fn, file.Mode.Perm()); errGo != nil {
+			return warns, kv.Wrap(errGo).With("path", fn).With("stack", stack.Trace().TrimRuntime())
+		}
+
+		// Remember the hashes of what was downloaded so that the next checkpoint only
+		// uploads the files that the experiment changes
+		if info, errGo := os.Stat(fn); errGo == nil {
+			cache.rememberHash(fn, info, file.Hash)
+		}
+	}
+
+	cache.markUploaded(art.Key, hashes)
+
+	return warns, nil
+}
+
+// restoreIncremental uploads the blobs for files that have changed within an incremental
+// artifact directory and then uploads a new manifest for the artifact
+//
+func (cache *ArtifactCache) restoreIncremental(ctx context.Context, storage *objStore, art *Artifact, source string, dir string) (warns []kv.Error, err kv.Error) {
+
+	staging, errGo := ioutil.TempDir(dir, ".incremental-")
+	if errGo != nil {
+		return warns, kv.Wrap(errGo).With("dir", dir).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(staging)
+
+	blobDir := filepath.Join(staging, "blobs")
+	if errGo = os.MkdirAll(blobDir, 0700); errGo != nil {
+		return warns, kv.Wrap(errGo).With("dir", blobDir).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	manifest := &ArtifactManifest{}
+	staged := []string{}
+
+	errGo = filepath.Walk(source, func(fn string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Working files can be recycled on occasion and disappear, handle this
+			// possibility
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fn == source {
+			return nil
+		}
+
+		file := ManifestFile{
+			Path: filepath.ToSlash(strings.TrimPrefix(fn, source+string(filepath.Separator))),
+			Size: info.Size(),
+			Mode: info.Mode(),
+		}
+
+		switch {
+		case info.IsDir():
+			file.Size = 0
+		case info.Mode()&os.ModeSymlink != 0:
+			link, errGo := os.Readlink(fn)
+			if errGo != nil {
+				return kv.Wrap(errGo).With("file", fn).With("stack", stack.Trace().TrimRuntime())
+			}
+			file.Link = link
+		case info.Mode().IsRegular():
+			file.Hash = cache.knownHash(fn, info)
+			if len(file.Hash) == 0 || !cache.isUploaded(art.Key, file.Hash) {
+				// Copy the file into the staging area as the hash is generated so that
+				// the blob being uploaded is guaranteed to match its name even when the
+				// experiment is modifying the file
+				copyTo := filepath.Join(staging, "pending")
+				hash, err := hashFile(fn, copyTo)
+				if err != nil {
+					return err
+				}
+				file.Hash = hash
+				cache.rememberHash(fn, info, hash)
+
+				blob := filepath.Join(blobDir, hash)
+				if _, errGo := os.Stat(blob); os.IsNotExist(errGo) && !cache.isUploaded(art.Key, hash) {
+					if errGo := os.Rename(copyTo, blob); errGo != nil {
+						return kv.Wrap(errGo).With("file", fn).With("stack", stack.Trace().TrimRuntime())
+					}
+					staged = append(staged, hash)
+				}
+			}
+		default:
+			// Devices, sockets and pipes have no meaningful content to be saved
+			return nil
+		}
+
+		manifest.Files = append(manifest.Files, file)
+		return nil
+	})
+	os.Remove(filepath.Join(staging, "pending"))
+
+	if errGo != nil {
+		if err, isKV := errGo.(kv.Error); isKV {
+			return warns, err
+		}
+		return warns, kv.Wrap(errGo).With("source", source).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	if len(staged) != 0 {
+		if warns, err = storage.Hoard(ctx, blobDir, blobPrefix(art.Key)); err != nil {
+			return warns, err
+		}
+		cache.markUploaded(art.Key, staged)
+	}
+
+	// Now the blobs are in place write the manifest that references them
+	manifestDir := filepath.Join(staging, "manifest")
+	if errGo = os.MkdirAll(manifestDir, 0700); errGo != nil {
+		return warns, kv.Wrap(errGo).With("dir", manifestDir).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	data, errGo := json.Marshal(manifest)
+	if errGo != nil {
+		return warns, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	manifestKey := ManifestKey(art.Key)
+	if errGo = ioutil.WriteFile(filepath.Join(manifestDir, path.Base(manifestKey)), data, 0600); errGo != nil {
+		return warns, kv.Wrap(errGo).With("dir", manifestDir).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	w, err := storage.Hoard(ctx, manifestDir, path.Dir(manifestKey))
+	return append(warns, w...), err
+}
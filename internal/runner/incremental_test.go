@@ -0,0 +1,211 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv"
+)
+
+// dirStorage is a Storage implementation that uses a local directory as the backing
+// store and records the keys that were uploaded
+type dirStorage struct {
+	root     string
+	uploaded []string
+}
+
+func (s *dirStorage) Gather(ctx context.Context, keyPrefix string, outputDir string, tap io.Writer) (warnings []kv.Error, err kv.Error) {
+	return warnings, kv.NewError("unimplemented").With("stack", stack.Trace().TrimRuntime())
+}
+
+func (s *dirStorage) Fetch(ctx context.Context, name string, unpack bool, output string, tap io.Writer) (warnings []kv.Error, err kv.Error) {
+	_, err = hashFile(filepath.Join(s.root, name), filepath.Join(output, filepath.Base(name)))
+	return warnings, err
+}
+
+func (s *dirStorage) Hoard(ctx context.Context, srcDir string, keyPrefix string) (warnings []kv.Error, err kv.Error) {
+	errGo := filepath.Walk(srcDir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		key := filepath.Join(keyPrefix, strings.TrimPrefix(file, srcDir))
+		if errGo := os.MkdirAll(filepath.Dir(filepath.Join(s.root, key)), 0700); errGo != nil {
+			return errGo
+		}
+		s.uploaded = append(s.uploaded, key)
+		_, err = hashFile(file, filepath.Join(s.root, key))
+		return err
+	})
+	if errGo != nil {
+		return warnings, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	return warnings, nil
+}
+
+func (s *dirStorage) Deposit(ctx context.Context, src string, dest string) (warnings []kv.Error, err kv.Error) {
+	return warnings, kv.NewError("unimplemented").With("stack", stack.Trace().TrimRuntime())
+}
+
+func (s *dirStorage) Hash(ctx context.Context, name string) (hash string, err kv.Error) {
+	return filepath.Base(name), nil
+}
+
+func (s *dirStorage) Close() {}
+
+// TestIncrementalArtifact checks that incremental artifacts upload only changed files
+// and that the artifact can then be reassembled from the manifest
+func TestIncrementalArtifact(t *testing.T) {
+
+	dir, errGo := ioutil.TempDir("", "incremental")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(dir)
+
+	store := &dirStorage{root: filepath.Join(dir, "bucket")}
+	storage := &objStore{store: store}
+	cache := NewArtifactCache()
+	art := &Artifact{
+		Key:         "experiments/test/modeldir.tar",
+		Mutable:     true,
+		Incremental: true,
+	}
+
+	exprDir := filepath.Join(dir, "expr")
+	source := filepath.Join(exprDir, "modeldir")
+	contents := map[string]string{
+		"a.bin":     RandomString(4096),
+		"b/b.bin":   RandomString(8192),
+		"b/c/c.bin": RandomString(10),
+	}
+	for name, data := range contents {
+		fn := filepath.Join(source, name)
+		if errGo = os.MkdirAll(filepath.Dir(fn), 0700); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if errGo = ioutil.WriteFile(fn, []byte(data), 0600); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+
+	ctx := context.Background()
+	if _, err := cache.restoreIncremental(ctx, storage, art, source, exprDir); err != nil {
+		t.Fatal(err)
+	}
+	// All three blobs and the manifest should have been uploaded
+	if len(store.uploaded) != 4 {
+		t.Fatal(kv.NewError("unexpected upload count").With("uploaded", store.uploaded).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// Change a single file and ensure only it and the manifest are uploaded
+	contents["a.bin"] = RandomString(4096)
+	if errGo = ioutil.WriteFile(filepath.Join(source, "a.bin"), []byte(contents["a.bin"]), 0600); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	store.uploaded = []string{}
+	if _, err := cache.restoreIncremental(ctx, storage, art, source, exprDir); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.uploaded) != 2 {
+		t.Fatal(kv.NewError("unexpected upload count").With("uploaded", store.uploaded).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// Now reassemble the artifact using a fresh cache as would be done on the next attempt
+	dest := filepath.Join(dir, "next", "modeldir")
+	if errGo = os.MkdirAll(dest, 0700); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	next := NewArtifactCache()
+	if _, err := next.fetchIncremental(ctx, storage, art, dest); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range contents {
+		fetched, errGo := ioutil.ReadFile(filepath.Join(dest, name))
+		if errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("file", name).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if string(fetched) != data {
+			t.Fatal(kv.NewError("reassembled contents did not match").With("file", name).With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+
+	// A checkpoint immediately after the fetch should only upload the manifest
+	store.uploaded = []string{}
+	if _, err := next.restoreIncremental(ctx, storage, art, dest, filepath.Dir(dest)); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.uploaded) != 1 {
+		t.Fatal(kv.NewError("unexpected upload count").With("uploaded", store.uploaded).With("stack", stack.Trace().TrimRuntime()))
+	}
+}
+
+// TestIncrementalManifestChecks checks that manifests cannot create symbolic links that leave
+// the artifact directory, and that blobs not matching their manifest hash are rejected
+func TestIncrementalManifestChecks(t *testing.T) {
+
+	dir, errGo := ioutil.TempDir("", "incremental")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(dir)
+
+	store := &dirStorage{root: filepath.Join(dir, "bucket")}
+	storage := &objStore{store: store}
+	art := &Artifact{
+		Key:         "experiments/test/modeldir.tar",
+		Mutable:     true,
+		Incremental: true,
+	}
+
+	// Place a blob into storage whose contents do not match the name it was given
+	blobDir := filepath.Join(store.root, filepath.FromSlash(blobPrefix(art.Key)))
+	if errGo = os.MkdirAll(blobDir, 0700); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	tampered := strings.Repeat("0", 64)
+	if errGo = ioutil.WriteFile(filepath.Join(blobDir, tampered), []byte("tampered"), 0600); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	cases := []struct {
+		name   string
+		file   ManifestFile
+		expect kv.Error
+	}{
+		{"absolute link", ManifestFile{Path: "link", Mode: os.ModeSymlink | 0777, Link: "/etc"}, ErrArchiveLinkEscape},
+		{"escaping link", ManifestFile{Path: "sub/link", Mode: os.ModeSymlink | 0777, Link: "../../outside"}, ErrArchiveLinkEscape},
+		{"tampered blob", ManifestFile{Path: "data", Mode: 0600, Hash: tampered}, ErrIncrementalBlobHash},
+		{"invalid hash", ManifestFile{Path: "data", Mode: 0600, Hash: "../" + tampered}, ErrIncrementalBlobHash},
+	}
+
+	for _, aCase := range cases {
+		data, errGo := json.Marshal(&ArtifactManifest{Files: []ManifestFile{aCase.file}})
+		if errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if errGo = ioutil.WriteFile(filepath.Join(store.root, filepath.FromSlash(ManifestKey(art.Key))), data, 0600); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+
+		dest := filepath.Join(dir, aCase.name, "modeldir")
+		if errGo = os.MkdirAll(dest, 0700); errGo != nil {
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+		_, err := NewArtifactCache().fetchIncremental(context.Background(), storage, art, dest)
+		if err == nil || errorCause(err) != aCase.expect {
+			t.Fatal(kv.NewError("manifest was not rejected").With("case", aCase.name, "error", err).With("stack", stack.Trace().TrimRuntime()))
+		}
+		if _, errGo = os.Lstat(filepath.Join(dest, filepath.FromSlash(aCase.file.Path))); errGo == nil {
+			t.Fatal(kv.NewError("rejected manifest entry was created").With("case", aCase.name).With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+}
@@ -188,15 +188,53 @@ type K8sStateUpdate struct {
 	State types.K8sState
 }
 
+// K8sConfigUpdate contains the runner configuration values, other than the STATE, found
+// in the global and pod specific configMaps.  Values in the pod specific map supersede those
+// in the global map.
+//
+type K8sConfigUpdate struct {
+	Values map[string]string
+}
+
+// mergeK8sConfig combines the global and pod specific configuration values, excluding the STATE
+//
+func mergeK8sConfig(global map[string]string, pod map[string]string) (values map[string]string) {
+	values = make(map[string]string, len(global)+len(pod))
+	for _, cfg := range []map[string]string{global, pod} {
+		for k, v := range cfg {
+			if k == "STATE" {
+				continue
+			}
+			values[k] = v
+		}
+	}
+	return values
+}
+
+func sameK8sConfig(a map[string]string, b map[string]string) (same bool) {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if other, isPresent := b[k]; !isPresent || other != v {
+			return false
+		}
+	}
+	return true
+}
+
 // ListenK8s will register a listener to watch for pod specific configMaps in k8s
 // and will relay state changes to a channel,  the global state map should exist
 // at the bare minimum.  A state change in either map superseded any previous
 // state.
 //
+// When configC is supplied the remaining values within the maps are relayed to it
+// whenever they change allowing the runner to be reconfigured while running.
+//
 // This is a blocking function that will return either upon an error in API calls
 // to the cluster API or when the ctx is Done().
 //
-func ListenK8s(ctx context.Context, namespace string, globalMap string, podMap string, updateC chan<- K8sStateUpdate, errC chan<- kv.Error) (err kv.Error) {
+func ListenK8s(ctx context.Context, namespace string, globalMap string, podMap string, updateC chan<- K8sStateUpdate, configC chan<- K8sConfigUpdate, errC chan<- kv.Error) (err kv.Error) {
 
 	// If k8s is not being used ignore this feature
 	if err = IsAliveK8s(); err != nil {
@@ -208,6 +246,11 @@ func ListenK8s(ctx context.Context, namespace string, globalMap string, podMap s
 		State: types.K8sUnknown,
 	}
 
+	// The configuration values from each of the maps and the merged values last sent
+	globalConfig := map[string]string{}
+	podConfig := map[string]string{}
+	var currentConfig map[string]string
+
 	// Start the k8s configMap watcher
 	cmChanges, err := watchCMaps(ctx, namespace)
 	if err != nil {
@@ -235,6 +278,26 @@ func ListenK8s(ctx context.Context, namespace string, globalMap string, podMap s
 			}
 		case cm := <-cmChanges:
 			if *cm.Metadata.Namespace == namespace && (*cm.Metadata.Name == globalMap || *cm.Metadata.Name == podMap) {
+				if configC != nil {
+					if *cm.Metadata.Name == globalMap {
+						globalConfig = cm.Data
+					} else {
+						podConfig = cm.Data
+					}
+					if values := mergeK8sConfig(globalConfig, podConfig); currentConfig == nil || !sameK8sConfig(values, currentConfig) {
+						select {
+						case configC <- K8sConfigUpdate{Values: values}:
+							currentConfig = values
+						case <-time.After(2 * time.Second):
+							msg := kv.NewError("could not update configuration").With("namespace", namespace).With("config", *cm.Metadata.Name).With("stack", stack.Trace().TrimRuntime())
+							select {
+							case errC <- msg:
+							case <-time.After(2 * time.Second):
+								fmt.Println(msg)
+							}
+						}
+					}
+				}
 				if state, _ := cm.Data["STATE"]; len(state) != 0 {
 					newState, errGo := types.K8sStateString(state)
 					if errGo != nil {
@@ -0,0 +1,520 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of an executor that runs experiments as
+// Kubernetes pods rather than as child processes of the runner.  The runner continues
+// to download artifacts and generate the experiment script into its working directory,
+// which must be a persistent volume claim shared with the experiment pods, and once the
+// pod has completed the runner returns artifacts in the same manner as for local
+// experiments.  Only the directory of the experiment is mounted from the claim, at the
+// same path as it has within the runner, so that the pod cannot see the files of other
+// experiments or of the runners artifact cache.
+//
+// The vendored k8s client does not contain the batch API and so experiments are run
+// as bare pods with a restart policy of Never which gives the same run to completion
+// semantics as a Job with no retries.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/ericchiang/k8s"
+	core "github.com/ericchiang/k8s/apis/core/v1"
+	meta "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/ericchiang/k8s/apis/resource"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// K8sPodOpts contains the cluster configuration used when experiments are dispatched
+// as Kubernetes pods
+//
+type K8sPodOpts struct {
+	Namespace string        // The namespace into which experiment pods are placed
+	Image     string        // The container image used to run the experiment script
+	Claim     string        // The persistent volume claim holding the runners working directory
+	MountPath string        // The path at which the runner has the claim mounted
+	Secrets   []string      // Secrets mounted read-only into the experiment pods under /etc/secrets/
+	Interval  time.Duration // The interval at which the pod status is checked
+}
+
+// podAPI contains the Kubernetes operations used to manage experiment pods and allows
+// a fake cluster to be used for testing
+//
+type podAPI interface {
+	create(ctx context.Context, pod *core.Pod) (err error)
+	get(ctx context.Context, namespace string, name string) (pod *core.Pod, err error)
+	logs(ctx context.Context, namespace string, name string) (rdr io.ReadCloser, err error)
+	delete(ctx context.Context, pod *core.Pod) (err error)
+}
+
+// k8sPods implements the podAPI using the in cluster Kubernetes client
+//
+type k8sPods struct {
+	client *k8s.Client
+}
+
+func (k *k8sPods) create(ctx context.Context, pod *core.Pod) (err error) {
+	return k.client.Create(ctx, pod)
+}
+
+func (k *k8sPods) get(ctx context.Context, namespace string, name string) (pod *core.Pod, err error) {
+	pod = &core.Pod{}
+	if err = k.client.Get(ctx, namespace, name, pod); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// logs follows the output of the pods single container, the k8s client only decodes
+// API objects and so the plain text log subresource is retrieved directly
+//
+func (k *k8sPods) logs(ctx context.Context, namespace string, name string) (rdr io.ReadCloser, err error) {
+	logURL := fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/log?follow=true",
+		k.client.Endpoint, url.PathEscape(namespace), url.PathEscape(name))
+
+	req, err := http.NewRequest(http.MethodGet, logURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if k.client.SetHeaders != nil {
+		if err = k.client.SetHeaders(req.Header); err != nil {
+			return nil, err
+		}
+	}
+
+	client := k.client.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("log request failed with status %d, %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp.Body, nil
+}
+
+func (k *k8sPods) delete(ctx context.Context, pod *core.Pod) (err error) {
+	return k.client.Delete(ctx, pod, k8s.DeletePropagationBackground())
+}
+
+// K8sPod is an Executor that prepares experiments using a python virtual environment
+// script and then runs that script inside a dedicated Kubernetes pod
+//
+type K8sPod struct {
+	Request *Request
+	Opts    K8sPodOpts
+	venv    *VirtualEnv // Used to generate the script run within the pod
+	pods    podAPI
+	pod     *core.Pod // The pod created for the experiment, nil when no pod exists
+	dir     string    // The experiment directory
+	subPath string    // The experiment directory relative to the root of the claim
+	sync.Mutex
+}
+
+// NewK8sPod creates an executor that will run the experiment within a Kubernetes pod
+//
+func NewK8sPod(rqst *Request, dir string, opts K8sPodOpts) (p *K8sPod, err kv.Error) {
+
+	protect.Lock()
+	client := k8sClient
+	initErr := k8sInitErr
+	protect.Unlock()
+
+	if client == nil {
+		if initErr == nil {
+			initErr = kv.NewError("kubernetes client not initialized").With("stack", stack.Trace().TrimRuntime())
+		}
+		return nil, initErr
+	}
+
+	return newK8sPod(rqst, dir, opts, &k8sPods{client: client})
+}
+
+func newK8sPod(rqst *Request, dir string, opts K8sPodOpts, pods podAPI) (p *K8sPod, err kv.Error) {
+
+	if len(opts.Image) == 0 {
+		return nil, kv.NewError("no image specified for experiment pods").With("stack", stack.Trace().TrimRuntime())
+	}
+	if len(opts.Claim) == 0 {
+		return nil, kv.NewError("no persistent volume claim specified for experiment pods").With("stack", stack.Trace().TrimRuntime())
+	}
+
+	// The experiment directory has to be visible at the same path within the experiment
+	// pod, which means it must reside on the shared claim
+	mountPath := filepath.Clean(opts.MountPath)
+	rel, errGo := filepath.Rel(mountPath, filepath.Clean(dir))
+	if errGo != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return nil, kv.NewError("experiment directory is not within the shared volume").
+			With("dir", dir, "mount_path", mountPath).With("stack", stack.Trace().TrimRuntime())
+	}
+	opts.MountPath = mountPath
+
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Second
+	}
+
+	venv, err := NewVirtualEnv(rqst, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &K8sPod{
+		Request: rqst,
+		Opts:    opts,
+		venv:    venv,
+		pods:    pods,
+		dir:     filepath.Clean(dir),
+		subPath: filepath.ToSlash(rel),
+	}, nil
+}
+
+// Make generates the python virtual environment script that will be run inside the pod
+//
+func (p *K8sPod) Make(alloc *Allocated, e interface{}) (err kv.Error) {
+	return p.venv.Make(alloc, e)
+}
+
+// podName generates a name for the experiment pod that is valid as a DNS label
+//
+func (p *K8sPod) podName() (name string) {
+	key := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, p.Request.Experiment.Key)
+
+	if len(key) > 40 {
+		key = key[:40]
+	}
+	key = strings.Trim(key, "-")
+
+	return strings.Trim("studioml-"+key, "-") + "-" + strings.ToLower(RandomString(6))
+}
+
+// resources translates the experiment resource request into the pod resource requests and limits
+//
+func (p *K8sPod) resources() (rsc *core.ResourceRequirements, err kv.Error) {
+
+	rsc = &core.ResourceRequirements{
+		Limits:   map[string]*resource.Quantity{},
+		Requests: map[string]*resource.Quantity{},
+	}
+
+	quantity := func(value string) *resource.Quantity {
+		return &resource.Quantity{String_: k8s.String(value)}
+	}
+
+	if cpus := p.Request.Experiment.Resource.Cpus; cpus != 0 {
+		rsc.Requests["cpu"] = quantity(strconv.FormatUint(uint64(cpus), 10))
+		rsc.Limits["cpu"] = quantity(strconv.FormatUint(uint64(cpus), 10))
+	}
+
+	if ram := p.Request.Experiment.Resource.Ram; len(ram) != 0 {
+		mem, errGo := humanize.ParseBytes(ram)
+		if errGo != nil {
+			return nil, kv.Wrap(errGo).With("ram", ram).With("stack", stack.Trace().TrimRuntime())
+		}
+		if mem != 0 {
+			rsc.Requests["memory"] = quantity(strconv.FormatUint(mem, 10))
+			rsc.Limits["memory"] = quantity(strconv.FormatUint(mem, 10))
+		}
+	}
+
+	// Extended resources such as GPUs can only be specified as limits, Kubernetes
+	// sets the request to match
+	if gpus := p.Request.Experiment.Resource.Gpus; gpus != 0 {
+		rsc.Limits["nvidia.com/gpu"] = quantity(strconv.FormatUint(uint64(gpus), 10))
+	}
+
+	return rsc, nil
+}
+
+// podSpec generates the pod used to run the experiment script
+//
+func (p *K8sPod) podSpec(name string) (pod *core.Pod, err kv.Error) {
+
+	rsc, err := p.resources()
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := []*core.Volume{
+		{
+			Name: k8s.String("workdir"),
+			VolumeSource: &core.VolumeSource{
+				PersistentVolumeClaim: &core.PersistentVolumeClaimVolumeSource{
+					ClaimName: k8s.String(p.Opts.Claim),
+				},
+			},
+		},
+		{
+			Name: k8s.String("scratch"),
+			VolumeSource: &core.VolumeSource{
+				EmptyDir: &core.EmptyDirVolumeSource{},
+			},
+		},
+	}
+	mounts := []*core.VolumeMount{
+		{
+			Name:      k8s.String("workdir"),
+			MountPath: k8s.String(p.dir),
+			SubPath:   k8s.String(p.subPath),
+		},
+		{
+			Name:      k8s.String("scratch"),
+			MountPath: k8s.String("/scratch"),
+		},
+	}
+	for i, secret := range p.Opts.Secrets {
+		volName := "secret-" + strconv.Itoa(i)
+		volumes = append(volumes, &core.Volume{
+			Name: k8s.String(volName),
+			VolumeSource: &core.VolumeSource{
+				Secret: &core.SecretVolumeSource{
+					SecretName: k8s.String(secret),
+				},
+			},
+		})
+		mounts = append(mounts, &core.VolumeMount{
+			Name:      k8s.String(volName),
+			ReadOnly:  k8s.Bool(true),
+			MountPath: k8s.String(path.Join("/etc/secrets", secret)),
+		})
+	}
+
+	// The experiment environment is written into the script by the virtual environment
+	// Make, the pod environment carries only values needed before the script is started
+	env := []*core.EnvVar{
+		{Name: k8s.String("TMPDIR"), Value: k8s.String("/scratch")},
+		{Name: k8s.String("STUDIOML_EXPERIMENT"), Value: k8s.String(p.Request.Experiment.Key)},
+		{Name: k8s.String("STUDIOML_PROJECT"), Value: k8s.String(p.Request.Config.Database.ProjectId)},
+	}
+
+	pod = &core.Pod{
+		Metadata: &meta.ObjectMeta{
+			Name:      k8s.String(name),
+			Namespace: k8s.String(p.Opts.Namespace),
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "studioml-go-runner",
+				"studioml-experiment":          name,
+			},
+		},
+		Spec: &core.PodSpec{
+			RestartPolicy: k8s.String("Never"),
+			Volumes:       volumes,
+			Containers: []*core.Container{
+				{
+					Name:         k8s.String("experiment"),
+					Image:        k8s.String(p.Opts.Image),
+					Command:      []string{"/bin/bash", "-c", filepath.Clean(p.venv.Script)},
+					WorkingDir:   k8s.String(path.Dir(p.venv.Script)),
+					Env:          env,
+					Resources:    rsc,
+					VolumeMounts: mounts,
+				},
+			},
+		},
+	}
+	return pod, nil
+}
+
+// podFailure extracts the reason for a failed pod from its status
+//
+func podFailure(pod *core.Pod) (err kv.Error) {
+	err = kv.NewError("experiment pod failed").With("pod", pod.GetMetadata().GetName())
+	if reason := pod.GetStatus().GetReason(); len(reason) != 0 {
+		err = err.With("reason", reason)
+	}
+	for _, status := range pod.GetStatus().GetContainerStatuses() {
+		if term := status.GetState().GetTerminated(); term != nil {
+			err = err.With("exit_code", term.GetExitCode(), "reason", term.GetReason())
+		}
+	}
+	return err.With("stack", stack.Trace().TrimRuntime())
+}
+
+// podStuck detects pods that will never start due to problems with the pod specification
+// or the image, these otherwise remain pending until the experiment times out
+//
+func podStuck(pod *core.Pod) (err kv.Error) {
+	for _, status := range pod.GetStatus().GetContainerStatuses() {
+		waiting := status.GetState().GetWaiting()
+		if waiting == nil {
+			continue
+		}
+		switch waiting.GetReason() {
+		case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerConfigError", "CreateContainerError":
+			return kv.NewError("experiment pod unable to start").
+				With("pod", pod.GetMetadata().GetName(), "reason", waiting.GetReason(), "message", waiting.GetMessage()).
+				With("stack", stack.Trace().TrimRuntime())
+		}
+	}
+	return nil
+}
+
+// streamLogs copies the output of the experiment pod into the experiment output file
+//
+func (p *K8sPod) streamLogs(ctx context.Context, name string, f io.Writer) (err kv.Error) {
+	rdr, errGo := p.pods.logs(ctx, p.Opts.Namespace, name)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("pod", name).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer rdr.Close()
+
+	if _, errGo = io.Copy(f, rdr); errGo != nil && ctx.Err() == nil {
+		return kv.Wrap(errGo).With("pod", name).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// Run creates the experiment pod, streams its output into the experiment output file and
+// blocks until the pod has completed or the ctx is Done
+//
+func (p *K8sPod) Run(ctx context.Context, refresh map[string]Artifact) (err kv.Error) {
+
+	name := p.podName()
+	pod, err := p.podSpec(name)
+	if err != nil {
+		return err
+	}
+
+	outputFN := filepath.Join(path.Dir(p.venv.Script), "..", "output", "output")
+	f, errGo := os.Create(outputFN)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer f.Close()
+
+	if errGo = p.pods.create(ctx, pod); errGo != nil {
+		return kv.Wrap(errGo).With("pod", name, "namespace", p.Opts.Namespace).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	p.Lock()
+	p.pod = pod
+	p.Unlock()
+
+	// Pods are removed regardless of how the experiment ended, the context used for
+	// the experiment may have already expired
+	defer p.remove()
+
+	logCtx, logCancel := context.WithCancel(ctx)
+	defer logCancel()
+
+	logC := make(chan kv.Error, 1)
+	streaming := false
+
+	// Transient failures retrieving the pod status are tolerated up to a limit
+	failures := 0
+
+	check := time.NewTicker(p.Opts.Interval)
+	defer check.Stop()
+
+	for {
+		status, errGo := p.pods.get(ctx, p.Opts.Namespace, name)
+		if errGo != nil {
+			if ctx.Err() != nil {
+				return kv.Wrap(ctx.Err()).With("pod", name).With("stack", stack.Trace().TrimRuntime())
+			}
+			if failures++; failures > 5 {
+				return kv.Wrap(errGo, "experiment pod status unavailable").With("pod", name).With("stack", stack.Trace().TrimRuntime())
+			}
+		} else {
+			failures = 0
+			phase := status.GetStatus().GetPhase()
+
+			if !streaming && phase != "Pending" && len(phase) != 0 {
+				streaming = true
+				go func() {
+					logC <- p.streamLogs(logCtx, name, f)
+				}()
+			}
+
+			switch phase {
+			case "Succeeded", "Failed":
+				// Allow the log to drain once the pod is done
+				select {
+				case err = <-logC:
+				case <-ctx.Done():
+				}
+				if phase == "Failed" {
+					return podFailure(status)
+				}
+				return err
+			case "Pending":
+				if err = podStuck(status); err != nil {
+					return err
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return kv.Wrap(ctx.Err()).With("pod", name).With("stack", stack.Trace().TrimRuntime())
+		case <-check.C:
+		}
+	}
+}
+
+// remove deletes the experiment pod if one was created
+//
+func (p *K8sPod) remove() (err kv.Error) {
+	p.Lock()
+	pod := p.pod
+	p.pod = nil
+	p.Unlock()
+
+	if pod == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if errGo := p.pods.delete(ctx, pod); errGo != nil {
+		return kv.Wrap(errGo).With("pod", pod.GetMetadata().GetName()).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// WorkDirs returns directories outside of the experiment directory used by the experiment, being
+// none as the experiment runs within a separate pod
+//
+func (*K8sPod) WorkDirs() (dirs []string) {
+	return []string{}
+}
+
+// Pid returns 0 as the experiment is not a process of the runner
+//
+func (*K8sPod) Pid() (pid int) {
+	return 0
+}
+
+// Close removes any experiment pod that remains
+//
+func (p *K8sPod) Close() (err kv.Error) {
+	return p.remove()
+}
@@ -0,0 +1,170 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	core "github.com/ericchiang/k8s/apis/core/v1"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// fakePods is a podAPI that steps a single pod through a series of phases, one per
+// status request
+//
+type fakePods struct {
+	phases  []string
+	exit    int32
+	output  string
+	created *core.Pod
+	deleted bool
+	sync.Mutex
+}
+
+func (f *fakePods) create(ctx context.Context, pod *core.Pod) (err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.created = pod
+	return nil
+}
+
+func (f *fakePods) get(ctx context.Context, namespace string, name string) (pod *core.Pod, err error) {
+	f.Lock()
+	defer f.Unlock()
+
+	phase := f.phases[0]
+	if len(f.phases) > 1 {
+		f.phases = f.phases[1:]
+	}
+
+	status := &core.ContainerStatus{Name: k8s.String("experiment"), State: &core.ContainerState{}}
+	if phase == "Failed" || phase == "Succeeded" {
+		status.State.Terminated = &core.ContainerStateTerminated{ExitCode: &f.exit}
+	}
+	return &core.Pod{
+		Metadata: f.created.Metadata,
+		Status: &core.PodStatus{
+			Phase:             k8s.String(phase),
+			ContainerStatuses: []*core.ContainerStatus{status},
+		},
+	}, nil
+}
+
+func (f *fakePods) logs(ctx context.Context, namespace string, name string) (rdr io.ReadCloser, err error) {
+	return ioutil.NopCloser(strings.NewReader(f.output)), nil
+}
+
+func (f *fakePods) delete(ctx context.Context, pod *core.Pod) (err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.deleted = true
+	return nil
+}
+
+// TestK8sPod runs experiments against a fake cluster checking the pod that is generated
+// for the experiment, that its output is captured, and that failures are reported
+//
+func TestK8sPod(t *testing.T) {
+
+	mount, errGo := ioutil.TempDir("", "k8s-pod")
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	defer os.RemoveAll(mount)
+
+	dir := filepath.Join(mount, "experiments", "test.0")
+	if errGo = os.MkdirAll(filepath.Join(dir, "output"), 0700); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	rqst := &Request{}
+	rqst.Experiment.Key = "Test_Experiment"
+	rqst.Experiment.Resource = Resource{Cpus: 2, Gpus: 1, Ram: "2gb"}
+
+	opts := K8sPodOpts{
+		Namespace: "studioml",
+		Image:     "studioml/experiment",
+		Claim:     "runner-workdir",
+		MountPath: mount,
+		Secrets:   []string{"aws-credentials"},
+		Interval:  10 * time.Millisecond,
+	}
+
+	if _, err := newK8sPod(rqst, dir, K8sPodOpts{Image: opts.Image, Claim: opts.Claim, MountPath: "/elsewhere"}, &fakePods{}); err == nil {
+		t.Fatal(kv.NewError("experiment directory outside of the claim accepted").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if _, err := newK8sPod(rqst, mount, opts, &fakePods{}); err == nil {
+		t.Fatal(kv.NewError("root of the claim accepted as the experiment directory").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	pods := &fakePods{phases: []string{"Pending", "Running", "Running", "Succeeded"}, output: "experiment output\n"}
+	exec, err := newK8sPod(rqst, dir, opts, pods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = exec.Run(context.Background(), map[string]Artifact{}); err != nil {
+		t.Fatal(err)
+	}
+
+	pods.Lock()
+	pod, deleted := pods.created, pods.deleted
+	pods.Unlock()
+
+	if !deleted {
+		t.Fatal(kv.NewError("experiment pod not deleted").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if name := pod.GetMetadata().GetName(); !strings.HasPrefix(name, "studioml-test-experiment-") {
+		t.Fatal(kv.NewError("unexpected pod name").With("name", name).With("stack", stack.Trace().TrimRuntime()))
+	}
+	container := pod.GetSpec().GetContainers()[0]
+	if gpus := container.GetResources().GetLimits()["nvidia.com/gpu"].GetString_(); gpus != "1" {
+		t.Fatal(kv.NewError("unexpected gpu limit").With("gpus", gpus).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if cpus := container.GetResources().GetRequests()["cpu"].GetString_(); cpus != "2" {
+		t.Fatal(kv.NewError("unexpected cpu request").With("cpus", cpus).With("stack", stack.Trace().TrimRuntime()))
+	}
+	mounts := map[string]string{}
+	subPaths := map[string]string{}
+	for _, mnt := range container.GetVolumeMounts() {
+		mounts[mnt.GetName()] = mnt.GetMountPath()
+		subPaths[mnt.GetName()] = mnt.GetSubPath()
+	}
+	if mounts["workdir"] != dir || mounts["secret-0"] != "/etc/secrets/aws-credentials" {
+		t.Fatal(kv.NewError("unexpected volume mounts").With("mounts", mounts).With("stack", stack.Trace().TrimRuntime()))
+	}
+	// Only the experiment directory is to be visible within the pod
+	if subPaths["workdir"] != "experiments/test.0" {
+		t.Fatal(kv.NewError("unexpected volume sub path").With("sub_paths", subPaths).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	output, errGo := ioutil.ReadFile(filepath.Join(dir, "output", "output"))
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if string(output) != pods.output {
+		t.Fatal(kv.NewError("experiment output not captured").With("output", string(output)).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// An experiment that exits with an error should fail and still have its pod removed
+	pods = &fakePods{phases: []string{"Running", "Failed"}, exit: 2}
+	if exec, err = newK8sPod(rqst, dir, opts, pods); err != nil {
+		t.Fatal(err)
+	}
+	if err = exec.Run(context.Background(), map[string]Artifact{}); err == nil {
+		t.Fatal(kv.NewError("failed experiment pod not reported").With("stack", stack.Trace().TrimRuntime()))
+	}
+	if !pods.deleted {
+		t.Fatal(kv.NewError("failed experiment pod not deleted").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
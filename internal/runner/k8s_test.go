@@ -58,7 +58,7 @@ func TestK8sConfigUnit(t *testing.T) {
 
 	go func() {
 		// Register a listener for the newly created map
-		if err := ListenK8s(ctx, client.Namespace, name, "", updateC, errC); err != nil {
+		if err := ListenK8s(ctx, client.Namespace, name, "", updateC, nil, errC); err != nil {
 			errC <- err
 		}
 	}()
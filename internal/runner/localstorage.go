@@ -6,13 +6,9 @@ package runner
 // be used by the runner to retrieve storage from local storage
 
 import (
-	"archive/tar"
 	"bufio"
-	"compress/bzip2"
-	"compress/gzip"
 	"context"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -82,65 +78,27 @@ func (s *localStorage) Fetch(ctx context.Context, name string, unpack bool, outp
 	}
 	defer obj.Close()
 
-	return fetcher(obj, name, output, fileType, unpack)
+	return fetcher(ctx, obj, name, output, fileType, unpack)
 }
 
-func addReader(obj *os.File, fileType string) (inReader io.ReadCloser, err kv.Error) {
-	switch fileType {
-	case "application/x-gzip", "application/zip":
-		reader, errGo := gzip.NewReader(obj)
-		if errGo != nil {
-			return reader, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
-		}
-		inReader = reader
-	case "application/bzip2", "application/octet-stream":
-		inReader = ioutil.NopCloser(bzip2.NewReader(obj))
-	default:
-		inReader = ioutil.NopCloser(obj)
-	}
-	return inReader, err
-}
-
-func fetcher(obj *os.File, name string, output string, fileType string, unpack bool) (warns []kv.Error, err kv.Error) {
+func fetcher(ctx context.Context, obj *os.File, name string, output string, fileType string, unpack bool) (warns []kv.Error, err kv.Error) {
 	// If the unpack flag is set then use a tar decompressor and unpacker
 	// but first make sure the output location is an existing directory
 	if unpack {
 
-		inReader, err := addReader(obj, fileType)
+		// Zip archives are not streamable and are handled as a whole
+		if fileType == "application/zip" {
+			return warns, Unzip(ctx, obj, output)
+		}
+
+		inReader, err := NewDecompressor(fileType, obj)
 		if err != nil {
 			return warns, err
 		}
 		defer inReader.Close()
 
-		tarReader := tar.NewReader(inReader)
-
-		for {
-			header, errGo := tarReader.Next()
-			if errGo == io.EOF {
-				break
-			} else if errGo != nil {
-				return warns, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
-			}
-
-			path := filepath.Join(output, header.Name)
-			info := header.FileInfo()
-			if info.IsDir() {
-				if errGo = os.MkdirAll(path, info.Mode()); errGo != nil {
-					return warns, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
-				}
-				continue
-			}
-
-			file, errGo := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
-			if errGo != nil {
-				return warns, kv.Wrap(errGo).With("file", path).With("stack", stack.Trace().TrimRuntime())
-			}
-
-			_, errGo = io.Copy(file, tarReader)
-			file.Close()
-			if errGo != nil {
-				return warns, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
-			}
+		if err = Untar(ctx, inReader, output); err != nil {
+			return warns, err
 		}
 	} else {
 		fn := filepath.Join(output, filepath.Base(name))
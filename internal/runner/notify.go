@@ -0,0 +1,296 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of a notifier that sends messages about the progress
+// of experiments, and alerts about the runner, to Slack incoming webhooks or to generic JSON
+// webhooks.  Messages are queued and sent in the background by a worker for each destination,
+// so that a destination that is slow or unavailable only delays its own messages, being rate
+// limited for each destination and retried when the destination is temporarily unavailable.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// Notification describes an event that is sent to a webhook, when sent to generic webhooks
+// it is the JSON body of the request
+//
+type Notification struct {
+	Event      string    `json:"event"` // started, completed, failed, or alert
+	Host       string    `json:"host"`
+	Project    string    `json:"project_id,omitempty"`
+	Experiment string    `json:"experiment_id,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	Outcome    string    `json:"outcome,omitempty"` // The class of failure for failed experiments
+	Output     string    `json:"output,omitempty"`  // The tail of the experiment output for failures
+	Time       time.Time `json:"time"`
+}
+
+// Destination is the webhook a notification is sent to, when the webhook is a Slack incoming
+// webhook an optional channel overrides the default channel of the webhook
+//
+type Destination struct {
+	URL     string
+	Channel string
+}
+
+type pendingNotification struct {
+	dest Destination
+	note Notification
+}
+
+// Notifier queues notifications and sends them to their destinations
+//
+type Notifier struct {
+	retries int                                 // The number of attempts made to resend a failed notification
+	backoff time.Duration                       // The initial wait before a notification is resent, doubled for each attempt
+	idle    time.Duration                       // The time the worker for a destination waits for notifications before stopping
+	client  *http.Client                        // The client used to send notifications
+	queue   chan pendingNotification            // Notifications waiting to be passed to the worker for their destination
+	dests   map[string]chan pendingNotification // Notifications waiting to be sent by the worker for each destination
+	sync.Mutex
+}
+
+// NewNotifier creates a notifier that resends notifications that could not be delivered up
+// to retries times
+//
+func NewNotifier(retries int) (n *Notifier) {
+	return &Notifier{
+		retries: retries,
+		backoff: 2 * time.Second,
+		idle:    time.Minute,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		queue:   make(chan pendingNotification, 128),
+		dests:   map[string]chan pendingNotification{},
+	}
+}
+
+// Send queues a notification for the destination without blocking, an error is returned if
+// the notification had to be dropped
+//
+func (n *Notifier) Send(dest Destination, note Notification) (err kv.Error) {
+	if note.Time.IsZero() {
+		note.Time = time.Now()
+	}
+	select {
+	case n.queue <- pendingNotification{dest: dest, note: note}:
+		return nil
+	default:
+		return kv.NewError("notification dropped, queue full").With("event", note.Event).
+			With("project_id", note.Project).With("experiment_id", note.Experiment).With("stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// Run sends queued notifications until the ctx is Done.  Each destination is sent its
+// notifications by a worker of its own, and is sent at most one notification within each
+// interval.  Notifications that could not be sent are reported using the errorC channel.
+//
+func (n *Notifier) Run(ctx context.Context, interval time.Duration, errorC chan<- kv.Error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case pending := <-n.queue:
+			if err := n.dispatch(ctx, pending, interval, errorC); err != nil {
+				select {
+				case errorC <- err:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// dispatch passes a notification to the worker for its destination, starting the worker if
+// needed, an error is returned if the notification had to be dropped
+//
+func (n *Notifier) dispatch(ctx context.Context, pending pendingNotification, interval time.Duration, errorC chan<- kv.Error) (err kv.Error) {
+	n.Lock()
+	defer n.Unlock()
+
+	queue, isPresent := n.dests[pending.dest.URL]
+	if !isPresent {
+		queue = make(chan pendingNotification, 32)
+		n.dests[pending.dest.URL] = queue
+		go n.send(ctx, pending.dest.URL, queue, interval, errorC)
+	}
+
+	select {
+	case queue <- pending:
+		return nil
+	default:
+		return kv.NewError("notification dropped, destination queue full").With("event", pending.note.Event).
+			With("project_id", pending.note.Project).With("experiment_id", pending.note.Experiment).With("stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// send is the worker that delivers the notifications queued for a single destination, it
+// stops once the destination has had nothing to send for the idle duration of the notifier
+//
+func (n *Notifier) send(ctx context.Context, hook string, queue chan pendingNotification, interval time.Duration, errorC chan<- kv.Error) {
+	next := time.Time{}
+
+	idle := time.NewTimer(n.idle)
+	defer idle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-idle.C:
+			// Notifications are only queued while holding the lock so none can be lost
+			n.Lock()
+			if len(queue) == 0 {
+				delete(n.dests, hook)
+				n.Unlock()
+				return
+			}
+			n.Unlock()
+			idle.Reset(n.idle)
+		case pending := <-queue:
+			if wait := time.Until(next); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return
+				}
+			}
+			err := n.deliver(ctx, pending.dest, pending.note)
+			next = time.Now().Add(interval)
+
+			if err != nil {
+				select {
+				case errorC <- err:
+				default:
+				}
+			}
+
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(n.idle)
+		}
+	}
+}
+
+// isSlack checks if the destination is a Slack incoming webhook
+//
+func (dest *Destination) isSlack() bool {
+	u, errGo := url.Parse(dest.URL)
+	if errGo != nil {
+		return false
+	}
+	return len(dest.Channel) != 0 || u.Hostname() == "slack.com" || strings.HasSuffix(u.Hostname(), ".slack.com")
+}
+
+// slackText formats a notification as the text of a Slack message
+//
+func slackText(note Notification) (text string) {
+	text = fmt.Sprintf("runner %s: %s", note.Host, note.Event)
+	if len(note.Experiment) != 0 {
+		text = fmt.Sprintf("experiment %s %s on %s", note.Experiment, note.Event, note.Host)
+		if len(note.Project) != 0 {
+			text = fmt.Sprintf("experiment %s of project %s %s on %s", note.Experiment, note.Project, note.Event, note.Host)
+		}
+	}
+	if len(note.Outcome) != 0 {
+		text += " (" + note.Outcome + ")"
+	}
+	if len(note.Message) != 0 {
+		text += "\n" + note.Message
+	}
+	if len(note.Output) != 0 {
+		text += "\n```\n" + strings.Replace(note.Output, "```", "'''", -1) + "\n```"
+	}
+	return text
+}
+
+// deliver sends a notification, retrying failures that could be temporary
+//
+func (n *Notifier) deliver(ctx context.Context, dest Destination, note Notification) (err kv.Error) {
+	var body interface{} = note
+	if dest.isSlack() {
+		msg := map[string]string{"text": slackText(note)}
+		if len(dest.Channel) != 0 {
+			msg["channel"] = dest.Channel
+		}
+		body = msg
+	}
+
+	buf, errGo := json.Marshal(body)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	backoff := n.backoff
+	for attempt := 0; ; attempt++ {
+		retryAfter := time.Duration(0)
+		retry := false
+		if retryAfter, retry, err = n.post(ctx, dest.URL, buf); err == nil {
+			return nil
+		}
+		if !retry || attempt >= n.retries {
+			return err.With("event", note.Event).With("attempts", attempt+1)
+		}
+
+		// Destinations that are rate limiting the runner can indicate when to try again
+		wait := backoff
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err.With("event", note.Event)
+		}
+		backoff *= 2
+	}
+}
+
+// post sends a single request to a webhook and indicates if a failure is worth retrying along
+// with any time the destination asked the runner to wait before doing so
+//
+func (n *Notifier) post(ctx context.Context, hook string, body []byte) (retryAfter time.Duration, retry bool, err kv.Error) {
+	req, errGo := http.NewRequest(http.MethodPost, hook, bytes.NewReader(body))
+	if errGo != nil {
+		return 0, false, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, errGo := n.client.Do(req.WithContext(ctx))
+	if errGo != nil {
+		// The URL is not included as webhook URLs contain the credentials for the webhook
+		if urlErr, isURLErr := errGo.(*url.Error); isURLErr {
+			errGo = urlErr.Err
+		}
+		return 0, true, kv.NewError("notification not sent").With("error", errGo.Error()).With("stack", stack.Trace().TrimRuntime())
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return 0, false, nil
+	}
+
+	// Limit any requested wait so that a destination does not hold up its later notifications indefinitely
+	if secs, errGo := strconv.Atoi(resp.Header.Get("Retry-After")); errGo == nil && secs > 0 {
+		if secs > 60 {
+			secs = 60
+		}
+		retryAfter = time.Duration(secs) * time.Second
+	}
+
+	err = kv.NewError("notification rejected").With("status", resp.Status).With("stack", stack.Trace().TrimRuntime())
+	return retryAfter, resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
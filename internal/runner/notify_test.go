@@ -0,0 +1,162 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// TestNotifier checks that notifications are retried when the destination is unavailable,
+// are formatted for Slack when a channel is used, and are rate limited for each destination
+//
+func TestNotifier(t *testing.T) {
+	type received struct {
+		body map[string]interface{}
+		at   time.Time
+	}
+	bodies := []received{}
+	failures := 1
+	lock := sync.Mutex{}
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body := map[string]interface{}{}
+		if errGo := json.NewDecoder(r.Body).Decode(&body); errGo != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		bodies = append(bodies, received{body: body, at: time.Now()})
+	}))
+	defer hook.Close()
+
+	n := NewNotifier(2)
+	n.backoff = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	interval := 200 * time.Millisecond
+	errorC := make(chan kv.Error, 1)
+	go n.Run(ctx, interval, errorC)
+
+	note := Notification{
+		Event:      "failed",
+		Host:       "host",
+		Project:    "project",
+		Experiment: "experiment",
+		Output:     "Traceback",
+	}
+	if err := n.Send(Destination{URL: hook.URL}, note); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Send(Destination{URL: hook.URL, Channel: "#experiments"}, note); err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		lock.Lock()
+		done := len(bodies) == 2
+		lock.Unlock()
+		if done {
+			break
+		}
+		select {
+		case err := <-errorC:
+			t.Fatal(err)
+		case <-ctx.Done():
+			t.Fatal(kv.NewError("notifications not received").With("stack", stack.Trace().TrimRuntime()))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if bodies[0].body["experiment_id"] != "experiment" || bodies[0].body["output"] != "Traceback" {
+		t.Fatal(kv.NewError("webhook notification incorrect").With("body", bodies[0].body).With("stack", stack.Trace().TrimRuntime()))
+	}
+	text, _ := bodies[1].body["text"].(string)
+	if bodies[1].body["channel"] != "#experiments" || !strings.Contains(text, "experiment experiment of project project failed") {
+		t.Fatal(kv.NewError("slack notification incorrect").With("body", bodies[1].body).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if gap := bodies[1].at.Sub(bodies[0].at); gap < interval {
+		t.Fatal(kv.NewError("notifications not rate limited").With("gap", gap.String()).With("stack", stack.Trace().TrimRuntime()))
+	}
+}
+
+// TestNotifierIsolation checks that a destination that does not respond does not hold up
+// notifications to other destinations, and that idle destination workers stop
+//
+func TestNotifierIsolation(t *testing.T) {
+	blocked := make(chan struct{})
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-blocked
+	}))
+	defer dead.Close()
+	defer close(blocked)
+
+	received := make(chan struct{}, 1)
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+	}))
+	defer live.Close()
+
+	n := NewNotifier(0)
+	n.idle = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go n.Run(ctx, time.Millisecond, make(chan kv.Error, 1))
+
+	note := Notification{Event: "alert", Host: "host"}
+	for i := 0; i != 3; i++ {
+		if err := n.Send(Destination{URL: dead.URL}, note); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Send(Destination{URL: live.URL}, note); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal(kv.NewError("notification held up by an unresponsive destination").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// The worker for the live destination stops once it has been idle
+	for {
+		n.Lock()
+		_, isPresent := n.dests[live.URL]
+		n.Unlock()
+		if !isPresent {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal(kv.NewError("idle destination worker did not stop").With("stack", stack.Trace().TrimRuntime()))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
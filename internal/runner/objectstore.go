@@ -8,7 +8,10 @@ package runner
 import (
 	"bufio"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -51,8 +54,10 @@ func init() {
 }
 
 type objStore struct {
-	store  Storage
-	ErrorC chan kv.Error
+	store     Storage
+	bucket    string
+	qualified string
+	ErrorC    chan kv.Error
 }
 
 // NewObjStore is used to instantiate an object store for the running that includes a cache
@@ -64,8 +69,10 @@ func NewObjStore(ctx context.Context, spec *StoreOpts, errorC chan kv.Error) (os
 	}
 
 	return &objStore{
-		store:  store,
-		ErrorC: errorC,
+		store:     store,
+		bucket:    spec.Art.Bucket,
+		qualified: spec.Art.Qualified,
+		ErrorC:    errorC,
 	}, nil
 }
 
@@ -75,11 +82,45 @@ var (
 	cacheMax      int64
 	cacheInit     sync.Once
 	cacheInitSync sync.Mutex
-	cache         *ccache.Cache
+
+	// cache is replaced when the cache size is changed, cacheLock is held while it is in use
+	cache     *ccache.Cache
+	cacheLock sync.RWMutex
 )
 
-func groom(backingDir string, removedC chan os.FileInfo, errorC chan kv.Error) {
+// withCache calls fn with the in memory cache, if it has been initialized, holding the lock that
+// prevents the cache being replaced while fn runs
+//
+func withCache(fn func(c *ccache.Cache)) (ok bool) {
+	cacheLock.RLock()
+	defer cacheLock.RUnlock()
+
 	if cache == nil {
+		return false
+	}
+	fn(cache)
+	return true
+}
+
+// cacheReady is used to test if the in memory cache has been initialized
+//
+func cacheReady() bool {
+	return withCache(func(c *ccache.Cache) {})
+}
+
+// swapCache replaces the in memory cache and returns the previous one
+//
+func swapCache(c *ccache.Cache) (old *ccache.Cache) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	old = cache
+	cache = c
+	return old
+}
+
+func groom(backingDir string, removedC chan os.FileInfo, errorC chan kv.Error) {
+	if !cacheReady() {
 		return
 	}
 	cachedFiles, err := ioutil.ReadDir(backingDir)
@@ -100,8 +141,18 @@ func groom(backingDir string, removedC chan os.FileInfo, errorC chan kv.Error) {
 
 	for _, file := range cachedFiles {
 		// Is an expired or missing file in cache data structure, if it is not a directory delete it
-		item := cache.Sample(file.Name())
+		var item *ccache.Item
+		withCache(func(c *ccache.Cache) {
+			item = c.Sample(file.Name())
+		})
 		if item == nil || item.Expired() {
+			// Pinned files are retained regardless of their age
+			if isPinned(file.Name()) {
+				if item != nil {
+					item.Extend(48 * time.Hour)
+				}
+				continue
+			}
 			info, err := os.Stat(filepath.Join(backingDir, file.Name()))
 			if err == nil {
 				if info.IsDir() {
@@ -117,6 +168,14 @@ func groom(backingDir string, removedC chan os.FileInfo, errorC chan kv.Error) {
 					case <-time.After(time.Second):
 						fmt.Printf("%s\n", kv.Wrap(err, fmt.Sprintf("cache dir %s remove failed", backingDir)).With("stack", stack.Trace().TrimRuntime()))
 					}
+					continue
+				}
+				removeTree(file.Name())
+				if err := index.remove(file.Name()); err != nil {
+					select {
+					case errorC <- err:
+					case <-time.After(time.Second):
+					}
 				}
 			}
 		}
@@ -139,6 +198,7 @@ func groomDir(ctx context.Context, backingDir string, removedC chan os.FileInfo,
 				groom(backingDir, removedC, errorC)
 
 			case <-ctx.Done():
+				index.Close()
 				return
 			}
 		}
@@ -170,6 +230,12 @@ func ClearObjStore() (err kv.Error) {
 			if err = os.Remove(filepath.Join(backingDir, file.Name())); err != nil {
 				return kv.Wrap(err, fmt.Sprintf("cache dir %s remove failed", backingDir)).With("stack", stack.Trace().TrimRuntime())
 			}
+			removeTree(file.Name())
+			if index != nil {
+				if err := index.remove(file.Name()); err != nil {
+					return err
+				}
+			}
 		}
 	}
 	return nil
@@ -182,6 +248,39 @@ func ObjStoreFootPrint() (max int64) {
 	return cacheMax
 }
 
+// SetObjStoreMax changes the target size of an initialized object store cache.  The cache
+// is rebuilt from the index so items will be groomed from the backing store if the
+// new size is smaller than the items currently cached.
+//
+func SetObjStoreMax(size int64) (err kv.Error) {
+	cacheInitSync.Lock()
+	defer cacheInitSync.Unlock()
+
+	if len(backingDir) == 0 || index == nil {
+		return kv.NewError("cache is not initialized").With("stack", stack.Trace().TrimRuntime())
+	}
+	if size == cacheMax {
+		return nil
+	}
+
+	cachedFiles, errGo := ioutil.ReadDir(backingDir)
+	if errGo != nil {
+		return kv.Wrap(errGo, "cache directory not readable").With("backing", backingDir).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	resized := ccache.New(ccache.Configure().MaxSize(size).GetsPerPromote(1).ItemsToPrune(1).Track())
+	if err = loadCacheIndex(resized, index, backingDir, cachedFiles, 48*time.Hour); err != nil {
+		resized.Stop()
+		return err
+	}
+	// Callers only use the cache while holding the lock so once it has been replaced the
+	// previous cache is no longer in use and can be stopped
+	swapCache(resized).Stop()
+	cacheMax = size
+
+	return nil
+}
+
 // InitObjStore sets up the backing store for our object store cache.  The size specified
 // can be any byte amount.
 //
@@ -229,7 +328,7 @@ func InitObjStore(ctx context.Context, backing string, size int64, removedC chan
 	cacheInitSync.Lock()
 	defer cacheInitSync.Unlock()
 
-	if cache != nil {
+	if cacheReady() {
 		return nil, kv.Wrap(err, "cache is already initialized").With("stack", stack.Trace().TrimRuntime())
 	}
 
@@ -253,36 +352,37 @@ func InitObjStore(ctx context.Context, backing string, size int64, removedC chan
 	default:
 	}
 
-	// Store the backing store directory for the cache
-	backingDir = backing
-	cacheMax = size
-
-	// The backing store might have partial downloads inside it.  We should clear those, ignoring kv.
-	// and then re-create the partial download directory
-	partialDir := filepath.Join(backingDir, ".partial")
-	os.RemoveAll(partialDir)
-
-	if errGo = os.MkdirAll(partialDir, 0700); err != nil {
+	// The backing store might have partial downloads inside it, these are retained when the
+	// index knows of them so that they can be resumed
+	partialDir := filepath.Join(backing, ".partial")
+	if errGo = os.MkdirAll(partialDir, 0700); errGo != nil {
 		return nil, kv.Wrap(errGo, "unable to create the partial downloads dir ", partialDir).With("stack", stack.Trace().TrimRuntime())
 	}
 
+	idx, err := openCacheIndex(backing)
+	if err != nil {
+		return nil, err
+	}
+
 	// Size the cache appropriately, and track items that are in use through to their being released,
 	// which prevents items being read from being groomed and then new copies of the same
-	// data appearing
-	cache = ccache.New(ccache.Configure().MaxSize(size).GetsPerPromote(1).ItemsToPrune(1))
-
-	// Now populate the lookaside cache with the files found in the cache directory and their sizes
-	for i, file := range cachedFiles {
-		if file.IsDir() {
-			continue
-		}
-		if file.Name()[0] != '.' {
-			cache.Fetch(file.Name(), time.Hour*48,
-				func() (interface{}, error) {
-					return cachedFiles[i], nil
-				})
-		}
+	// data appearing.  Tracking is also used to hold pinned items in the cache.
+	created := ccache.New(ccache.Configure().MaxSize(size).GetsPerPromote(1).ItemsToPrune(1).Track())
+
+	// Now populate the lookaside cache with the files found in the cache directory using the
+	// access history from the index
+	if err = loadCacheIndex(created, idx, backing, cachedFiles, 48*time.Hour); err != nil {
+		idx.Close()
+		created.Stop()
+		return nil, err
 	}
+	index = idx
+	swapCache(created)
+
+	// Store the backing store directory for the cache only once the cache is usable as
+	// fetches use its presence to decide whether the cache is enabled
+	backingDir = backing
+	cacheMax = size
 
 	// Now start the directory groomer
 	cacheInit.Do(func() {
@@ -294,8 +394,12 @@ func InitObjStore(ctx context.Context, backing string, size int64, removedC chan
 
 // CacheProbe can be used to test the validity of the cache for a previously cached item.
 //
-func CacheProbe(key string) bool {
-	return cache.Get(key) != nil && !cache.Get(key).Expired()
+func CacheProbe(key string) (valid bool) {
+	withCache(func(c *ccache.Cache) {
+		item := c.Get(key)
+		valid = item != nil && !item.Expired()
+	})
+	return valid
 }
 
 // Hash will return the hash of a stored file or other blob.  This method can be used
@@ -333,11 +437,11 @@ func (s *objStore) Fetch(ctx context.Context, name string, unpack bool, output s
 
 	// triggers LRU to elevate the item being retrieved
 	if len(hash) != 0 {
-		if item := cache.Get(hash); item != nil {
-			if !item.Expired() {
+		withCache(func(c *ccache.Cache) {
+			if item := c.Get(hash); item != nil && !item.Expired() {
 				item.Extend(48 * time.Hour)
 			}
-		}
+		})
 	}
 
 	startTime := time.Now()
@@ -374,6 +478,12 @@ func (s *objStore) Fetch(ctx context.Context, name string, unpack bool, output s
 			w, err := localFS.Fetch(ctx, localName, unpack, output, nil)
 			if err == nil {
 				cacheHits.With(prometheus.Labels{"host": host, "hash": hash}).Inc()
+				if err = index.touch(hash); err != nil {
+					select {
+					case s.ErrorC <- err:
+					default:
+					}
+				}
 				return warns, nil
 			}
 
@@ -400,6 +510,14 @@ func (s *objStore) Fetch(ctx context.Context, name string, unpack bool, output s
 		//
 		partial := filepath.Join(backingDir, ".partial", hash)
 		if _, errGo := os.Stat(partial); errGo == nil {
+			// Partial downloads left behind by a previous instance of the runner have no
+			// downloader and so the first waiter to find one completes it
+			if claimResumable(hash) {
+				if err := s.resume(ctx, name, hash, partial, localName); err != nil {
+					warns = append(warns, err)
+				}
+				continue
+			}
 			select {
 			case <-ctx.Done():
 				return warns, err
@@ -433,6 +551,40 @@ func (s *objStore) Fetch(ctx context.Context, name string, unpack bool, output s
 		}
 		downloader = true
 
+		// Record the download in the index so that it can be resumed should the runner
+		// be restarted before it completes
+		now := time.Now()
+		entry := &CacheEntry{
+			Hash:       hash,
+			Key:        name,
+			Bucket:     s.bucket,
+			Qualified:  s.qualified,
+			Created:    now,
+			LastAccess: now,
+			Partial:    true,
+		}
+		if err := index.put(entry); err != nil {
+			warns = append(warns, err)
+		}
+
+		// Runners sharing their caches might already have the artifact, if one of them
+		// does and it passes validation it is used in preference to the origin
+		if peers != nil {
+			if err := peers.fetch(ctx, hash, file); err == nil {
+				file.Close()
+				if err = promote(hash, partial, localName); err != nil {
+					warns = append(warns, err)
+				}
+				continue
+			}
+			if _, errGo = file.Seek(0, io.SeekStart); errGo == nil {
+				errGo = file.Truncate(0)
+			}
+			if errGo != nil {
+				warns = append(warns, kv.Wrap(errGo).With("partial", partial, "file", name).With("stack", stack.Trace().TrimRuntime()))
+			}
+		}
+
 		tapWriter := bufio.NewWriter(file)
 
 		// Having gained the file to download into call the fetch method and supply the io.WriteClose
@@ -452,10 +604,19 @@ func (s *objStore) Fetch(ctx context.Context, name string, unpack bool, output s
 		if err == nil {
 			info, errGo := os.Stat(partial)
 			if errGo == nil {
-				cache.Fetch(info.Name(), time.Hour*48,
-					func() (interface{}, error) {
-						return info, nil
-					})
+				withCache(func(c *ccache.Cache) {
+					c.Fetch(info.Name(), time.Hour*48,
+						func() (interface{}, error) {
+							return info, nil
+						})
+				})
+				err := index.update(hash, func(entry *CacheEntry) {
+					entry.Size = info.Size()
+					entry.Partial = false
+				})
+				if err != nil {
+					warns = append(warns, err)
+				}
 			} else {
 				select {
 				case <-ctx.Done():
@@ -487,6 +648,9 @@ func (s *objStore) Fetch(ctx context.Context, name string, unpack bool, output s
 			warn := kv.Wrap(errGo).With("since", time.Now().Sub(startTime).String(), "partial", partial, "file", name, "stack", stack.Trace().TrimRuntime())
 			warns = append(warns, warn)
 		}
+		if err := index.remove(hash); err != nil {
+			warns = append(warns, err)
+		}
 
 		select {
 		case <-ctx.Done():
@@ -499,6 +663,100 @@ func (s *objStore) Fetch(ctx context.Context, name string, unpack bool, output s
 	// unreachable
 }
 
+// resume completes a partial download from a previous instance of the runner by appending the
+// remainder of the object to it and then moving it into the cache.  If the download cannot be
+// resumed the partial download is discarded so that a fresh download will be started.
+//
+func (s *objStore) resume(ctx context.Context, name string, hash string, partial string, localName string) (err kv.Error) {
+	defer func() {
+		if err != nil {
+			os.Remove(partial)
+			index.remove(hash)
+		}
+	}()
+
+	tail, isTail := s.store.(TailFetcher)
+	if !isTail {
+		return kv.NewError("storage does not support resuming downloads").With("partial", partial, "file", name).With("stack", stack.Trace().TrimRuntime())
+	}
+	// The start of the file was written by a previous instance of the runner so the completed
+	// file must be checked against the hash, which is only possible for plain MD5 hashes
+	if !plainMD5.MatchString(hash) {
+		return kv.NewError("resumed download cannot be validated").With("partial", partial, "file", name, "hash", hash).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	file, errGo := os.OpenFile(partial, os.O_WRONLY|os.O_APPEND, 0600)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("partial", partial, "file", name).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer file.Close()
+
+	info, errGo := file.Stat()
+	if errGo != nil {
+		return kv.Wrap(errGo).With("partial", partial, "file", name).With("stack", stack.Trace().TrimRuntime())
+	}
+	if info.Size() == 0 {
+		return kv.NewError("partial download is empty").With("partial", partial, "file", name).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	out := bufio.NewWriter(file)
+	if err = tail.FetchTail(ctx, name, info.Size(), out); err != nil {
+		return err
+	}
+	if errGo = out.Flush(); errGo != nil {
+		return kv.Wrap(errGo).With("partial", partial, "file", name).With("stack", stack.Trace().TrimRuntime())
+	}
+	if errGo = file.Close(); errGo != nil {
+		return kv.Wrap(errGo).With("partial", partial, "file", name).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	actual, err := fileMD5(partial)
+	if err != nil {
+		return err.With("file", name)
+	}
+	if actual != hash {
+		return kv.NewError("resumed download hash mismatch").With("partial", partial, "file", name, "hash", hash, "actual", actual).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	return promote(hash, partial, localName)
+}
+
+// fileMD5 returns the hex encoded MD5 digest of the contents of a file
+//
+func fileMD5(fn string) (hash string, err kv.Error) {
+	file, errGo := os.Open(fn)
+	if errGo != nil {
+		return "", kv.Wrap(errGo).With("partial", fn).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer file.Close()
+
+	digest := md5.New()
+	if _, errGo = io.Copy(digest, file); errGo != nil {
+		return "", kv.Wrap(errGo).With("partial", fn).With("stack", stack.Trace().TrimRuntime())
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// promote moves a completed download into the cache and records its completion
+//
+func promote(hash string, partial string, localName string) (err kv.Error) {
+	info, errGo := os.Stat(partial)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("partial", partial).With("stack", stack.Trace().TrimRuntime())
+	}
+	if errGo = os.Rename(partial, localName); errGo != nil {
+		return kv.Wrap(errGo).With("partial", partial, "file", localName).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	withCache(func(c *ccache.Cache) {
+		c.Set(hash, info, time.Hour*48)
+	})
+	return index.update(hash, func(entry *CacheEntry) {
+		entry.Size = info.Size()
+		entry.Partial = false
+	})
+}
+
 // Hoard is used to place a directory with individual files into the storage resource within the storage implemented
 // by a specific implementation.
 //
@@ -0,0 +1,302 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of an exporter that sends the spans recorded using
+// OpenCensus tracing to an OpenTelemetry collector.  Spans are encoded using the JSON form of
+// the OTLP/HTTP protocol and are buffered, being sent as batches on a regular basis.
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"go.opencensus.io/trace"
+)
+
+const (
+	// otlpMaxBuffered is the number of spans held waiting for export after which spans are dropped
+	otlpMaxBuffered = 8192
+)
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+// otlpRequest is the body of an OTLP/HTTP trace export request
+//
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// OTLPExporter buffers the spans ended by the OpenCensus tracing package and sends them to an
+// OTLP/HTTP collector
+//
+type OTLPExporter struct {
+	endpoint string
+	resource []otlpKeyValue
+	client   *http.Client
+	spans    []*trace.SpanData // Spans waiting to be sent
+	dropped  uint64            // Spans discarded since the last send due to the buffer being full
+	sync.Mutex
+}
+
+// NewOTLPExporter creates an exporter that sends spans to the OTLP/HTTP traces endpoint of a
+// collector, for example http://localhost:4318/v1/traces.  The resource attributes identify
+// the process the spans originated within.
+//
+func NewOTLPExporter(endpoint string, resource map[string]string) (exporter *OTLPExporter) {
+	keys := make([]string, 0, len(resource))
+	for k := range resource {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	exporter = &OTLPExporter{
+		endpoint: endpoint,
+		resource: make([]otlpKeyValue, 0, len(keys)),
+		client:   &http.Client{Timeout: 30 * time.Second},
+		spans:    []*trace.SpanData{},
+	}
+	for _, k := range keys {
+		exporter.resource = append(exporter.resource, otlpAttribute(k, resource[k]))
+	}
+	return exporter
+}
+
+// ExportSpan implements the OpenCensus trace.Exporter interface and queues the span to be sent
+//
+func (e *OTLPExporter) ExportSpan(s *trace.SpanData) {
+	e.Lock()
+	defer e.Unlock()
+
+	if len(e.spans) >= otlpMaxBuffered {
+		e.dropped++
+		return
+	}
+	e.spans = append(e.spans, s)
+}
+
+// Flush sends any buffered spans to the collector
+//
+func (e *OTLPExporter) Flush(ctx context.Context) (err kv.Error) {
+	e.Lock()
+	spans := e.spans
+	dropped := e.dropped
+	e.spans = []*trace.SpanData{}
+	e.dropped = 0
+	e.Unlock()
+
+	if len(spans) == 0 {
+		if dropped != 0 {
+			return kv.NewError("spans dropped").With("dropped", dropped).With("stack", stack.Trace().TrimRuntime())
+		}
+		return nil
+	}
+
+	rqst := otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: e.resource},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/leaf-ai/studio-go-runner"},
+				Spans: make([]otlpSpan, 0, len(spans)),
+			}},
+		}},
+	}
+	for _, s := range spans {
+		rqst.ResourceSpans[0].ScopeSpans[0].Spans = append(rqst.ResourceSpans[0].ScopeSpans[0].Spans, otlpFromSpan(s))
+	}
+
+	body, errGo := json.Marshal(rqst)
+	if errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	req, errGo := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if errGo != nil {
+		return kv.Wrap(errGo).With("endpoint", e.endpoint).With("stack", stack.Trace().TrimRuntime())
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, errGo := e.client.Do(req.WithContext(ctx))
+	if errGo != nil {
+		return kv.Wrap(errGo).With("endpoint", e.endpoint).With("spans", len(spans)).With("stack", stack.Trace().TrimRuntime())
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return kv.NewError("spans rejected").With("endpoint", e.endpoint).With("status", resp.Status).
+			With("spans", len(spans)).With("stack", stack.Trace().TrimRuntime())
+	}
+	if dropped != 0 {
+		return kv.NewError("spans dropped").With("dropped", dropped).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
+// Run sends the buffered spans to the collector at regular intervals until the ctx is Done at
+// which point any remaining spans are sent.  Failures are reported using the errorC channel.
+//
+func (e *OTLPExporter) Run(ctx context.Context, interval time.Duration, errorC chan<- kv.Error) {
+	send := func(ctx context.Context) {
+		if err := e.Flush(ctx); err != nil {
+			select {
+			case errorC <- err:
+			default:
+			}
+		}
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			send(ctx)
+		case <-ctx.Done():
+			// The original ctx has finished so a fresh one is used to send the last of the spans
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			send(flushCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+func otlpAttribute(key string, value interface{}) (attr otlpKeyValue) {
+	attr = otlpKeyValue{Key: key}
+	switch v := value.(type) {
+	case string:
+		attr.Value.StringValue = &v
+	case bool:
+		attr.Value.BoolValue = &v
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		attr.Value.IntValue = &s
+	case float64:
+		attr.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		attr.Value.StringValue = &s
+	}
+	return attr
+}
+
+func otlpAttributes(attrs map[string]interface{}) (kvs []otlpKeyValue) {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs = make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, otlpAttribute(k, attrs[k]))
+	}
+	return kvs
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlpFromSpan converts an OpenCensus span into its OTLP form
+//
+func otlpFromSpan(s *trace.SpanData) (span otlpSpan) {
+	span = otlpSpan{
+		TraceID:           hex.EncodeToString(s.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.SpanID[:]),
+		Name:              s.Name,
+		StartTimeUnixNano: otlpTime(s.StartTime),
+		EndTimeUnixNano:   otlpTime(s.EndTime),
+		Attributes:        otlpAttributes(s.Attributes),
+	}
+	if s.ParentSpanID != (trace.SpanID{}) {
+		span.ParentSpanID = hex.EncodeToString(s.ParentSpanID[:])
+	}
+
+	// OTLP span kinds are internal 1, server 2, and client 3
+	switch s.SpanKind {
+	case trace.SpanKindServer:
+		span.Kind = 2
+	case trace.SpanKindClient:
+		span.Kind = 3
+	default:
+		span.Kind = 1
+	}
+
+	// OpenCensus uses gRPC status codes, OTLP only distinguishes errors using the code 2
+	if s.Status.Code != 0 {
+		span.Status = otlpStatus{Code: 2, Message: s.Status.Message}
+	}
+
+	for _, annotation := range s.Annotations {
+		span.Events = append(span.Events, otlpEvent{
+			TimeUnixNano: otlpTime(annotation.Time),
+			Name:         annotation.Message,
+			Attributes:   otlpAttributes(annotation.Attributes),
+		})
+	}
+	return span
+}
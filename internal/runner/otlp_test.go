@@ -0,0 +1,85 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"go.opencensus.io/trace"
+)
+
+// TestOTLPExport checks that spans started using the trace context of a submitter are sent
+// to an in-process collector as a part of the submitters trace
+//
+func TestOTLPExport(t *testing.T) {
+	received := []otlpRequest{}
+	receivedLock := sync.Mutex{}
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rqst := otlpRequest{}
+		if errGo := json.NewDecoder(r.Body).Decode(&rqst); errGo != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		receivedLock.Lock()
+		received = append(received, rqst)
+		receivedLock.Unlock()
+	}))
+	defer collector.Close()
+
+	if _, err := ParseTraceParent("00-00000000000000000000000000000000-00f067aa0ba902b7-01"); err == nil {
+		t.Fatal(kv.NewError("invalid trace identifier accepted").With("stack", stack.Trace().TrimRuntime()))
+	}
+	parent, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parent.IsSampled() {
+		t.Fatal(kv.NewError("sampled flag not seen").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	exporter := NewOTLPExporter(collector.URL+"/v1/traces", map[string]string{"service.name": "test"})
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	// Spans within a trace sampled by the submitter are always recorded
+	root := trace.NewSpanWithRemoteParent("receive", parent, trace.StartOptions{Sampler: trace.ProbabilitySampler(0)})
+	root.AddAttributes(trace.StringAttribute("project_id", "project"))
+	_, child := trace.StartSpan(trace.WithSpan(context.Background(), root), "fetch")
+	child.SetStatus(trace.Status{Code: 2, Message: "fetch failed"})
+	child.End()
+	root.End()
+
+	if err := exporter.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	receivedLock.Lock()
+	defer receivedLock.Unlock()
+
+	if len(received) != 1 || len(received[0].ResourceSpans) != 1 || len(received[0].ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatal(kv.NewError("export missing").With("received", received).With("stack", stack.Trace().TrimRuntime()))
+	}
+	spans := map[string]otlpSpan{}
+	for _, span := range received[0].ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[span.Name] = span
+	}
+
+	if spans["receive"].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || spans["receive"].ParentSpanID != "00f067aa0ba902b7" {
+		t.Fatal(kv.NewError("submitter trace not used").With("span", spans["receive"]).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if len(spans["receive"].Attributes) != 1 || *spans["receive"].Attributes[0].Value.StringValue != "project" {
+		t.Fatal(kv.NewError("span attributes missing").With("span", spans["receive"]).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if spans["fetch"].ParentSpanID != spans["receive"].SpanID || spans["fetch"].Status.Code != 2 {
+		t.Fatal(kv.NewError("child span incorrect").With("span", spans["fetch"]).With("stack", stack.Trace().TrimRuntime()))
+	}
+}
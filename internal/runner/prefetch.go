@@ -0,0 +1,267 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of a prefetcher for the artifact cache.  The prefetcher
+// learns which immutable artifacts are commonly used by the experiments arriving on each
+// subscription, and while the network is otherwise idle it downloads those artifacts that
+// have left the cache so that the next experiment from the subscription finds them locally.
+//
+// Only the identity of the artifacts is retained.  The credentials needed to retrieve them
+// are lent by the experiments that used them while those experiments are running, and once
+// an experiment finishes are retained for a bounded period before being discarded, so that
+// artifacts can be prefetched between the experiments arriving on a subscription without
+// credentials being held indefinitely.
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+
+	"github.com/lthibault/jitterbug"
+)
+
+type prefetchCreds struct {
+	creds   string
+	env     map[string]string
+	expires time.Time // Zero while the experiment that lent the credentials is running
+}
+
+type prefetchItem struct {
+	art       *Artifact
+	projectId string
+	leases    map[string]prefetchCreds // Credentials lent by running experiments
+	uses      uint
+	lastUsed  time.Time
+}
+
+// prefetchTask is a commonly used artifact along with credentials that can be used to retrieve it
+type prefetchTask struct {
+	art       *Artifact
+	projectId string
+	creds     prefetchCreds
+}
+
+// Prefetcher records the artifacts used by subscriptions and warms the artifact cache with
+// the most commonly used of them
+//
+type Prefetcher struct {
+	top    int                                 // The number of artifacts per subscription that are kept warm
+	minUse uint                                // The number of uses before an artifact is considered common
+	retain time.Duration                       // The time credentials remain usable once an experiment has released them
+	subs   map[string]map[string]*prefetchItem // Artifacts used within each subscription keyed on their qualified URI
+	sync.Mutex
+}
+
+// NewPrefetcher creates a prefetcher that will keep up to top artifacts from each subscription
+// within the cache.  Credentials lent by experiments remain usable for the retain duration once
+// the experiments have finished, a zero duration discards them as soon as they are released.
+//
+func NewPrefetcher(top int, retain time.Duration) (p *Prefetcher) {
+	return &Prefetcher{
+		top:    top,
+		minUse: 2,
+		retain: retain,
+		subs:   map[string]map[string]*prefetchItem{},
+	}
+}
+
+// Observe records the use of an artifact by an experiment from the named subscription.  Only
+// immutable artifacts that pass through the cache unchanged are candidates for prefetching.
+// The credentials are lent to the prefetcher until Release is called using the same lease,
+// which uniquely identifies the experiment.
+//
+func (p *Prefetcher) Observe(subscription string, art *Artifact, projectId string, lease string, creds string, env map[string]string) {
+	if art.Mutable || art.Incremental || art.Encrypted || len(art.Qualified) == 0 || strings.HasPrefix(art.Qualified, "file://") {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	items, isPresent := p.subs[subscription]
+	if !isPresent {
+		items = map[string]*prefetchItem{}
+		p.subs[subscription] = items
+	}
+	item, isPresent := items[art.Qualified]
+	if !isPresent {
+		item = &prefetchItem{leases: map[string]prefetchCreds{}}
+		items[art.Qualified] = item
+	}
+
+	item.art = art.Clone()
+	item.projectId = projectId
+	lent := prefetchCreds{creds: creds, env: make(map[string]string, len(env))}
+	for k, v := range env {
+		lent.env[k] = v
+	}
+	item.leases[lease] = lent
+	item.uses++
+	item.lastUsed = time.Now()
+}
+
+// Release starts the retention period for the credentials lent by an experiment, typically
+// because it has finished, after which they are discarded
+//
+func (p *Prefetcher) Release(lease string) {
+	p.Lock()
+	defer p.Unlock()
+
+	expires := time.Now().Add(p.retain)
+	for _, items := range p.subs {
+		for _, item := range items {
+			lent, isPresent := item.leases[lease]
+			if !isPresent {
+				continue
+			}
+			if p.retain <= 0 {
+				delete(item.leases, lease)
+				continue
+			}
+			lent.expires = expires
+			item.leases[lease] = lent
+		}
+	}
+}
+
+// Forget discards what has been learned about a subscription, typically because its queue
+// has been removed
+//
+func (p *Prefetcher) Forget(subscription string) {
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.subs, subscription)
+}
+
+// lent discards any credentials for the item whose retention has expired and returns the
+// remaining credentials that will stay usable longest, preferring those of running experiments
+//
+func (item *prefetchItem) lent(now time.Time) (creds prefetchCreds, isLent bool) {
+	for lease, candidate := range item.leases {
+		if !candidate.expires.IsZero() && !now.Before(candidate.expires) {
+			delete(item.leases, lease)
+			continue
+		}
+		if !isLent || (!creds.expires.IsZero() && (candidate.expires.IsZero() || candidate.expires.After(creds.expires))) {
+			creds, isLent = candidate, true
+		}
+	}
+	return creds, isLent
+}
+
+// candidates returns the commonly used artifacts for every subscription that have credentials
+// lent by a running experiment, or retained from a finished one, the most used first
+//
+func (p *Prefetcher) candidates(now time.Time) (tasks []*prefetchTask) {
+	p.Lock()
+	defer p.Unlock()
+
+	tasks = []*prefetchTask{}
+	for _, sub := range p.subs {
+		common := make([]*prefetchItem, 0, len(sub))
+		for _, item := range sub {
+			if _, isLent := item.lent(now); isLent && item.uses >= p.minUse {
+				common = append(common, item)
+			}
+		}
+		sort.Slice(common, func(i, j int) bool {
+			if common[i].uses == common[j].uses {
+				return common[i].lastUsed.After(common[j].lastUsed)
+			}
+			return common[i].uses > common[j].uses
+		})
+		if len(common) > p.top {
+			common = common[:p.top]
+		}
+		for _, item := range common {
+			lent, _ := item.lent(now)
+			tasks = append(tasks, &prefetchTask{art: item.art.Clone(), projectId: item.projectId, creds: lent})
+		}
+	}
+	return tasks
+}
+
+// Run will periodically warm the cache until the context is cancelled, transfers for
+// prefetching are only started when no other transfers are active
+//
+func (p *Prefetcher) Run(ctx context.Context, interval time.Duration, errorC chan kv.Error) {
+	check := NewTrigger(nil, interval, &jitterbug.Norm{Stdev: interval / 10})
+	defer check.Stop()
+
+	for {
+		select {
+		case <-check.C:
+			for _, task := range p.candidates(time.Now()) {
+				if !TransfersIdle() {
+					break
+				}
+				if err := p.warm(ctx, task); err != nil {
+					select {
+					case errorC <- err:
+					default:
+					}
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// warm downloads a single artifact into the cache if it is not already present
+//
+func (p *Prefetcher) warm(ctx context.Context, task *prefetchTask) (err kv.Error) {
+	if len(backingDir) == 0 {
+		return nil
+	}
+
+	storage, err := NewObjStore(ctx,
+		&StoreOpts{
+			Art:       task.art,
+			ProjectID: task.projectId,
+			Creds:     task.creds.creds,
+			Env:       task.creds.env,
+			Validate:  true,
+		},
+		nil)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	hash, err := storage.Hash(ctx, task.art.Key)
+	if err != nil {
+		return err
+	}
+	if len(hash) == 0 || CacheProbe(hash) {
+		return nil
+	}
+
+	release, err := AcquireTransfer(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// The cache retains the download, the copy made in the scratch directory is discarded
+	scratch, errGo := ioutil.TempDir(filepath.Join(backingDir, ".partial"), ".prefetch-")
+	if errGo != nil {
+		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer os.RemoveAll(scratch)
+
+	if _, err = storage.Fetch(ctx, task.art.Key, false, scratch); err != nil {
+		return err.With("qualified", task.art.Qualified)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv"
+)
+
+// TestPrefetchCandidates validates that only commonly used immutable artifacts are selected
+// for prefetching, that the number selected per subscription is limited, and that artifacts
+// are only prefetched while an experiment has lent credentials for them or the credentials
+// are still being retained after the experiment finished
+func TestPrefetchCandidates(t *testing.T) {
+	retain := time.Hour
+	p := NewPrefetcher(1, retain)
+
+	common := &Artifact{Key: "common.tar", Qualified: "s3://host/bucket/common.tar"}
+	rare := &Artifact{Key: "rare.tar", Qualified: "s3://host/bucket/rare.tar"}
+	mutable := &Artifact{Key: "output.tar", Qualified: "s3://host/bucket/output.tar", Mutable: true}
+	local := &Artifact{Key: "local.tar", Qualified: "file:///tmp/local.tar"}
+
+	for i := 0; i != 3; i++ {
+		p.Observe("queue", common, "project", "experiment", "", nil)
+		p.Observe("queue", mutable, "project", "experiment", "", nil)
+		p.Observe("queue", local, "project", "experiment", "", nil)
+	}
+	p.Observe("queue", rare, "project", "experiment", "", nil)
+	p.Observe("queue", rare, "project", "experiment", "", nil)
+
+	items := p.candidates(time.Now())
+	if len(items) != 1 || items[0].art.Qualified != common.Qualified {
+		t.Fatal(kv.NewError("unexpected prefetch candidates").With("count", len(items)).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// Between experiments the released credentials continue to be used until they expire
+	p.Release("experiment")
+	if items = p.candidates(time.Now()); len(items) != 1 || items[0].creds.expires.IsZero() {
+		t.Fatal(kv.NewError("released credentials were not retained").With("count", len(items)).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if items = p.candidates(time.Now().Add(retain)); len(items) != 0 {
+		t.Fatal(kv.NewError("candidates retained after credentials expired").With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// Credentials from a running experiment are preferred over retained ones
+	p.Observe("queue", common, "project", "other", "", nil)
+	p.Release("other")
+	p.Observe("queue", common, "project", "running", "", nil)
+	if items = p.candidates(time.Now()); len(items) != 1 || !items[0].creds.expires.IsZero() {
+		t.Fatal(kv.NewError("running experiment credentials were not preferred").With("count", len(items)).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	p.Forget("queue")
+	if items = p.candidates(time.Now()); len(items) != 0 {
+		t.Fatal(kv.NewError("forgotten subscription still had candidates").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
+
+// TestPrefetchNoRetention validates that credentials are discarded as soon as they are
+// released when no retention period is configured
+func TestPrefetchNoRetention(t *testing.T) {
+	p := NewPrefetcher(1, 0)
+
+	common := &Artifact{Key: "common.tar", Qualified: "s3://host/bucket/common.tar"}
+	p.Observe("queue", common, "project", "experiment", "", nil)
+	p.Observe("queue", common, "project", "experiment", "", nil)
+	if items := p.candidates(time.Now()); len(items) != 1 {
+		t.Fatal(kv.NewError("unexpected prefetch candidates").With("count", len(items)).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	p.Release("experiment")
+	if items := p.candidates(time.Now()); len(items) != 0 {
+		t.Fatal(kv.NewError("candidates retained after credentials were released").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
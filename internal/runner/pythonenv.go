@@ -40,6 +40,9 @@ func init() {
 type VirtualEnv struct {
 	Request *Request
 	Script  string
+	tmpDir  string // The temporary directory used by the running experiment
+	pid     int    // The process id of the running experiment
+	sync.Mutex
 }
 
 // NewVirtualEnv builds the VirtualEnv data structure from data received across the wire
@@ -168,6 +171,7 @@ func (p *VirtualEnv) Make(alloc *Allocated, e interface{}) (err kv.Error) {
 		StudioPIP string
 		CudaDir   string
 		Hostname  string
+		CPUSet    string
 	}{
 		AllocEnv:  []string{},
 		E:         e,
@@ -176,6 +180,7 @@ func (p *VirtualEnv) Make(alloc *Allocated, e interface{}) (err kv.Error) {
 		StudioPIP: studioPIP,
 		CudaDir:   cudaDir,
 		Hostname:  hostname,
+		CPUSet:    alloc.CPU.CPUSet(),
 	}
 
 	if alloc.GPU != nil {
@@ -185,6 +190,11 @@ func (p *VirtualEnv) Make(alloc *Allocated, e interface{}) (err kv.Error) {
 			}
 		}
 	}
+	if alloc.CPU != nil {
+		for k, v := range alloc.CPU.Env {
+			params.AllocEnv = append(params.AllocEnv, k+"="+v)
+		}
+	}
 
 	// Create a shell script that will do everything needed to run
 	// the python environment in a virtual env
@@ -285,7 +295,7 @@ echo "{\"studioml\": {\"start_time\": \"` + "`" + `date '+%FT%T.%N%:z'` + "`" +
 echo "{\"studioml\": {\"host\": \"{{.Hostname}}\"}}" | jq -c '.'
 set -x
 set -e
-python {{.E.Request.Experiment.Filename}} {{range .E.Request.Experiment.Args}}{{.}} {{end}}
+{{if .CPUSet}}taskset -c {{.CPUSet}} {{end}}python {{.E.Request.Experiment.Filename}} {{range .E.Request.Experiment.Args}}{{.}} {{end}}
 result=$?
 echo $result
 set +e
@@ -376,6 +386,15 @@ func (p *VirtualEnv) Run(ctx context.Context, refresh map[string]Artifact) (err
 	}
 	defer os.RemoveAll(tmpDir)
 
+	p.Lock()
+	p.tmpDir = tmpDir
+	p.Unlock()
+	defer func() {
+		p.Lock()
+		p.tmpDir = ""
+		p.Unlock()
+	}()
+
 	// Move to starting the process that we will monitor with the experiment running within
 	// it
 
@@ -409,6 +428,15 @@ func (p *VirtualEnv) Run(ctx context.Context, refresh map[string]Artifact) (err
 		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
 	}
 
+	p.Lock()
+	p.pid = cmd.Process.Pid
+	p.Unlock()
+	defer func() {
+		p.Lock()
+		p.pid = 0
+		p.Unlock()
+	}()
+
 	// Protect the err value when running multiple goroutines
 	errCheck := sync.Mutex{}
 
@@ -475,6 +503,27 @@ func (p *VirtualEnv) Run(ctx context.Context, refresh map[string]Artifact) (err
 	return err
 }
 
+// WorkDirs returns the directories outside of the experiment directory that the running
+// experiment is storing files within
+//
+func (p *VirtualEnv) WorkDirs() (dirs []string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.tmpDir) == 0 {
+		return []string{}
+	}
+	return []string{p.tmpDir}
+}
+
+// Pid returns the process id of the running experiment, or 0 if it is not running
+//
+func (p *VirtualEnv) Pid() (pid int) {
+	p.Lock()
+	defer p.Unlock()
+	return p.pid
+}
+
 // Close is used to close any resources which the encapsulated VirtualEnv may have consumed.
 //
 func (*VirtualEnv) Close() (err kv.Error) {
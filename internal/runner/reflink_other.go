@@ -0,0 +1,20 @@
+// +build !linux
+
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the copy-on-write file cloning for platforms where it is not supported
+
+import (
+	"os"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// reflink is not supported on this platform and so always fails causing callers to copy files
+//
+func reflink(in *os.File, out *os.File) (errGo error) {
+	return kv.NewError("reflinks unsupported").With("stack", stack.Trace().TrimRuntime())
+}
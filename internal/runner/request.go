@@ -137,6 +137,7 @@ type Database struct {
 type Experiment struct {
 	Args               []string            `json:"args"`
 	Artifacts          map[string]Artifact `json:"artifacts"`
+	ArtifactKey        string              `json:"artifact_key,omitempty"`
 	Filename           string              `json:"filename"`
 	Git                interface{}         `json:"git"`
 	Info               Info                `json:"info"`
@@ -170,25 +171,29 @@ type Info struct {
 // is used to encapsulate files and other external data sources
 // that the runner retrieve and/or upload as the experiment progresses
 type Artifact struct {
-	Bucket    string `json:"bucket"`
-	Key       string `json:"key"`
-	Hash      string `json:"hash,omitempty"`
-	Local     string `json:"local,omitempty"`
-	Mutable   bool   `json:"mutable"`
-	Unpack    bool   `json:"unpack"`
-	Qualified string `json:"qualified"`
+	Bucket      string `json:"bucket"`
+	Key         string `json:"key"`
+	Hash        string `json:"hash,omitempty"`
+	Local       string `json:"local,omitempty"`
+	Mutable     bool   `json:"mutable"`
+	Unpack      bool   `json:"unpack"`
+	Incremental bool   `json:"incremental,omitempty"`
+	Encrypted   bool   `json:"encrypted,omitempty"`
+	Qualified   string `json:"qualified"`
 }
 
 // Clone is a full on duplication of the original artifact
 func (a *Artifact) Clone() (b *Artifact) {
 	return &Artifact{
-		Bucket:    a.Bucket[:],
-		Key:       a.Key[:],
-		Hash:      a.Hash[:],
-		Local:     a.Local[:],
-		Mutable:   a.Mutable,
-		Unpack:    a.Unpack,
-		Qualified: a.Qualified[:],
+		Bucket:      a.Bucket[:],
+		Key:         a.Key[:],
+		Hash:        a.Hash[:],
+		Local:       a.Local[:],
+		Mutable:     a.Mutable,
+		Unpack:      a.Unpack,
+		Incremental: a.Incremental,
+		Encrypted:   a.Encrypted,
+		Qualified:   a.Qualified[:],
 	}
 }
 
@@ -83,8 +83,8 @@ func (*Resources) Alloc(rqst AllocRequest, live bool) (alloc *Allocated, err kv.
 		return nil, err
 	}
 
-	// CPU resources next
-	if alloc.CPU, err = AllocCPU(rqst.MaxCPU, rqst.MaxMem, live); err != nil {
+	// CPU resources next, preferring cores close to any GPUs that were allocated
+	if alloc.CPU, err = AllocCPU(rqst.MaxCPU, rqst.MaxMem, alloc.GPU.NUMANodes(), live); err != nil {
 		alloc.Release()
 		return nil, err
 	}
@@ -8,6 +8,7 @@ package runner
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -246,6 +247,72 @@ func (rmq *RabbitMQ) Exists(ctx context.Context, subscription string) (exists bo
 	return true, nil
 }
 
+// Depth will connect to the rabbitMQ management interface and retrieve the message counts for
+// the queue identified by the studio go runner subscription.  The age of the oldest message is
+// only available when the messages were published with a timestamp property.
+//
+func (rmq *RabbitMQ) Depth(ctx context.Context, subscription string) (depth *QueueDepth, err kv.Error) {
+	destHost := strings.Split(subscription, "?")
+	if len(destHost) != 2 {
+		return nil, kv.NewError("subscription supplied was not question-mark separated").With("stack", stack.Trace().TrimRuntime()).With("subscription", subscription)
+	}
+
+	timeout := 15 * time.Second
+	if deadline, isPresent := ctx.Deadline(); isPresent {
+		timeout = time.Until(deadline)
+	}
+
+	// The queue is queried directly as the management client does not decode the message
+	// timestamps.  A transport of its own is used so that closing its connections does not
+	// disturb the management client which uses the receiver's transport
+	transport := &http.Transport{
+		MaxIdleConns:    1,
+		IdleConnTimeout: timeout,
+	}
+	defer transport.CloseIdleConnections()
+
+	// The subscription components are already escaped
+	qURL := fmt.Sprintf("%s://%s/api/queues/%s/%s", rmq.mgmt.Scheme, rmq.mgmt.Host, destHost[0], destHost[1])
+
+	req, errGo := http.NewRequest("GET", qURL, nil)
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("subscription", subscription)
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(rmq.user, rmq.pass)
+
+	resp, errGo := (&http.Client{Transport: transport}).Do(req)
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("uri", rmq.Identity, "subscription", subscription)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, kv.NewError("queue depth unavailable").With("stack", stack.Trace().TrimRuntime()).With("uri", rmq.Identity, "subscription", subscription, "status", resp.Status)
+	}
+
+	info := struct {
+		Ready     int64 `json:"messages_ready"`
+		InFlight  int64 `json:"messages_unacknowledged"`
+		Timestamp int64 `json:"head_message_timestamp"`
+	}{}
+	if errGo = json.NewDecoder(resp.Body).Decode(&info); errGo != nil {
+		return nil, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("uri", rmq.Identity, "subscription", subscription)
+	}
+
+	depth = &QueueDepth{
+		Ready:    info.Ready,
+		InFlight: info.InFlight,
+	}
+	if info.Timestamp != 0 {
+		depth.OldestAge = time.Since(time.Unix(info.Timestamp, 0))
+		depth.AgeKnown = true
+	} else if info.Ready == 0 {
+		depth.AgeKnown = true
+	}
+	return depth, nil
+}
+
 // Work will connect to the rabbitMQ server identified in the receiver, rmq, and will see if any work
 // can be found on the queue identified by the go runner subscription and present work
 // to the handler for processing
@@ -6,10 +6,7 @@ package runner
 // be used by the runner to retrieve storage from cloud providers or localized storage
 
 import (
-	"archive/tar"
 	"bufio"
-	"compress/bzip2"
-	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
@@ -27,8 +24,6 @@ import (
 
 	"github.com/minio/minio-go"
 
-	bzip2w "github.com/dsnet/compress/bzip2"
-
 	"github.com/go-stack/stack"
 	"github.com/jjeffery/kv" // MIT License
 )
@@ -323,19 +318,22 @@ func (s *s3Storage) Fetch(ctx context.Context, name string, unpack bool, output
 		warns = append(warns, w)
 	}
 
-	obj, errGo := s.client.GetObjectWithContext(ctx, s.bucket, key, minio.GetObjectOptions{})
+	client := s.client
+	objInfo := minio.ObjectInfo{}
+	obj, errGo := client.GetObjectWithContext(ctx, s.bucket, key, minio.GetObjectOptions{})
 	if errGo == nil {
 		// Errors can be delayed until the first interaction with the storage platform so
 		// we exercise access to the meta data at least to validate the object we have
-		_, errGo = obj.Stat()
+		objInfo, errGo = obj.Stat()
 	}
 	if errGo != nil {
 		if minio.ToErrorResponse(errGo).Code == "AccessDenied" {
-			obj, errGo = s.anonClient.GetObjectWithContext(ctx, s.bucket, key, minio.GetObjectOptions{})
+			client = s.anonClient
+			obj, errGo = client.GetObjectWithContext(ctx, s.bucket, key, minio.GetObjectOptions{})
 			if errGo == nil {
 				// Errors can be delayed until the first interaction with the storage platform so
 				// we exercise access to the meta data at least to validate the object we have
-				_, errGo = obj.Stat()
+				objInfo, errGo = obj.Stat()
 			}
 		}
 		if errGo != nil {
@@ -344,98 +342,57 @@ func (s *s3Storage) Fetch(ctx context.Context, name string, unpack bool, output
 	}
 	defer obj.Close()
 
+	// Large objects are downloaded using multiple concurrent ranged requests, the
+	// parts being reassembled in order before being handed to the unpacker
+	var body io.Reader = obj
+	if parts := TransferParts(); parts > 1 && objInfo.Size > 2*RangedPartSize {
+		ranged := NewRangedReader(ctx, objInfo.Size, RangedPartSize, parts,
+			func(ctx context.Context, start int64, end int64) (rdr io.ReadCloser, err kv.Error) {
+				opts := minio.GetObjectOptions{}
+				if errGo := opts.SetRange(start, end); errGo != nil {
+					return nil, errCtx.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+				}
+				part, errGo := client.GetObjectWithContext(ctx, s.bucket, key, opts)
+				if errGo != nil {
+					return nil, errCtx.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+				}
+				return part, nil
+			})
+		defer ranged.Close()
+		body = ranged
+	}
+	body = NewThrottledReader(ctx, body)
+
 	// If the unpack flag is set then use a tar decompressor and unpacker
 	// but first make sure the output location is an existing directory
 	if unpack {
 
-		var inReader io.ReadCloser
-
-		switch fileType {
-		case "application/x-gzip", "application/zip":
-			if tap != nil {
-				// Create a stack of reader that first tee off any data read to a tap
-				// the tap being able to send data to things like caches etc
-				//
-				// Second in the stack of readers after the TAP is a decompression reader
-				inReader, errGo = gzip.NewReader(io.TeeReader(obj, tap))
-			} else {
-				inReader, errGo = gzip.NewReader(obj)
-			}
-		case "application/bzip2", "application/octet-stream":
-			if tap != nil {
-				// Create a stack of reader that first tee off any data read to a tap
-				// the tap being able to send data to things like caches etc
-				//
-				// Second in the stack of readers after the TAP is a decompression reader
-				inReader = ioutil.NopCloser(bzip2.NewReader(io.TeeReader(obj, tap)))
-			} else {
-				inReader = ioutil.NopCloser(bzip2.NewReader(obj))
-			}
-		default:
-			if tap != nil {
-				// Create a stack of reader that first tee off any data read to a tap
-				// the tap being able to send data to things like caches etc
-				//
-				// Second in the stack of readers after the TAP is a decompression reader
-				inReader = ioutil.NopCloser(io.TeeReader(obj, tap))
-			} else {
-				inReader = ioutil.NopCloser(obj)
-			}
-		}
-		if errGo != nil {
-			return warns, errCtx.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+		var src io.Reader = body
+		if tap != nil {
+			// Create a stack of reader that first tee off any data read to a tap
+			// the tap being able to send data to things like caches etc
+			//
+			src = io.TeeReader(body, tap)
 		}
-		defer inReader.Close()
-
-		// Last in the stack is a tar file handling reader
-		tarReader := tar.NewReader(inReader)
 
-		for {
-			header, errGo := tarReader.Next()
-			if errGo == io.EOF {
-				break
-			} else if errGo != nil {
-				return warns, errCtx.Wrap(errGo).With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
-			}
-
-			path := filepath.Join(output, header.Name)
-
-			if len(header.Linkname) != 0 {
-				if errGo = os.Symlink(header.Linkname, path); errGo != nil {
-					return warns, errCtx.Wrap(errGo, "symbolic link create failed").With("stack", stack.Trace().TrimRuntime())
-				}
-				continue
+		// Zip archives are not streamable and are handled as a whole
+		if fileType == "application/zip" {
+			if err = Unzip(ctx, src, output); err != nil {
+				return warns, errCtx.Wrap(err).With("fileType", fileType).With("stack", stack.Trace().TrimRuntime())
 			}
+			return warns, nil
+		}
 
-			switch header.Typeflag {
-			case tar.TypeDir:
-				if info.IsDir() {
-					if errGo = os.MkdirAll(path, os.FileMode(header.Mode)); errGo != nil {
-						return warns, errCtx.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("path", path)
-					}
-				}
-			case tar.TypeReg, tar.TypeRegA:
-
-				// If the file name included directories then these should be created
-				if parent, err := filepath.Abs(path); err == nil {
-					// implicitly
-					_ = os.MkdirAll(filepath.Dir(parent), os.ModePerm)
-				}
-
-				file, errGo := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode))
-				if errGo != nil {
-					return warns, errCtx.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("path", path)
-				}
+		// Second in the stack of readers after the TAP is a decompression reader
+		inReader, err := NewDecompressor(fileType, src)
+		if err != nil {
+			return warns, errCtx.Wrap(err).With("stack", stack.Trace().TrimRuntime())
+		}
+		defer inReader.Close()
 
-				_, errGo = io.Copy(file, tarReader)
-				file.Close()
-				if errGo != nil {
-					return warns, errCtx.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("path", path)
-				}
-			default:
-				errGo = fmt.Errorf("unknown tar archive type '%c'", header.Typeflag)
-				return warns, errCtx.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("path", path)
-			}
+		// Last in the stack is the hardened tar extractor
+		if err = Untar(ctx, inReader, output); err != nil {
+			return warns, errCtx.Wrap(err).With("fileType", fileType)
 		}
 	} else {
 		errGo := os.MkdirAll(output, 0700)
@@ -455,9 +412,9 @@ func (s *s3Storage) Fetch(ctx context.Context, name string, unpack bool, output
 			// the tap being able to send data to things like caches etc
 			//
 			// Second in the stack of readers after the TAP is a decompression reader
-			_, errGo = io.Copy(outf, io.TeeReader(obj, tap))
+			_, errGo = io.Copy(outf, io.TeeReader(body, tap))
 		} else {
-			_, errGo = io.Copy(outf, obj)
+			_, errGo = io.Copy(outf, body)
 		}
 		if errGo != nil {
 			return warns, errCtx.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("path", path)
@@ -467,6 +424,44 @@ func (s *s3Storage) Fetch(ctx context.Context, name string, unpack bool, output
 	return warns, nil
 }
 
+// FetchTail retrieves the contents of the named object starting at the offset supplied and
+// writes them to the output writer, it is used to resume interrupted downloads
+//
+func (s *s3Storage) FetchTail(ctx context.Context, name string, offset int64, out io.Writer) (err kv.Error) {
+
+	key := name
+	if len(key) == 0 {
+		key = s.key
+	}
+	errCtx := kv.With("name", name).With("offset", offset).
+		With("bucket", s.bucket).With("key", key).With("endpoint", s.endpoint)
+
+	opts := minio.GetObjectOptions{}
+	if errGo := opts.SetRange(offset, 0); errGo != nil {
+		return errCtx.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	obj, errGo := s.client.GetObjectWithContext(ctx, s.bucket, key, opts)
+	if errGo == nil {
+		_, errGo = obj.Stat()
+	}
+	if errGo != nil && minio.ToErrorResponse(errGo).Code == "AccessDenied" {
+		obj, errGo = s.anonClient.GetObjectWithContext(ctx, s.bucket, key, opts)
+		if errGo == nil {
+			_, errGo = obj.Stat()
+		}
+	}
+	if errGo != nil {
+		return errCtx.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	defer obj.Close()
+
+	if _, errGo = io.Copy(out, NewThrottledReader(ctx, obj)); errGo != nil {
+		return errCtx.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
+	}
+	return nil
+}
+
 // uploadFile can be used to transmit a file to the S3 server using a fully qualified file
 // name and key
 //
@@ -486,8 +481,9 @@ func (s *s3Storage) uploadFile(ctx context.Context, src string, dest string) (er
 		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("src", src)
 	}
 
-	_, errGo = s.client.PutObjectWithContext(ctx, s.bucket, dest, file, fileStat.Size(), minio.PutObjectOptions{
+	_, errGo = s.client.PutObjectWithContext(ctx, s.bucket, dest, NewThrottledReader(ctx, file), fileStat.Size(), minio.PutObjectOptions{
 		ContentType: "application/octet-stream",
+		NumThreads:  TransferParts(),
 	})
 	if errGo != nil {
 		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("src", src, "bucket", s.bucket, "key", dest)
@@ -540,8 +536,8 @@ func (s *s3Storage) Hoard(ctx context.Context, srcDir string, keyPrefix string)
 //
 func (s *s3Storage) Deposit(ctx context.Context, src string, dest string) (warns []kv.Error, err kv.Error) {
 
-	if !IsTar(dest) {
-		return warns, kv.NewError("uploads must be tar, or tar compressed files").With("stack", stack.Trace().TrimRuntime()).With("key", dest)
+	if !IsArchive(dest) {
+		return warns, kv.NewError("uploads must be tar, tar compressed, or zip files").With("stack", stack.Trace().TrimRuntime()).With("key", dest)
 	}
 
 	key := dest
@@ -564,7 +560,7 @@ func (s *s3Storage) Deposit(ctx context.Context, src string, dest string) (warns
 	go streamingWriter(pr, pw, files, dest, swErrorC)
 
 	s3ErrorC := make(chan kv.Error)
-	go s.s3Put(key, pr, s3ErrorC)
+	go s.s3Put(key, NewThrottledReader(ctx, pr), s3ErrorC)
 
 	finished := 2
 	for {
@@ -592,7 +588,7 @@ func (s *s3Storage) Deposit(ctx context.Context, src string, dest string) (warns
 	return warns, nil
 }
 
-func (s *s3Storage) s3Put(key string, pr *io.PipeReader, errorC chan kv.Error) {
+func (s *s3Storage) s3Put(key string, pr io.Reader, errorC chan kv.Error) {
 
 	errS := kv.With("key", key).With("bucket", s.bucket)
 
@@ -602,7 +598,7 @@ func (s *s3Storage) s3Put(key string, pr *io.PipeReader, errorC chan kv.Error) {
 		}
 		close(errorC)
 	}()
-	if _, errGo := s.client.PutObject(s.bucket, key, pr, -1, minio.PutObjectOptions{}); errGo != nil {
+	if _, errGo := s.client.PutObject(s.bucket, key, pr, -1, minio.PutObjectOptions{NumThreads: TransferParts()}); errGo != nil {
 		errorC <- errS.Wrap(minio.ToErrorResponse(errGo)).With("stack", stack.Trace().TrimRuntime())
 		return
 	}
@@ -637,34 +633,7 @@ func streamingWriter(pr *io.PipeReader, pw *io.PipeWriter, files *TarWriter, des
 	typ, w := MimeFromExt(dest)
 	sender.send(w)
 
-	switch typ {
-	case "application/tar", "application/octet-stream":
-		tw := tar.NewWriter(pw)
-		if errGo := files.Write(tw); errGo != nil {
-			sender.send(errGo)
-		}
-		tw.Close()
-	case "application/bzip2":
-		outZ, _ := bzip2w.NewWriter(pw, &bzip2w.WriterConfig{Level: 6})
-		tw := tar.NewWriter(outZ)
-		if errGo := files.Write(tw); errGo != nil {
-			sender.send(errGo)
-		}
-		tw.Close()
-		outZ.Close()
-	case "application/x-gzip":
-		outZ := gzip.NewWriter(pw)
-		tw := tar.NewWriter(outZ)
-		if errGo := files.Write(tw); errGo != nil {
-			sender.send(errGo)
-		}
-		tw.Close()
-		outZ.Close()
-	case "application/zip":
-		sender.send(kv.NewError("only tar archives are supported").With("stack", stack.Trace().TrimRuntime()).With("key", dest))
-		return
-	default:
-		sender.send(kv.NewError("unrecognized upload compression").With("stack", stack.Trace().TrimRuntime()).With("key", dest))
-		return
+	if err := WriteArchive(typ, files, pw); err != nil {
+		sender.send(err.With("key", dest))
 	}
 }
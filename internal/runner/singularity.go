@@ -30,6 +30,8 @@ type Singularity struct {
 	Request   *Request
 	BaseDir   string
 	BaseImage string
+	pid       int // The process id of the running experiment
+	sync.Mutex
 }
 
 // NewSingularity is used to instantiate a singularity resource based upon a request, typically sent
@@ -230,22 +232,24 @@ func (s *Singularity) runBuildScript(script string) (err kv.Error) {
 		}
 	}()
 
-	return runWait(ctx, script, filepath.Join(s.BaseDir, "_runner"), outputFN, reporterC)
+	return runWait(ctx, script, filepath.Join(s.BaseDir, "_runner"), outputFN, reporterC, nil)
 }
 
-func (s *Singularity) makeExecScript(e interface{}) (fn string, err kv.Error) {
+func (s *Singularity) makeExecScript(alloc *Allocated, e interface{}) (fn string, err kv.Error) {
 
 	fn = filepath.Join(s.BaseDir, "_runner", "exec.sh")
 
 	params := struct {
-		Dir string
+		Dir    string
+		CPUSet string
 	}{
-		Dir: filepath.Join(s.BaseDir, "_runner"),
+		Dir:    filepath.Join(s.BaseDir, "_runner"),
+		CPUSet: alloc.CPU.CPUSet(),
 	}
 
 	tmpl, errGo := template.New("singularityRunner").Parse(
 		`#!/bin/bash -x
-singularity run --home {{.Dir}} -B /tmp:/tmp -B /usr/local/cuda:/usr/local/cuda -B /usr/lib/nvidia-384:/usr/lib/nvidia-384 --nv {{.Dir}}/runner.img
+{{if .CPUSet}}taskset -c {{.CPUSet}} {{end}}singularity run --home {{.Dir}} -B /tmp:/tmp -B /usr/local/cuda:/usr/local/cuda -B /usr/lib/nvidia-384:/usr/lib/nvidia-384 --nv {{.Dir}}/runner.img
 `)
 
 	if errGo != nil {
@@ -283,7 +287,7 @@ func (s *Singularity) Make(alloc *Allocated, e interface{}) (err kv.Error) {
 		return err
 	}
 
-	if _, err = s.makeExecScript(e); err != nil {
+	if _, err = s.makeExecScript(alloc, e); err != nil {
 		return err
 	}
 
@@ -313,10 +317,20 @@ func (s *Singularity) Run(ctx context.Context, refresh map[string]Artifact) (err
 		}
 	}()
 
-	return runWait(ctx, script, filepath.Join(s.BaseDir, "_runner"), outputFN, reporterC)
+	started := func(pid int) {
+		s.Lock()
+		s.pid = pid
+		s.Unlock()
+	}
+	defer started(0)
+
+	return runWait(ctx, script, filepath.Join(s.BaseDir, "_runner"), outputFN, reporterC, started)
 }
 
-func runWait(ctx context.Context, script string, dir string, outputFN string, errorC chan *string) (err kv.Error) {
+// runWait runs a script and waits for it to complete, the started function if supplied is
+// called with the process id of the script once it is running
+//
+func runWait(ctx context.Context, script string, dir string, outputFN string, errorC chan *string, started func(pid int)) (err kv.Error) {
 
 	stopCopy, stopCopyCancel := context.WithCancel(context.Background())
 	// defers are stacked in LIFO order so cancelling this context is the last
@@ -354,6 +368,9 @@ func runWait(ctx context.Context, script string, dir string, outputFN string, er
 	if errGo = cmd.Start(); err != nil {
 		return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime())
 	}
+	if started != nil && cmd.Process != nil {
+		started(cmd.Process.Pid)
+	}
 
 	waitOnIO := sync.WaitGroup{}
 	waitOnIO.Add(2)
@@ -425,6 +442,21 @@ func runWait(ctx context.Context, script string, dir string, outputFN string, er
 	return err
 }
 
+// WorkDirs returns the directories outside of the experiment directory that the running
+// experiment is storing files within, singularity containers only use the experiment directory
+//
+func (*Singularity) WorkDirs() (dirs []string) {
+	return []string{}
+}
+
+// Pid returns the process id of the running experiment, or 0 if it is not running
+//
+func (s *Singularity) Pid() (pid int) {
+	s.Lock()
+	defer s.Unlock()
+	return s.pid
+}
+
 // Close is a stub method for termination of a singularity resource
 func (*Singularity) Close() (err kv.Error) {
 	return nil
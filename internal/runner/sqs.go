@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"regexp"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
@@ -201,6 +202,56 @@ func (sq *SQS) Exists(ctx context.Context, subscription string) (exists bool, er
 	return false, nil
 }
 
+// Depth retrieves the approximate number of messages waiting and in flight for the
+// subscription.  SQS only reports the age of the oldest message using CloudWatch and so
+// it is not available.
+//
+func (sq *SQS) Depth(ctx context.Context, subscription string) (depth *QueueDepth, err kv.Error) {
+
+	regionUrl := strings.SplitN(subscription, ":", 2)
+	if len(regionUrl) != 2 {
+		return nil, kv.NewError("subscription supplied was not colon separated").With("stack", stack.Trace().TrimRuntime()).With("subscription", subscription)
+	}
+	url := sq.project + "/" + regionUrl[1]
+
+	sess, errGo := session.NewSessionWithOptions(session.Options{
+		Config: aws.Config{
+			Region:                        aws.String(sq.creds.Region),
+			Credentials:                   sq.creds.Creds,
+			CredentialsChainVerboseErrors: aws.Bool(true),
+		},
+		Profile: "default",
+	})
+
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("credentials", sq.creds)
+	}
+
+	// Create a SQS service client.
+	svc := sqs.New(sess)
+
+	attrs, errGo := svc.GetQueueAttributesWithContext(ctx,
+		&sqs.GetQueueAttributesInput{
+			QueueUrl: &url,
+			AttributeNames: []*string{
+				aws.String(sqs.QueueAttributeNameApproximateNumberOfMessages),
+				aws.String(sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible),
+			},
+		})
+	if errGo != nil {
+		return nil, kv.Wrap(errGo).With("credentials", sq.creds, "url", url).With("stack", stack.Trace().TrimRuntime())
+	}
+
+	depth = &QueueDepth{}
+	if value, isPresent := attrs.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessages]; isPresent && value != nil {
+		depth.Ready, _ = strconv.ParseInt(*value, 10, 64)
+	}
+	if value, isPresent := attrs.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible]; isPresent && value != nil {
+		depth.InFlight, _ = strconv.ParseInt(*value, 10, 64)
+	}
+	return depth, nil
+}
+
 // Work is invoked by the queue handling software within the runner to get the
 // specific queue implementation to process potential work that could be
 // waiting inside the queue.
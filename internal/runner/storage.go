@@ -49,6 +49,13 @@ type Storage interface {
 	Close()
 }
 
+// TailFetcher is implemented by storage platforms that can retrieve an object starting part way
+// through its contents, it is used to resume downloads into the artifact cache that were interrupted
+//
+type TailFetcher interface {
+	FetchTail(ctx context.Context, name string, offset int64, out io.Writer) (err kv.Error)
+}
+
 // StoreOpts is used to encapsulate a storage implementation with the runner and studioml data needed
 //
 type StoreOpts struct {
@@ -119,6 +126,12 @@ func IsTar(name string) bool {
 		return true
 	case strings.HasSuffix(name, ".tbz"):
 		return true
+	case strings.HasSuffix(name, ".tzst"):
+		return true
+	case strings.HasSuffix(name, ".txz"):
+		return true
+	case strings.HasSuffix(name, ".tlz4"):
+		return true
 	}
 	return false
 }
@@ -135,6 +148,12 @@ func MimeFromExt(name string) (fileType string, err kv.Error) {
 		return "application/bzip2", nil
 	case ".tb2", ".tbz", ".tbz2", ".bzip2", ".bz2": // Standard bzip2 extensions
 		return "application/bzip2", nil
+	case ".zst", ".zstd", ".tzst":
+		return "application/zstd", nil
+	case ".xz", ".txz":
+		return "application/x-xz", nil
+	case ".lz4", ".tlz4":
+		return "application/x-lz4", nil
 	case ".tar":
 		return "application/tar", nil
 	default:
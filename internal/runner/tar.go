@@ -8,6 +8,7 @@ package runner
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"io"
 	"os"
 	"path/filepath"
@@ -133,3 +134,67 @@ func (t *TarWriter) Write(tw *tar.Writer) (err kv.Error) {
 	}
 	return nil
 }
+
+// WriteZip is used to output the files within the catalog of the runners
+// file list into a go zip file writer
+//
+func (t *TarWriter) WriteZip(zw *zip.Writer) (err kv.Error) {
+
+	for file, header := range t.files {
+		err = func() (err kv.Error) {
+			fi, errGo := os.Lstat(file)
+			if errGo != nil {
+				// Working files can be recycled on occasion and disappear, handle this
+				// possibility
+				if os.IsNotExist(errGo) {
+					return nil
+				}
+				return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("file", file)
+			}
+
+			zipHeader, errGo := zip.FileInfoHeader(fi)
+			if errGo != nil {
+				return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("file", file)
+			}
+			// Use the same relative naming that was used for the tar catalog, zip
+			// uses forward slashes and a trailing slash to denote a directory
+			zipHeader.Name = filepath.ToSlash(header.Name)
+			if fi.IsDir() {
+				zipHeader.Name += "/"
+			} else {
+				zipHeader.Method = zip.Deflate
+			}
+
+			w, errGo := zw.CreateHeader(zipHeader)
+			if errGo != nil {
+				return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("file", file)
+			}
+
+			switch {
+			case fi.Mode()&os.ModeSymlink != 0:
+				// Symbolic links are stored using the link destination as the content
+				if _, errGo = io.WriteString(w, header.Linkname); errGo != nil {
+					return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("file", file)
+				}
+				return nil
+			case !fi.Mode().IsRegular():
+				return nil
+			}
+
+			f, errGo := os.Open(filepath.Clean(file))
+			if errGo != nil {
+				return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("file", file)
+			}
+			defer func() { _ = f.Close() }()
+
+			if _, errGo = io.Copy(w, f); errGo != nil {
+				return kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()).With("file", file)
+			}
+			return nil
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/go-stack/stack"
 	"github.com/jjeffery/kv" // MIT License
@@ -30,6 +31,15 @@ type QueueTask struct {
 //
 type MsgHandler func(ctx context.Context, qt *QueueTask) (resource *Resource, ack bool, err kv.Error)
 
+// QueueDepth describes the work waiting within a queue
+//
+type QueueDepth struct {
+	Ready     int64         // The number of messages waiting to be delivered
+	InFlight  int64         // The number of messages delivered and not yet acknowledged
+	OldestAge time.Duration // The age of the oldest message waiting to be delivered
+	AgeKnown  bool          // Set when the queue implementation was able to determine OldestAge
+}
+
 // TaskQueue is the interface definition for a queue message handling implementation.
 //
 type TaskQueue interface {
@@ -42,6 +52,9 @@ type TaskQueue interface {
 
 	// Check that the specified queue exists
 	Exists(ctx context.Context, subscription string) (exists bool, err kv.Error)
+
+	// Depth retrieves the amount of work waiting within the specified queue
+	Depth(ctx context.Context, subscription string) (depth *QueueDepth, err kv.Error)
 }
 
 // NewTaskQueue is used to initiate processing for any of the types of queues
@@ -0,0 +1,266 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of a runner wide budget for artifact transfers.  The
+// budget limits the number of artifacts being concurrently transferred, the number of parallel
+// ranged requests used within large objects, and the overall bandwidth consumed by transfers
+// across all of the experiments running on the runner.
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+const (
+	// RangedPartSize is the size of the individual ranged requests that are used to download
+	// large objects in parallel
+	RangedPartSize = int64(32 * 1024 * 1024)
+)
+
+type transferTracker struct {
+	slots     chan struct{} // Semaphore used to limit the number of concurrent artifact transfers
+	parts     uint          // The number of parallel ranged requests used for large objects
+	bandwidth uint64        // The maximum bytes per second for all transfers, 0 is unlimited
+
+	next time.Time // The time at which the bandwidth consumed so far will have been paid for
+
+	sync.Mutex
+}
+
+var (
+	transferTrack = &transferTracker{
+		slots: make(chan struct{}, 8),
+		parts: 4,
+	}
+)
+
+// SetTransferLimits is used to set the maximum number of concurrent artifact transfers across
+// all experiments, the number of parallel ranged requests used for each large object, and the
+// maximum bandwidth in bytes per second, 0 being unlimited, to be shared by all transfers.
+//
+// Changing the limits does not affect transfers that are already in progress.
+//
+func SetTransferLimits(maxTransfers uint, parts uint, bandwidth uint64) (err kv.Error) {
+	if maxTransfers == 0 {
+		return kv.NewError("the maximum number of transfers must be at least 1").With("stack", stack.Trace().TrimRuntime())
+	}
+	if parts == 0 {
+		return kv.NewError("the number of parts for ranged transfers must be at least 1").With("stack", stack.Trace().TrimRuntime())
+	}
+
+	transferTrack.Lock()
+	defer transferTrack.Unlock()
+
+	transferTrack.slots = make(chan struct{}, maxTransfers)
+	transferTrack.parts = parts
+	transferTrack.bandwidth = bandwidth
+	transferTrack.next = time.Time{}
+
+	return nil
+}
+
+// TransferParts returns the number of parallel ranged requests that should be
+// used when transferring a large object
+//
+func TransferParts() (parts uint) {
+	transferTrack.Lock()
+	defer transferTrack.Unlock()
+	return transferTrack.parts
+}
+
+// AcquireTransfer blocks until a slot within the runner wide transfer budget is available, or
+// the context is cancelled.  The release function returned must be called once the transfer
+// is complete.
+//
+func AcquireTransfer(ctx context.Context) (release func(), err kv.Error) {
+	transferTrack.Lock()
+	slots := transferTrack.slots
+	transferTrack.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, kv.NewError("waiting for a transfer slot terminated").With("stack", stack.Trace().TrimRuntime())
+	}
+}
+
+// TransfersIdle returns true when no artifact transfers are in progress
+//
+func TransfersIdle() (idle bool) {
+	transferTrack.Lock()
+	slots := transferTrack.slots
+	transferTrack.Unlock()
+
+	return len(slots) == 0
+}
+
+// throttle will delay the caller for long enough that the bytes being transferred fit within the
+// bandwidth budget.  Bandwidth is reserved in the order callers arrive.
+func throttle(ctx context.Context, bytes int) (err kv.Error) {
+	transferTrack.Lock()
+	if transferTrack.bandwidth == 0 || bytes <= 0 {
+		transferTrack.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	if transferTrack.next.Before(now) {
+		transferTrack.next = now
+	}
+	wait := transferTrack.next.Sub(now)
+	transferTrack.next = transferTrack.next.Add(time.Duration(float64(bytes) / float64(transferTrack.bandwidth) * float64(time.Second)))
+	transferTrack.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return kv.NewError("transfer terminated").With("stack", stack.Trace().TrimRuntime())
+	}
+}
+
+type throttledReader struct {
+	ctx   context.Context
+	r     io.Reader
+	usage *UsageTracker
+}
+
+// NewThrottledReader wraps a reader so that data read through it is accounted
+// for within the runner wide bandwidth budget
+//
+func NewThrottledReader(ctx context.Context, r io.Reader) (reader io.Reader) {
+	return &throttledReader{ctx: ctx, r: r, usage: usageFromContext(ctx)}
+}
+
+func (t *throttledReader) Read(p []byte) (n int, errGo error) {
+	n, errGo = t.r.Read(p)
+	if t.usage != nil && n > 0 {
+		t.usage.transferred(n)
+	}
+	if err := throttle(t.ctx, n); err != nil && errGo == nil {
+		errGo = err
+	}
+	return n, errGo
+}
+
+// RangeFetcher is a function that can retrieve the bytes between start and end, inclusive, of a
+// remote object
+//
+type RangeFetcher func(ctx context.Context, start int64, end int64) (rdr io.ReadCloser, err kv.Error)
+
+type rangedPart struct {
+	data []byte
+	err  kv.Error
+}
+
+// rangedReader presents a sequential stream over an object that is being downloaded
+// using multiple concurrent ranged requests
+type rangedReader struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	partsC   []chan rangedPart
+	inflight chan struct{}
+	current  []byte
+	next     int
+	err      error
+}
+
+// NewRangedReader will return a reader that downloads an object of a known size using
+// parallel ranged requests, returning the contents in order.  At most parts requests of
+// partSize will be outstanding, or buffered waiting to be read, at any one time.
+//
+func NewRangedReader(ctx context.Context, size int64, partSize int64, parts uint, fetch RangeFetcher) (rdr io.ReadCloser) {
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	count := int((size + partSize - 1) / partSize)
+	r := &rangedReader{
+		ctx:      ctx,
+		cancel:   cancel,
+		partsC:   make([]chan rangedPart, count),
+		inflight: make(chan struct{}, parts),
+	}
+	for i := range r.partsC {
+		r.partsC[i] = make(chan rangedPart, 1)
+	}
+
+	go func() {
+		for i := 0; i < count; i++ {
+			select {
+			case r.inflight <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go r.fetchPart(i, size, partSize, fetch)
+		}
+	}()
+
+	return r
+}
+
+func (r *rangedReader) fetchPart(i int, size int64, partSize int64, fetch RangeFetcher) {
+	start := int64(i) * partSize
+	end := start + partSize - 1
+	if end >= size {
+		end = size - 1
+	}
+
+	part := rangedPart{}
+	body, err := fetch(r.ctx, start, end)
+	if err == nil {
+		part.data = make([]byte, end-start+1)
+		if _, errGo := io.ReadFull(body, part.data); errGo != nil {
+			err = kv.Wrap(errGo).With("start", start, "end", end).With("stack", stack.Trace().TrimRuntime())
+		}
+		body.Close()
+	}
+	part.err = err
+	r.partsC[i] <- part
+}
+
+// Read returns the contents of the object in order, waiting for parts to arrive as needed
+//
+func (r *rangedReader) Read(p []byte) (n int, errGo error) {
+	for len(r.current) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.next >= len(r.partsC) {
+			return 0, io.EOF
+		}
+		select {
+		case part := <-r.partsC[r.next]:
+			r.next++
+			// Having consumed a part allow another request to start
+			<-r.inflight
+			if part.err != nil {
+				r.err = part.err
+				continue
+			}
+			r.current = part.data
+		case <-r.ctx.Done():
+			r.err = kv.NewError("ranged transfer terminated").With("stack", stack.Trace().TrimRuntime())
+		}
+	}
+	n = copy(p, r.current)
+	r.current = r.current[n:]
+	return n, nil
+}
+
+// Close will stop any outstanding ranged requests
+//
+func (r *rangedReader) Close() (errGo error) {
+	r.cancel()
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv"
+)
+
+// TestRangedReader checks that an object fetched using parallel ranged requests is
+// reassembled in order and that the number of outstanding requests is bounded
+func TestRangedReader(t *testing.T) {
+
+	data := []byte(RandomString(1000*1000 + 17))
+	partSize := int64(64 * 1024)
+	parts := uint(3)
+
+	active := 0
+	maxActive := 0
+	lock := sync.Mutex{}
+
+	fetch := func(ctx context.Context, start int64, end int64) (rdr io.ReadCloser, err kv.Error) {
+		lock.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		lock.Unlock()
+
+		// Randomize the completion order of the parts
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+
+		lock.Lock()
+		active--
+		lock.Unlock()
+		return ioutil.NopCloser(bytes.NewReader(data[start : end+1])), nil
+	}
+
+	rdr := NewRangedReader(context.Background(), int64(len(data)), partSize, parts, fetch)
+	defer rdr.Close()
+
+	result, errGo := ioutil.ReadAll(rdr)
+	if errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if !bytes.Equal(data, result) {
+		t.Fatal(kv.NewError("reassembled contents did not match").With("expected", len(data), "actual", len(result)).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if maxActive > int(parts) {
+		t.Fatal(kv.NewError("too many concurrent ranged requests").With("parts", parts, "active", maxActive).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	// A failed part should be surfaced to the reader
+	failed := func(ctx context.Context, start int64, end int64) (rdr io.ReadCloser, err kv.Error) {
+		if start != 0 {
+			return nil, kv.NewError("part failed").With("stack", stack.Trace().TrimRuntime())
+		}
+		return fetch(ctx, start, end)
+	}
+	rdr = NewRangedReader(context.Background(), int64(len(data)), partSize, parts, failed)
+	defer rdr.Close()
+
+	if _, errGo = ioutil.ReadAll(rdr); errGo == nil {
+		t.Fatal(kv.NewError("failed part was not reported").With("stack", stack.Trace().TrimRuntime()))
+	}
+}
+
+// TestTransferBudget checks that the runner wide transfer slots, and bandwidth limits
+// are being enforced
+func TestTransferBudget(t *testing.T) {
+
+	parts := TransferParts()
+	defer SetTransferLimits(8, parts, 0)
+
+	if err := SetTransferLimits(1, parts, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	release, err := AcquireTransfer(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With the single slot taken further requests should block until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = AcquireTransfer(ctx); err == nil {
+		t.Fatal(kv.NewError("transfer slot was available when the budget was exhausted").With("stack", stack.Trace().TrimRuntime()))
+	}
+	release()
+
+	// Reading 1.5MB in three parts at 1MB per second should take around a second as
+	// the last read must wait for the first two to be paid for
+	start := time.Now()
+	rdr := NewThrottledReader(context.Background(), bytes.NewReader(make([]byte, 1536*1024)))
+	buffer := make([]byte, 512*1024)
+	for {
+		if _, errGo := io.ReadFull(rdr, buffer); errGo != nil {
+			if errGo == io.EOF {
+				break
+			}
+			t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Fatal(kv.NewError("bandwidth was not limited").With("elapsed", elapsed.String()).With("stack", stack.Trace().TrimRuntime()))
+	}
+}
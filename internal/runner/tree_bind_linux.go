@@ -0,0 +1,30 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the read-only bind mounting of cached trees for Linux
+
+import (
+	"syscall"
+)
+
+// bindReadOnly mounts the src directory at dest so that its contents cannot be modified
+// through dest, this requires the runner to have the CAP_SYS_ADMIN capability
+//
+func bindReadOnly(src string, dest string) (errGo error) {
+	if errGo = syscall.Mount(src, dest, "", syscall.MS_BIND, ""); errGo != nil {
+		return errGo
+	}
+	// The read-only flag is ignored when a bind is first created and so is applied using a remount
+	if errGo = syscall.Mount(src, dest, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); errGo != nil {
+		syscall.Unmount(dest, syscall.MNT_DETACH)
+		return errGo
+	}
+	return nil
+}
+
+// unbind removes a bind mount created using bindReadOnly
+//
+func unbind(dest string) (errGo error) {
+	return syscall.Unmount(dest, syscall.MNT_DETACH)
+}
@@ -0,0 +1,24 @@
+// +build !linux
+
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the read-only bind mounting of cached trees for platforms where it is not supported
+
+import (
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// bindReadOnly is not supported on this platform and so always fails causing callers to clone trees
+//
+func bindReadOnly(src string, dest string) (errGo error) {
+	return kv.NewError("read-only binds unsupported").With("stack", stack.Trace().TrimRuntime())
+}
+
+// unbind is not supported on this platform, no binds are ever created
+//
+func unbind(dest string) (errGo error) {
+	return nil
+}
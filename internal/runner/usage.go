@@ -0,0 +1,188 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the implementation of resource usage accounting for experiments.  While an
+// experiment runs its process tree, GPUs, and storage are sampled and the results are summarized
+// so that what an experiment consumed can be compared with the resources it requested.
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// procRoot is the location of the proc file system used to measure processes, and to read
+	// the cores the runner may use
+	procRoot = "/proc"
+)
+
+// ExperimentUsage is a summary of the resources consumed by an experiment
+//
+type ExperimentUsage struct {
+	Requested     Resource  `json:"requested"`               // The resources requested by the experiment
+	Started       time.Time `json:"started"`                 // The time sampling started
+	Stopped       time.Time `json:"stopped"`                 // The time sampling stopped
+	Samples       uint      `json:"samples"`                 // The number of samples taken
+	PeakRSS       uint64    `json:"peak_rss_bytes"`          // The largest resident memory seen across the process tree
+	AvgRSS        uint64    `json:"avg_rss_bytes"`           // The average resident memory of the process tree
+	CPUSeconds    float64   `json:"cpu_seconds"`             // The user and system CPU time consumed by the process tree
+	GPUUtilAvg    float64   `json:"gpu_utilization_avg_pct"` // The average utilization of the allocated GPUs
+	GPUMemPeak    uint64    `json:"gpu_mem_peak_bytes"`      // The largest amount of memory in use on the allocated GPUs
+	DiskPeak      uint64    `json:"disk_peak_bytes"`         // The largest amount of local storage used
+	TransferBytes uint64    `json:"transfer_bytes"`          // The bytes moved to and from storage platforms for artifacts
+}
+
+// UsageTracker accumulates samples of the resources being consumed by a single experiment
+//
+type UsageTracker struct {
+	usage      ExperimentUsage
+	rssTotal   float64 // The sum of the RSS samples, used for the average
+	gpuTotal   float64 // The sum of the GPU utilization samples, used for the average
+	gpuSamples uint    // The number of samples that included GPU utilization
+	transfers  uint64  // Accessed atomically, the bytes transferred for artifacts
+	sync.Mutex
+}
+
+type usageKey struct{}
+
+// NewUsageTracker creates a tracker for an experiment that requested the resources supplied
+//
+func NewUsageTracker(requested Resource) (tracker *UsageTracker) {
+	return &UsageTracker{
+		usage: ExperimentUsage{
+			Requested: requested,
+		},
+	}
+}
+
+// WithUsageTracker returns a context that will cause artifact transfers performed using it
+// to be counted by the tracker
+//
+func WithUsageTracker(ctx context.Context, tracker *UsageTracker) (usageCtx context.Context) {
+	return context.WithValue(ctx, usageKey{}, tracker)
+}
+
+func usageFromContext(ctx context.Context) (tracker *UsageTracker) {
+	if ctx == nil {
+		return nil
+	}
+	tracker, _ = ctx.Value(usageKey{}).(*UsageTracker)
+	return tracker
+}
+
+func (tracker *UsageTracker) transferred(n int) {
+	atomic.AddUint64(&tracker.transfers, uint64(n))
+}
+
+// Run samples the process tree rooted at the pid returned by the pid function, the GPUs
+// allocated to the experiment, and the directories returned by dirs on a regular interval
+// until the context is cancelled
+//
+func (tracker *UsageTracker) Run(ctx context.Context, interval time.Duration, pid func() int, gpus GPUAllocations, dirs func() []string) {
+	check := time.NewTicker(interval)
+	defer check.Stop()
+
+	tracker.Lock()
+	if tracker.usage.Started.IsZero() {
+		tracker.usage.Started = time.Now()
+	}
+	tracker.Unlock()
+
+	for {
+		select {
+		case <-check.C:
+			tracker.Sample(pid(), gpus, dirs())
+		case <-ctx.Done():
+			tracker.Lock()
+			tracker.usage.Stopped = time.Now()
+			tracker.Unlock()
+			return
+		}
+	}
+}
+
+// Sample takes a single measurement of the resources being consumed
+//
+func (tracker *UsageTracker) Sample(pid int, gpus GPUAllocations, dirs []string) {
+	rss, cpuSeconds := uint64(0), float64(0)
+	if pid > 0 {
+		rss, cpuSeconds = processTreeUsage(pid)
+	}
+	util, gpuMem, hasGPU := gpuUsage(gpus)
+	disk, errDisk := DirUsage(dirs)
+
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	tracker.usage.Samples++
+	tracker.rssTotal += float64(rss)
+	tracker.usage.AvgRSS = uint64(tracker.rssTotal / float64(tracker.usage.Samples))
+	if rss > tracker.usage.PeakRSS {
+		tracker.usage.PeakRSS = rss
+	}
+	// Time consumed by processes that have exited and not been reaped within the tree is lost
+	// and so the largest value seen is retained
+	if cpuSeconds > tracker.usage.CPUSeconds {
+		tracker.usage.CPUSeconds = cpuSeconds
+	}
+	if hasGPU {
+		tracker.gpuSamples++
+		tracker.gpuTotal += util
+		tracker.usage.GPUUtilAvg = tracker.gpuTotal / float64(tracker.gpuSamples)
+		if gpuMem > tracker.usage.GPUMemPeak {
+			tracker.usage.GPUMemPeak = gpuMem
+		}
+	}
+	if errDisk == nil && disk > tracker.usage.DiskPeak {
+		tracker.usage.DiskPeak = disk
+	}
+}
+
+// Summary returns the usage recorded so far
+//
+func (tracker *UsageTracker) Summary() (usage ExperimentUsage) {
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	usage = tracker.usage
+	usage.TransferBytes = atomic.LoadUint64(&tracker.transfers)
+	return usage
+}
+
+// gpuUsage returns the average utilization and the total memory in use across the devices
+// in the allocations.  Devices that are shared with other experiments report their utilization
+// and memory for all of the experiments using them.
+//
+func gpuUsage(allocs GPUAllocations) (util float64, mem uint64, hasGPU bool) {
+	if len(allocs) == 0 {
+		return 0, 0, false
+	}
+	ensureGPUs()
+
+	devs, err := gpuSource.Inventory()
+	if err != nil {
+		return 0, 0, false
+	}
+
+	allocated := map[string]struct{}{}
+	for _, alloc := range allocs {
+		allocated[alloc.uuid] = struct{}{}
+	}
+
+	count := 0
+	for _, dev := range devs.Devices {
+		if _, isPresent := allocated[dev.UUID]; !isPresent {
+			continue
+		}
+		count++
+		util += float64(dev.Util)
+		mem += dev.MemUsed
+	}
+	if count == 0 {
+		return 0, 0, false
+	}
+	return util / float64(count), mem, true
+}
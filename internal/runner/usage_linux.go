@@ -0,0 +1,93 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the measurement of process trees using the Linux proc file system
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// clockTicks is the USER_HZ value used by the kernel when reporting process times, it is
+	// fixed at 100 on all mainstream architectures
+	clockTicks = 100
+)
+
+type procStat struct {
+	ppid  int
+	ticks uint64 // The user and system time of the process and its reaped children
+	rss   uint64 // The resident memory in bytes
+}
+
+// readProcStat parses the stat file for a process, the command name can contain spaces and
+// parenthesis so the fields are located after the last closing parenthesis
+//
+func readProcStat(pid int) (stat *procStat) {
+	data, errGo := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if errGo != nil {
+		return nil
+	}
+	text := string(data)
+	end := strings.LastIndex(text, ")")
+	if end < 0 {
+		return nil
+	}
+	// Fields after the command start with the state, which is field 3 of the stat file
+	fields := strings.Fields(text[end+1:])
+	if len(fields) < 22 {
+		return nil
+	}
+	field := func(n int) uint64 {
+		v, _ := strconv.ParseUint(fields[n-3], 10, 64)
+		return v
+	}
+	ppid, _ := strconv.Atoi(fields[4-3])
+	return &procStat{
+		ppid:  ppid,
+		ticks: field(14) + field(15) + field(16) + field(17),
+		rss:   field(24) * uint64(os.Getpagesize()),
+	}
+}
+
+// processTreeUsage returns the resident memory and CPU seconds consumed by a process
+// and all of its descendants
+//
+func processTreeUsage(root int) (rss uint64, cpuSeconds float64) {
+	entries, errGo := ioutil.ReadDir(procRoot)
+	if errGo != nil {
+		return 0, 0
+	}
+
+	stats := map[int]*procStat{}
+	children := map[int][]int{}
+	for _, entry := range entries {
+		pid, errGo := strconv.Atoi(entry.Name())
+		if errGo != nil {
+			continue
+		}
+		if stat := readProcStat(pid); stat != nil {
+			stats[pid] = stat
+			children[stat.ppid] = append(children[stat.ppid], pid)
+		}
+	}
+
+	ticks := uint64(0)
+	pending := []int{root}
+	for len(pending) != 0 {
+		pid := pending[0]
+		pending = pending[1:]
+		stat, isPresent := stats[pid]
+		if !isPresent {
+			continue
+		}
+		rss += stat.rss
+		ticks += stat.ticks
+		pending = append(pending, children[pid]...)
+	}
+	return rss, float64(ticks) / clockTicks
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+// This file contains the measurement of process trees for platforms where it is not supported
+
+// processTreeUsage is not supported and reports no usage
+//
+func processTreeUsage(root int) (rss uint64, cpuSeconds float64) {
+	return 0, 0
+}
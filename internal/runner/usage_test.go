@@ -0,0 +1,50 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. All rights reserved. Issued under the Apache 2.0 License.
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// TestUsageTracker checks that the process tree of the test is measured and that artifact
+// transfers using a context carrying the tracker are counted
+//
+func TestUsageTracker(t *testing.T) {
+	tracker := NewUsageTracker(Resource{Ram: "1gb"})
+
+	data := RandomString(128 * 1024)
+	ctx := WithUsageTracker(context.Background(), tracker)
+	if _, errGo := ioutil.ReadAll(NewThrottledReader(ctx, strings.NewReader(data))); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+	// Transfers without the tracker in their context are not counted
+	if _, errGo := ioutil.ReadAll(NewThrottledReader(context.Background(), bytes.NewReader([]byte(data)))); errGo != nil {
+		t.Fatal(kv.Wrap(errGo).With("stack", stack.Trace().TrimRuntime()))
+	}
+
+	tracker.Sample(os.Getpid(), GPUAllocations{}, []string{})
+	tracker.Sample(0, GPUAllocations{}, []string{})
+
+	usage := tracker.Summary()
+	if usage.TransferBytes != uint64(len(data)) {
+		t.Fatal(kv.NewError("transfer bytes unexpected").With("transferred", usage.TransferBytes, "expected", len(data)).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if usage.Samples != 2 || usage.Requested.Ram != "1gb" {
+		t.Fatal(kv.NewError("usage summary unexpected").With("usage", usage).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if usage.PeakRSS == 0 || usage.AvgRSS == 0 || usage.AvgRSS >= usage.PeakRSS {
+		t.Fatal(kv.NewError("resident memory unexpected").With("peak", usage.PeakRSS, "avg", usage.AvgRSS).With("stack", stack.Trace().TrimRuntime()))
+	}
+}
@@ -24,7 +24,9 @@ func init() {
 // as a receiver that has the logging methods
 //
 type Logger struct {
-	log logxi.Logger
+	log         logxi.Logger
+	captures    map[uint64]*capture // Destinations receiving copies of messages with specific labels
+	nextCapture uint64
 	sync.Mutex
 }
 
@@ -63,6 +65,7 @@ func (l *Logger) Debug(msg string, args ...interface{}) {
 
 	l.Lock()
 	defer l.Unlock()
+	l.capture(logxi.LevelDebug, msg, allArgs)
 	l.log.Debug(msg, allArgs)
 }
 
@@ -77,6 +80,7 @@ func (l *Logger) Info(msg string, args ...interface{}) {
 
 	l.Lock()
 	defer l.Unlock()
+	l.capture(logxi.LevelInfo, msg, allArgs)
 	l.log.Info(msg, allArgs)
 }
 
@@ -91,6 +95,7 @@ func (l *Logger) Warn(msg string, args ...interface{}) error {
 
 	l.Lock()
 	defer l.Unlock()
+	l.capture(logxi.LevelWarn, msg, allArgs)
 	return l.log.Warn(msg, allArgs)
 }
 
@@ -105,6 +110,7 @@ func (l *Logger) Error(msg string, args ...interface{}) error {
 
 	l.Lock()
 	defer l.Unlock()
+	l.capture(logxi.LevelError, msg, allArgs)
 	return l.log.Error(msg, allArgs)
 }
 
@@ -119,6 +125,7 @@ func (l *Logger) Fatal(msg string, args ...interface{}) {
 
 	l.Lock()
 	defer l.Unlock()
+	l.capture(logxi.LevelFatal, msg, allArgs)
 	l.log.Fatal(msg, allArgs)
 }
 
@@ -133,6 +140,7 @@ func (l *Logger) Log(level int, msg string, args []interface{}) {
 
 	l.Lock()
 	defer l.Unlock()
+	l.capture(level, msg, allArgs)
 	l.log.Log(level, msg, allArgs)
 }
 
@@ -0,0 +1,150 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. Issued under the Apache 2.0 License.
+
+package studio
+
+// This file contains the implementation of log captures.  A capture receives a copy of every
+// message, at debug level and above, that carries a specific label and value, for example
+// the messages logged about a single experiment, regardless of the level of messages being
+// output by the logger.
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jjeffery/kv" // MIT License
+	logxi "github.com/karlmutch/logxi/v1"
+)
+
+// redactedKeys are the labels whose values are omitted from captures, stack traces to keep
+// the lines readable, and experiment requests as they contain credentials and artifact keys
+var redactedKeys = map[string]struct{}{
+	"stack":   {},
+	"request": {},
+}
+
+type capture struct {
+	key   string
+	value string
+	w     io.Writer
+}
+
+// Capture starts copying messages that have a key label with the specified value to the
+// writer.  The returned function stops the capture and must be called before the writer
+// is closed.
+//
+func (l *Logger) Capture(key string, value string, w io.Writer) (stop func()) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.captures == nil {
+		l.captures = map[uint64]*capture{}
+	}
+	id := l.nextCapture
+	l.nextCapture++
+	l.captures[id] = &capture{key: key, value: value, w: w}
+
+	return func() {
+		l.Lock()
+		delete(l.captures, id)
+		l.Unlock()
+	}
+}
+
+// flattenArgs expands label and value lists that were passed as a single slice argument
+//
+func flattenArgs(args []interface{}) (flat []interface{}) {
+	flat = make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		if list, isList := arg.([]interface{}); isList {
+			flat = append(flat, flattenArgs(list)...)
+			continue
+		}
+		flat = append(flat, arg)
+	}
+	return flat
+}
+
+// capture writes the message to the captures with matching labels, the logger lock must be
+// held by the caller
+//
+func (l *Logger) capture(level int, msg string, args []interface{}) {
+	if len(l.captures) == 0 || level > logxi.LevelDebug {
+		return
+	}
+
+	args = flattenArgs(args)
+
+	line := ""
+	for _, c := range l.captures {
+		matched := false
+		for i := 0; i+1 < len(args); i += 2 {
+			if key, isString := args[i].(string); isString && key == c.key && fmt.Sprint(args[i+1]) == c.value {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		if len(line) == 0 {
+			line = formatCapture(level, msg, args)
+		}
+		_, _ = io.WriteString(c.w, line)
+	}
+}
+
+// redactValue removes redacted labels from values, such as the text of errors, that carry key
+// value pairs of their own
+//
+func redactValue(value string) (redacted string) {
+	text, list := kv.Parse([]byte(value))
+	kept := make(kv.List, 0, len(list))
+	for i := 0; i+1 < len(list); i += 2 {
+		if _, isRedacted := redactedKeys[fmt.Sprint(list[i])]; !isRedacted {
+			kept = append(kept, list[i], list[i+1])
+		}
+	}
+	switch {
+	case len(kept) == len(list):
+		return value
+	case len(kept) == 0:
+		return string(text)
+	case len(text) == 0:
+		return kept.String()
+	}
+	return string(text) + " " + kept.String()
+}
+
+// formatCapture renders a message as a single line of text, the values of redacted labels are
+// omitted including those within the text of errors
+//
+func formatCapture(level int, msg string, args []interface{}) (line string) {
+	name, isPresent := logxi.LevelMap[level]
+	if !isPresent {
+		name = fmt.Sprint(level)
+	}
+
+	b := strings.Builder{}
+	b.WriteString(time.Now().UTC().Format(time.RFC3339))
+	b.WriteString(" ")
+	b.WriteString(name)
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		key := fmt.Sprint(args[i])
+		if _, isRedacted := redactedKeys[key]; isRedacted {
+			continue
+		}
+		b.WriteString(" ")
+		b.WriteString(key)
+		b.WriteString("=")
+		if i+1 < len(args) {
+			b.WriteString(fmt.Sprintf("%q", redactValue(fmt.Sprint(args[i+1]))))
+		}
+	}
+	b.WriteString("\n")
+	return b.String()
+}
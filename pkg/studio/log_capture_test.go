@@ -0,0 +1,64 @@
+// Copyright 2018-2020 (c) Cognizant Digital Business, Evolutionary AI. Issued under the Apache 2.0 License.
+
+package studio
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-stack/stack"
+	"github.com/jjeffery/kv" // MIT License
+)
+
+// TestLogCapture checks that only messages with the captured label are copied to the capture
+// and that they stop being copied once the capture is stopped
+//
+func TestLogCapture(t *testing.T) {
+	logger := NewLogger("capture-test")
+	logger.SetLevel(-1000)
+
+	buf := &bytes.Buffer{}
+	stop := logger.Capture("experiment_id", "experiment-1", buf)
+
+	logger.Debug("allocated", "experiment_id", "experiment-1", "stack", stack.Trace().TrimRuntime())
+	logger.Info("other experiment", "experiment_id", "experiment-2")
+	logger.Warn("fetch failed", []interface{}{"group", "workspace", "experiment_id", "experiment-1"})
+	logger.Trace("too detailed", "experiment_id", "experiment-1")
+
+	stop()
+	logger.Info("stopped", "experiment_id", "experiment-1")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal(kv.NewError("unexpected captured messages").With("captured", buf.String()).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if !strings.Contains(lines[0], " DBG allocated experiment_id=\"experiment-1\" host=") || strings.Contains(lines[0], "stack") {
+		t.Fatal(kv.NewError("debug message not captured").With("line", lines[0]).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if !strings.Contains(lines[1], " WRN fetch failed group=\"workspace\"") {
+		t.Fatal(kv.NewError("warning not captured").With("line", lines[1]).With("stack", stack.Trace().TrimRuntime()))
+	}
+}
+
+// TestLogCaptureRedaction checks that experiment requests are omitted from captures, including
+// requests attached to errors whose text is logged
+//
+func TestLogCaptureRedaction(t *testing.T) {
+	logger := NewLogger("capture-test")
+
+	buf := &bytes.Buffer{}
+	stop := logger.Capture("experiment_id", "experiment-1", buf)
+
+	err := kv.NewError("elapsed limit has expired").With("request", "artifact_key=secret").With("limit", "1h").With("stack", stack.Trace().TrimRuntime())
+	logger.Warn("experiment failed", "experiment_id", "experiment-1", "request", "secret", "error", err.Error())
+	stop()
+
+	line := buf.String()
+	if strings.Contains(line, "secret") || strings.Contains(line, "stack") {
+		t.Fatal(kv.NewError("request captured").With("line", line).With("stack", stack.Trace().TrimRuntime()))
+	}
+	if !strings.Contains(line, "elapsed limit has expired") || !strings.Contains(line, "limit=1h") {
+		t.Fatal(kv.NewError("error not captured").With("line", line).With("stack", stack.Trace().TrimRuntime()))
+	}
+}